@@ -1,19 +1,52 @@
 package cmd
 
 import (
-	"runtime"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"code.cloudfoundry.org/bytefmt"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/vfoucault/goPhoto/pkg/config"
 	"github.com/vfoucault/goPhoto/pkg/photo"
+	"github.com/vfoucault/goPhoto/pkg/progress"
+	"github.com/vfoucault/goPhoto/pkg/throttle"
 )
 
 var (
-	srcDirectory   string
-	dstDirectory   string
-	dstFileFormat  string
-	copyNoRecurse  bool
-	copyNumWorkers int
+	srcDirectory    string
+	dstDirectory    string
+	dstFileFormat   string
+	copyNoRecurse   bool
+	copyNumWorkers  int
+	copyWatch       bool
+	copyWatchSettle time.Duration
+	copyManifest    string
+	copyBagIt       bool
+	copyBagInfo     map[string]string
+	copyReport      string
+	copyReportFmt   string
+	copyProgress    string
+	copyProgressInt time.Duration
+	copyPreflight   string
+	copyReadLimit   string
+	copyWriteLimit  string
+	copyIOClass     string
+	copyNice        int
+	copyReadWorkers int
+	copyDevReaders  int
+	copyAutoTune    bool
+	copySimilarHash string
+	copySimilarMax  int
+	copySets        string
+	copySetGap      time.Duration
+	copyEventGap    time.Duration
+	copyEventDist   float64
+	copyPlaces      string
 )
 
 // cmdAwsDelete delete ACM certificates
@@ -23,27 +56,142 @@ var cmdCopyPhoto = &cobra.Command{
 	Long:    "Copy photos from source to destination",
 	Example: ``,
 	Args:    cobra.MinimumNArgs(0),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		readLimit, err := parseRate(copyReadLimit)
+		if err != nil {
+			return err
+		}
+		writeLimit, err := parseRate(copyWriteLimit)
+		if err != nil {
+			return err
+		}
 		cfg := &config.Config{
-			DestFileFormat:  dstFileFormat,
-			DestDirectory:   dstDirectory,
-			SourceDirectory: srcDirectory,
-			NoRecurse:       copyNoRecurse,
-			Workers:         copyNumWorkers,
+			DestFileFormat:   dstFileFormat,
+			DestDirectory:    dstDirectory,
+			SourceDirectory:  srcDirectory,
+			NoRecurse:        copyNoRecurse,
+			Workers:          copyNumWorkers,
+			Watch:            copyWatch,
+			WatchSettle:      copyWatchSettle,
+			ManifestPath:     copyManifest,
+			BagIt:            copyBagIt,
+			BagInfo:          copyBagInfo,
+			ReportPath:       copyReport,
+			ReportFormat:     copyReportFmt,
+			Preflight:        copyPreflight,
+			ReadLimit:        readLimit,
+			WriteLimit:       writeLimit,
+			IOClass:          copyIOClass,
+			Nice:             copyNice,
+			ReadWorkers:      copyReadWorkers,
+			DeviceReaders:    copyDevReaders,
+			AutoTune:         copyAutoTune,
+			SimilarHash:      copySimilarHash,
+			SimilarThreshold: copySimilarMax,
+			Sets:             copySets,
+			SetGap:           copySetGap,
+			EventGap:         copyEventGap,
+			EventDistance:    copyEventDist,
+			PlacesPath:       copyPlaces,
+		}
+		if err := throttle.SetPriority(cfg.IOClass, cfg.Nice); err != nil {
+			return err
 		}
-		photo.RunCopier(cfg)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		renderer, err := progress.NewRenderer(copyProgress, copyProgressInt)
+		if err != nil {
+			return err
+		}
+		return runCopy(ctx, cfg, renderer)
 	},
 }
 
+// parseRate parses a byte rate such as 20M, an empty rate is unlimited
+func parseRate(s string) (int64, error) {
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	bytes, err := bytefmt.ToBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %v. err=%w", s, err)
+	}
+	return int64(bytes), nil
+}
+
+// runCopy runs photo.Copy, renders its progress and logs a summary
+func runCopy(ctx context.Context, cfg *config.Config, renderer progress.Renderer) error {
+	cfg.PrintConfig()
+
+	prog := progress.New(renderer)
+	result, err := photo.Copy(ctx, photo.Options{
+		Config: cfg,
+		OnEvent: func(e photo.Event) {
+			switch e.Type {
+			case photo.EventStarted:
+				prog.AddTotal(e.Total, e.TotalBytes)
+			case photo.EventQueued:
+				prog.AddTotal(1, e.Size)
+			default:
+				f := progress.File{Path: e.Source, Action: string(e.Type), Size: e.Size, Worker: e.Worker}
+				if e.Err != nil {
+					f.Error = e.Err.Error()
+				}
+				prog.Done(f)
+			}
+		},
+	})
+	prog.Finish()
+	if result != nil {
+		log.Infof("Copy ended. Took %v", result.Duration)
+		log.Infof("Copied %d images / %s.", result.Copied, bytefmt.ByteSize(uint64(result.Bytes)))
+		if result.Skipped > 0 {
+			log.Infof("Skipped %d images that were duplicates", result.Skipped)
+		}
+		if result.Failed > 0 {
+			log.Errorf("Failed to copy %d images", result.Failed)
+		}
+		if result.WalkErrors > 0 {
+			log.Errorf("Failed to list %d source paths", result.WalkErrors)
+		}
+		log.Infof("Byte rate %v/s", bytefmt.ByteSize(uint64(float64(result.Bytes)/result.Duration.Seconds())))
+	}
+	return err
+}
+
 func copyInit() {
 
 	cmdCopyPhoto.PersistentFlags().StringVarP(&srcDirectory, "src", "s", ".", "Source directory")
 	cmdCopyPhoto.PersistentFlags().StringVarP(&dstDirectory, "dst", "d", ".", "Destination directory")
 	cmdCopyPhoto.MarkPersistentFlagRequired("src")
 	cmdCopyPhoto.MarkPersistentFlagRequired("dst")
-	cmdCopyPhoto.PersistentFlags().StringVarP(&dstFileFormat, "format", "", "2006/2006-01-02", "Destination directory format")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&dstFileFormat, "format", "", "2006/2006-01-02", "Destination directory format, a Go time layout where {event} is replaced by the event name, e.g. 2006/{event}")
+	cmdCopyPhoto.PersistentFlags().DurationVarP(&copyEventGap, "event-gap", "", 4*time.Hour, "Pause between two photos starting a new {event}")
+	cmdCopyPhoto.PersistentFlags().Float64VarP(&copyEventDist, "event-distance", "", 50, "Distance in km between two geotagged photos starting a new {event}, 0 to ignore locations")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyPlaces, "places", "", "", "CSV file of name,latitude,longitude[,radius_km] lines naming the {event} directories")
 	cmdCopyPhoto.PersistentFlags().BoolVarP(&copyNoRecurse, "no-recurse", "", false, "Don't search recursively for photos")
-	cmdCopyPhoto.PersistentFlags().IntVarP(&copyNumWorkers, "num-workers", "", runtime.NumCPU(), "number of workers. Default to runtime.NumCPU()")
+	cmdCopyPhoto.PersistentFlags().IntVarP(&copyNumWorkers, "num-workers", "", 4, "Number of writers copying to the destination, the maximum with --auto-tune")
+	cmdCopyPhoto.PersistentFlags().IntVarP(&copyReadWorkers, "read-workers", "", 4, "Number of readers extracting metadata and hashes from the source")
+	cmdCopyPhoto.PersistentFlags().IntVarP(&copyDevReaders, "device-readers", "", 2, "Maximum concurrent reads per source device, 0 for unlimited")
+	cmdCopyPhoto.PersistentFlags().BoolVarP(&copyAutoTune, "auto-tune", "", false, "Vary the number of writers with the observed byte rate")
+	cmdCopyPhoto.PersistentFlags().BoolVarP(&copyWatch, "watch", "w", false, "Keep running and copy new photos as they appear in the source directory")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyManifest, "manifest", "m", "", "Write a sha256sum compatible manifest of the imported files, relative to the destination. Existing manifests are updated")
+	cmdCopyPhoto.PersistentFlags().BoolVarP(&copyBagIt, "bagit", "", false, "Store photos as a BagIt bag: payload in <dst>/data, manifests and bag-info.txt in <dst>")
+	cmdCopyPhoto.PersistentFlags().StringToStringVarP(&copyBagInfo, "bag-info", "", nil, "Additional bag-info.txt fields, e.g. Source-Organization=Studio")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyReport, "report", "", "", "Write a per-file import report to this file")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyReportFmt, "report-format", "", "", "Report format: json (JSON lines) or csv. Default from the report file extension")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyPreflight, "preflight", "", config.PreflightAbort, "Free space and permission checks before copying: abort, warn or off")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyReadLimit, "read-limit", "", "", "Maximum read rate of all workers per second, e.g. 20M. Unlimited by default")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyWriteLimit, "write-limit", "", "", "Maximum write rate of all workers per second, e.g. 20M. Unlimited by default")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyIOClass, "io-class", "", "", "I/O scheduling class: idle, best-effort[:0-7] or realtime[:0-7] (linux only)")
+	cmdCopyPhoto.PersistentFlags().IntVarP(&copyNice, "nice", "", 0, "Nice value added to the current niceness, like nice -n (linux only)")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copySimilarHash, "flag-similar", "", "", "Flag the photos looking like another one with this perceptual hash: ahash, dhash or phash. Disabled by default")
+	cmdCopyPhoto.PersistentFlags().IntVarP(&copySimilarMax, "similar-threshold", "", 10, "Maximum number of different bits between similar perceptual hashes, out of 64")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copySets, "sets", "", "", "Group bursts and brackets: subdir puts each set in its own directory, sidecar tags the photos in XMP sidecars. Disabled by default")
+	cmdCopyPhoto.PersistentFlags().DurationVarP(&copySetGap, "set-gap", "", time.Second, "Maximum time between two photos of a burst or bracket")
+	cmdCopyPhoto.PersistentFlags().StringVarP(&copyProgress, "progress", "", progress.ModeAuto, "Progress output: auto, bar, log, json (on stdout) or none. auto is a bar on a terminal, log lines otherwise")
+	cmdCopyPhoto.PersistentFlags().DurationVarP(&copyProgressInt, "progress-interval", "", 10*time.Second, "Interval between progress log lines")
+	cmdCopyPhoto.PersistentFlags().DurationVarP(&copyWatchSettle, "watch-settle", "", 2*time.Second, "Time a new file must stay unchanged before being copied")
 
 	rootCmd.AddCommand(cmdCopyPhoto)
 
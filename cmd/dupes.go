@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"code.cloudfoundry.org/bytefmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/vfoucault/goPhoto/pkg/dupes"
+	"github.com/vfoucault/goPhoto/pkg/phash"
+)
+
+var (
+	dupesAction     string
+	dupesKeep       string
+	dupesQuarantine string
+	dupesDryRun     bool
+	dupesNumWorkers int
+	dupesReport     string
+	dupesSimilar    bool
+	dupesHash       string
+	dupesThreshold  int
+	dupesForce      bool
+)
+
+var cmdDupes = &cobra.Command{
+	Use:   "dupes <dir>...",
+	Short: "Find duplicate photos",
+	Long: `Hash the photos of one or more directories and group the byte-identical ones.
+In each group the copy to keep is chosen with the --keep rules, applied in order:
+
+  prefer:<dir>  keep the copies under dir
+  avoid:<dir>   don't keep the copies under dir
+  shortest      keep the shortest path
+  longest       keep the longest path
+  oldest        keep the oldest modification time
+  newest        keep the newest modification time
+
+The other copies are then reported, deleted, replaced with hard links to the kept copy,
+or moved to a quarantine directory.
+
+With --similar, photos whose perceptual hashes differ by at most --threshold bits are grouped
+as well, catching resized, re-encoded or forwarded copies. The largest copy is then kept by
+default, and only the copies within --threshold of it are listed. Copies that are not identical
+to the kept one are never hard linked, and only deleted or moved with --force-similar.`,
+	Example: `  photo-copier dupes /srv/photos /media/backup
+  photo-copier dupes /srv/photos --keep prefer:/srv/photos/2022,oldest --action move --quarantine /srv/dupes
+  photo-copier dupes /srv/photos --similar --hash phash --threshold 8`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if dupesSimilar && !cmd.Flags().Changed("keep") {
+			dupesKeep = "largest,shortest"
+		}
+		rules, err := dupes.ParseRules(dupesKeep)
+		if err != nil {
+			return err
+		}
+		applier := &dupes.Applier{Action: dupesAction, Quarantine: dupesQuarantine, DryRun: dupesDryRun, ForceSimilar: dupesForce}
+		if err := applier.Validate(); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		var groups []*dupes.Group
+		var findErr error
+		if dupesSimilar {
+			groups, findErr = dupes.FindSimilar(ctx, args, dupesNumWorkers, dupesHash, dupesThreshold)
+		} else {
+			groups, findErr = dupes.Find(ctx, args, dupesNumWorkers)
+		}
+		if groups == nil && findErr != nil {
+			return findErr
+		}
+		dupes.Sort(groups, rules)
+		groups = dupes.DropSameFiles(groups)
+
+		var extras int
+		var wasted int64
+		for _, g := range groups {
+			extras += len(g.Extras())
+			wasted += g.Wasted()
+		}
+		// the JSON report replaces the listing on stdout
+		if dupesReport != "-" {
+			printDupes(groups)
+		}
+		log.Infof("Found %d duplicated photos with %d extra copies using %s", len(groups), extras, bytefmt.ByteSize(uint64(wasted)))
+
+		if dupesReport != "" {
+			if err := writeDupesReport(dupesReport, groups); err != nil {
+				return err
+			}
+		}
+		if err := applier.Apply(groups); err != nil {
+			return err
+		}
+		return findErr
+	},
+}
+
+func printDupes(groups []*dupes.Group) {
+	for _, g := range groups {
+		if g.Similar {
+			fmt.Printf("similar\n")
+		} else {
+			fmt.Printf("%s %s\n", g.Sha256, bytefmt.ByteSize(uint64(g.Keep().Size)))
+		}
+		fmt.Printf("  keep  %s\n", g.Keep().Path)
+		for _, f := range g.Extras() {
+			fmt.Printf("  extra %s\n", f.Path)
+		}
+	}
+}
+
+func writeDupesReport(reportPath string, groups []*dupes.Group) error {
+	out := os.Stdout
+	if reportPath != "-" {
+		f, err := os.Create(reportPath)
+		if err != nil {
+			return fmt.Errorf("unable to create report %v. err=%w", reportPath, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(groups); err != nil {
+		return fmt.Errorf("unable to write report. err=%w", err)
+	}
+	return nil
+}
+
+func dupesInit() {
+
+	cmdDupes.PersistentFlags().StringVarP(&dupesAction, "action", "", dupes.ActionReport, "What to do with the extra copies: report, delete, hardlink or move")
+	cmdDupes.PersistentFlags().StringVarP(&dupesKeep, "keep", "", "shortest", "Comma separated rules choosing the copy to keep. Default to largest,shortest with --similar")
+	cmdDupes.PersistentFlags().BoolVarP(&dupesSimilar, "similar", "", false, "Group perceptually similar photos as well")
+	cmdDupes.PersistentFlags().StringVarP(&dupesHash, "hash", "", phash.PHash, "Perceptual hash used by --similar: ahash, dhash or phash")
+	cmdDupes.PersistentFlags().IntVarP(&dupesThreshold, "threshold", "", 10, "Maximum number of different bits between similar perceptual hashes, out of 64")
+	cmdDupes.PersistentFlags().BoolVarP(&dupesForce, "force-similar", "", false, "Delete or move the similar copies that are not identical to the kept one too")
+	cmdDupes.PersistentFlags().StringVarP(&dupesQuarantine, "quarantine", "", "", "Directory the extra copies are moved to with --action move")
+	cmdDupes.PersistentFlags().BoolVarP(&dupesDryRun, "dry-run", "n", false, "Only log what the action would do")
+	cmdDupes.PersistentFlags().IntVarP(&dupesNumWorkers, "num-workers", "", 4, "Number of readers hashing the photos")
+	cmdDupes.PersistentFlags().StringVarP(&dupesReport, "report", "", "", "Write the groups as JSON to this file, - for stdout")
+
+	rootCmd.AddCommand(cmdDupes)
+
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/ingest"
+)
+
+var (
+	ingestDstDirectory  string
+	ingestDstFileFormat string
+	ingestNumWorkers    int
+	ingestReadWorkers   int
+	ingestDevReaders    int
+	ingestPollInterval  time.Duration
+	ingestStateFile     string
+)
+
+var cmdIngestDaemon = &cobra.Command{
+	Use:   "ingest-daemon",
+	Short: "Import memory cards as they are mounted",
+	Long: `Watch the mount table and copy the DCIM directory of every newly mounted memory card.
+Cards are identified by volume UUID (or label) and only imported once.
+Per-card profiles can be set in the configuration file under ingest.profiles:
+
+ingest:
+  profiles:
+    - name: studio
+      uuid: 1234-ABCD
+      dest_directory: /srv/photos/studio
+      dest_file_format: 2006/2006-01-02`,
+	Example: ``,
+	Args:    cobra.MinimumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var profiles []config.CardProfile
+		if err := viper.UnmarshalKey("ingest.profiles", &profiles); err != nil {
+			return fmt.Errorf("unable to read ingest profiles. err=%w", err)
+		}
+		if ingestStateFile == "" {
+			statePath, err := ingest.DefaultStatePath()
+			if err != nil {
+				return err
+			}
+			ingestStateFile = statePath
+		}
+		state, err := ingest.LoadState(ingestStateFile)
+		if err != nil {
+			return err
+		}
+		cfg := &config.Config{
+			DestFileFormat: ingestDstFileFormat,
+			DestDirectory:  ingestDstDirectory,
+			Workers:        ingestNumWorkers,
+			ReadWorkers:    ingestReadWorkers,
+			DeviceReaders:  ingestDevReaders,
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		daemon := ingest.NewDaemon(cfg, profiles, state, ingestPollInterval)
+		return daemon.Run(ctx)
+	},
+}
+
+func ingestInit() {
+
+	cmdIngestDaemon.PersistentFlags().StringVarP(&ingestDstDirectory, "dst", "d", ".", "Default destination directory")
+	cmdIngestDaemon.MarkPersistentFlagRequired("dst")
+	cmdIngestDaemon.PersistentFlags().StringVarP(&ingestDstFileFormat, "format", "", "2006/2006-01-02", "Default destination directory format")
+	cmdIngestDaemon.PersistentFlags().IntVarP(&ingestNumWorkers, "num-workers", "", 4, "Number of writers copying to the destination")
+	cmdIngestDaemon.PersistentFlags().IntVarP(&ingestReadWorkers, "read-workers", "", 4, "Number of readers extracting metadata and hashes from the cards")
+	cmdIngestDaemon.PersistentFlags().IntVarP(&ingestDevReaders, "device-readers", "", 2, "Maximum concurrent reads per card, 0 for unlimited")
+	cmdIngestDaemon.PersistentFlags().DurationVarP(&ingestPollInterval, "poll-interval", "", 2*time.Second, "Mount table polling interval")
+	cmdIngestDaemon.PersistentFlags().StringVarP(&ingestStateFile, "state-file", "", "", "File recording imported cards. Default to <user config dir>/photo-copier/ingested.json")
+
+	rootCmd.AddCommand(cmdIngestDaemon)
+
+}
@@ -1,13 +1,21 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"image/color"
+	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
-	"strings"
+	"syscall"
 
-	log "github.com/sirupsen/logrus"
+	"code.cloudfoundry.org/bytefmt"
 	"github.com/spf13/cobra"
+	"github.com/vfoucault/goPhoto/pkg/engine"
+	"github.com/vfoucault/goPhoto/pkg/metadata"
 	"github.com/vfoucault/goPhoto/pkg/resize"
+	"github.com/vfoucault/goPhoto/pkg/utils"
 	"github.com/vfoucault/goPhoto/pkg/watermark"
 )
 
@@ -18,6 +26,22 @@ var (
 	resizeWatermarkColor string
 	resizeWatermarkSize  float64
 	resizeSize           string
+	resizeLayout         string
+	resizeMode           string
+	resizeBackground     string
+	resizeFormat         string
+	resizeFormatOptions  string
+	resizeStrip          string
+	resizeOutput         string
+	resizeFlatten        bool
+	resizeIncremental    bool
+	resizePrune          bool
+	resizeMaxBytes       string
+	resizeFilter         string
+	resizeLinear         bool
+	resizeSharpen        string
+	resizeNumWorkers     int
+	resizeMaxMemory      string
 )
 
 var cmdResize = &cobra.Command{
@@ -26,20 +50,37 @@ var cmdResize = &cobra.Command{
 	Long:    "Resize photos from source to destination",
 	Example: ``,
 	Args:    cobra.MinimumNArgs(0),
-	Run: func(cmd *cobra.Command, args []string) {
-		// define target size it not
-		sizeList := strings.Split(resizeSize, "x")
-		if len(sizeList) != 2 {
-			log.Errorf("unable to parse %s as size if format XxY", resizeSize)
-			return
+	RunE: func(cmd *cobra.Command, args []string) error {
+		renditions, err := resize.ParseRenditions(resizeSize)
+		if err != nil {
+			return err
+		}
+		background, err := resize.ParseColor(resizeBackground)
+		if err != nil {
+			return err
+		}
+		strip, err := metadata.ParseStrip(resizeStrip)
+		if err != nil {
+			return err
+		}
+		if resizePrune && !resizeIncremental {
+			return fmt.Errorf("--prune needs --incremental")
+		}
+		format, err := utils.ParseFormat(resizeFormat, resizeFormatOptions)
+		if err != nil {
+			return err
 		}
-		w, err := strconv.ParseUint(sizeList[0], 10, 32)
+		maxBytes, err := parseSize(resizeMaxBytes)
 		if err != nil {
-			log.Errorf("unable to parse width %s. err=%v", sizeList[0], err.Error())
+			return err
 		}
-		h, err := strconv.ParseUint(sizeList[1], 10, 32)
+		sharpen, err := resize.ParseSharpen(resizeSharpen)
 		if err != nil {
-			log.Errorf("unable to parse width %s. err=%v", sizeList[0], err.Error())
+			return err
+		}
+		maxMemory, err := parseSize(resizeMaxMemory)
+		if err != nil {
+			return err
 		}
 
 		// Watermark
@@ -50,13 +91,29 @@ var cmdResize = &cobra.Command{
 		case "black":
 			wm.Color = color.Black
 		default:
-			log.Errorf("unable to process color %s. only white and black", resizeWatermarkColor)
+			return fmt.Errorf("unable to process color %s. only white and black", resizeWatermarkColor)
 		}
 
-		err = resize.PhotoResize(uint(w), uint(h), resizeSrcDirectory, resizeDstDirectory, len(resizeWatermarkText) > 0, wm)
-		if err != nil {
-			log.Errorf("unable to start image resize processing. err=%v", err.Error())
-		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return resize.PhotoResize(ctx, resize.Options{
+			Renditions:  renditions,
+			Layout:      resizeLayout,
+			Mode:        resizeMode,
+			Background:  background,
+			Strip:       strip,
+			Format:      format,
+			MaxBytes:    maxBytes,
+			Filter:      resizeFilter,
+			Linear:      resizeLinear,
+			Sharpen:     sharpen,
+			Template:    resizeOutput,
+			Flatten:     resizeFlatten,
+			Incremental: resizeIncremental,
+			Prune:       resizePrune,
+			Engine:      engine.Options{Workers: resizeNumWorkers, MaxMemory: maxMemory},
+		}, resizeSrcDirectory, resizeDstDirectory, len(resizeWatermarkText) > 0, wm)
 	},
 }
 
@@ -66,7 +123,23 @@ func resizeInit() {
 	cmdResize.PersistentFlags().StringVarP(&resizeDstDirectory, "dst", "d", ".", "Destination directory")
 	cmdResize.MarkPersistentFlagRequired("src")
 	cmdResize.MarkPersistentFlagRequired("dst")
-	cmdResize.PersistentFlags().StringVarP(&resizeSize, "size", "r", "1600x1064", "target size")
+	cmdResize.PersistentFlags().StringVarP(&resizeSize, "size", "r", "1600x1064", "target size, or comma separated named sizes e.g. thumb:320x320,web:1600x1064")
+	cmdResize.PersistentFlags().StringVarP(&resizeLayout, "layout", "", utils.LayoutDir, "Output of the named sizes: dir (one subdirectory each) or suffix (<name>_<size>.<ext>)")
+	cmdResize.PersistentFlags().StringVarP(&resizeMode, "mode", "", resize.ModeFit, "Resize mode: fit, fill (center crop), pad, exact, long-edge or short-edge. A 0 width or height keeps the aspect ratio")
+	cmdResize.PersistentFlags().StringVarP(&resizeBackground, "background", "", "white", "Padding color of the pad mode: white, black or #rrggbb")
+	cmdResize.PersistentFlags().StringVarP(&resizeFormat, "format", "", utils.FormatAuto, "Output format: auto (same as the source), jpeg, png, gif, tiff or webp")
+	cmdResize.PersistentFlags().StringVarP(&resizeFormatOptions, "format-options", "", "", "Comma separated encoder options: quality=1..100 (jpeg, webp), subsampling=444|422|420 and progressive (jpeg), lossless (webp), compression=default|fast|best|none (png) or deflate|none (tiff), colors=1..256 (gif)")
+	cmdResize.PersistentFlags().StringVarP(&resizeFilter, "filter", "", resize.DefaultFilter, "Resampling filter: nearest, bilinear, bicubic, mitchell, lanczos2 or lanczos3")
+	cmdResize.PersistentFlags().BoolVarP(&resizeLinear, "linear", "", false, "Resample in linear light, keeps fine detail from darkening when downsampling")
+	cmdResize.PersistentFlags().StringVarP(&resizeSharpen, "sharpen", "", "", "Unsharp mask applied after resizing, e.g. amount=0.5,radius=1,threshold=2")
+	cmdResize.PersistentFlags().StringVarP(&resizeMaxBytes, "max-bytes", "", "", "Largest output size, e.g. 500000 or 500K. JPEG or WebP quality is lowered, then the photo shrunk, to fit")
+	cmdResize.PersistentFlags().StringVarP(&resizeOutput, "output", "", "", "Output file name template below the destination, with {name}, {ext}, {dir} (source subdirectory), {rendition}, {w} and {h}, e.g. {rendition}/{dir}/{name}")
+	cmdResize.PersistentFlags().BoolVarP(&resizeFlatten, "flatten", "", false, "Write every output to the destination directory instead of mirroring the source tree")
+	cmdResize.PersistentFlags().BoolVarP(&resizeIncremental, "incremental", "", false, "Skip the outputs whose source and settings did not change since the last incremental run")
+	cmdResize.PersistentFlags().BoolVarP(&resizePrune, "prune", "", false, "With --incremental, remove the outputs of deleted sources")
+	cmdResize.PersistentFlags().IntVarP(&resizeNumWorkers, "num-workers", "", runtime.NumCPU(), "Number of photos processed at once")
+	cmdResize.PersistentFlags().StringVarP(&resizeMaxMemory, "max-memory", "", "2G", "Memory budget of the photos processed at once, e.g. 512M, 0 for no limit. A larger photo is processed alone")
+	cmdResize.PersistentFlags().StringVarP(&resizeStrip, "strip", "", "", "Metadata not copied to the outputs: comma separated gps, serial, exif, xmp, iptc or all")
 	cmdResize.PersistentFlags().StringVarP(&resizeWatermarkText, "watermark", "", "", "Watermark text")
 	cmdResize.PersistentFlags().StringVarP(&resizeWatermarkColor, "watermark-color", "", "white", "Watermark color")
 	cmdResize.PersistentFlags().Float64VarP(&resizeWatermarkSize, "watermark-size", "", 25, "Watermark color (black / white)")
@@ -74,3 +147,18 @@ func resizeInit() {
 	rootCmd.AddCommand(cmdResize)
 
 }
+
+// parseSize parses a number of bytes, with or without unit
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	bytes, err := bytefmt.ToBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %v. err=%w", s, err)
+	}
+	return int64(bytes), nil
+}
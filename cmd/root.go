@@ -1,12 +1,23 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// Exit codes returned by the application
+const (
+	ExitOK = iota
+	// ExitFatal means the command could not run or was interrupted
+	ExitFatal
+	// ExitPartial means the command went through but failed on some files
+	ExitPartial
 )
 
 var (
@@ -17,16 +28,40 @@ var (
 )
 
 // rootCmd represents the base command when called without any subcommands
-var rootCmd = &cobra.Command{Use: "photo-copier", Version: fmt.Sprintf("%s / %s", version, commit)}
+var rootCmd = &cobra.Command{
+	Use:           "photo-copier",
+	Version:       fmt.Sprintf("%s / %s", version, commit),
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	setupCmd()
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	var partial *utils.PartialError
+	if errors.As(err, &partial) {
+		log.Errorf("%v:", err)
+		for _, f := range partial.Failed {
+			log.Errorf(" * %v", f)
+		}
+	} else if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
 	}
+	os.Exit(exitCode(err))
+}
+
+// exitCode maps the error of a command to the exit code of the application
+func exitCode(err error) int {
+	var partial *utils.PartialError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &partial):
+		return ExitPartial
+	}
+	return ExitFatal
 }
 
 func setupCmd() {
@@ -35,9 +70,12 @@ func setupCmd() {
 	copyInit()
 	resizeInit()
 	watermarkInit()
+	ingestInit()
+	verifyInit()
+	dupesInit()
 
 	cmdCopyPhoto.PersistentFlags().BoolVarP(&verbose, "verbose", "", false, "verbose output")
-	cmdCopyPhoto.PersistentFlags().StringVarP(&cfgFile, "config", "", "", "override configuration file")
+	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "", "", "override configuration file")
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+func TestExitCode(t *testing.T) {
+	partial := &utils.PartialError{Total: 2, Failed: []*utils.FileError{{Path: "a.jpg", Err: errors.New("unreadable")}}}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"ok", nil, ExitOK},
+		{"partial", partial, ExitPartial},
+		{"wrapped partial", fmt.Errorf("unable to verify. err=%w", partial), ExitPartial},
+		{"fatal", errors.New("unknown format"), ExitFatal},
+		{"interrupted", context.Canceled, ExitFatal},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("%v: exitCode(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/manifest"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+	"github.com/vfoucault/goPhoto/pkg/verify"
+)
+
+var (
+	verifySrcDirectory  string
+	verifyDstDirectory  string
+	verifyDstFileFormat string
+	verifyManifest      string
+	verifyNumWorkers    int
+	verifyReport        string
+	verifySets          string
+	verifySetGap        time.Duration
+	verifyEventGap      time.Duration
+	verifyEventDist     float64
+	verifyPlaces        string
+)
+
+var cmdVerify = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the destination library",
+	Long: `Re-hash every file of the destination and compare it against a checksum manifest
+(md5sum or sha256sum format, paths relative to the destination) or against the original source.
+Missing, changed and unexpected files are reported. With --src only the directories the source
+is copied to are searched for unexpected files, and the tag files of a BagIt bag are never unexpected.
+The sets and events options must match the ones of the copy, a BagIt destination is detected.
+Source files that cannot be read are reported as errors.`,
+	Example: `  photo-copier verify --dst /srv/photos --manifest /srv/photos/SHA256SUMS --report report.json
+  photo-copier verify --dst /srv/photos --src /media/card/DCIM`,
+	Args: cobra.MinimumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		verifier := &verify.Verifier{
+			Root:    verifyDstDirectory,
+			Workers: verifyNumWorkers,
+			Exclude: make(map[string]bool),
+		}
+		switch {
+		case verifyManifest != "":
+			read := manifest.Read
+			if manifest.IsBagManifest(verifyDstDirectory, verifyManifest) {
+				read = manifest.ReadBagManifest
+			}
+			expected, err := read(verifyManifest)
+			if err != nil {
+				return err
+			}
+			verifier.Expected = expected
+			if rel, err := filepath.Rel(verifyDstDirectory, verifyManifest); err == nil {
+				verifier.Exclude[filepath.ToSlash(rel)] = true
+			}
+			if manifest.IsBag(verifyDstDirectory) {
+				verifier.Scope = func(rel string) bool { return !manifest.IsBagTagFile(rel) }
+			}
+		case verifySrcDirectory != "":
+			cfg := &config.Config{
+				DestFileFormat:  verifyDstFileFormat,
+				DestDirectory:   verifyDstDirectory,
+				SourceDirectory: verifySrcDirectory,
+				BagIt:           manifest.IsBag(verifyDstDirectory),
+				Sets:            verifySets,
+				SetGap:          verifySetGap,
+				EventGap:        verifyEventGap,
+				EventDistance:   verifyEventDist,
+				PlacesPath:      verifyPlaces,
+			}
+			expected, err := verify.ExpectedFromSource(ctx, cfg)
+			var partial *utils.PartialError
+			if errors.As(err, &partial) {
+				verifier.SourceErrors = partial.Failed
+			} else if err != nil {
+				return err
+			}
+			verifier.Expected = expected
+			verifier.Filter = utils.IsImage
+			verifier.Scope = verify.InExpectedDirs(expected)
+		default:
+			return fmt.Errorf("either --manifest or --src is needed")
+		}
+
+		report, err := verifier.Run(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to verify %v. err=%w", verifyDstDirectory, err)
+		}
+		log.Infof("Verified %d files in %s: %d ok, %d missing, %d changed, %d unexpected, %d errors",
+			len(report.Files), report.Duration, report.Summary[verify.StatusOK], report.Summary[verify.StatusMissing],
+			report.Summary[verify.StatusChanged], report.Summary[verify.StatusUnexpected], report.Summary[verify.StatusError])
+
+		if verifyReport != "" {
+			out := os.Stdout
+			if verifyReport != "-" {
+				out, err = os.Create(verifyReport)
+				if err != nil {
+					return fmt.Errorf("unable to create report %v. err=%w", verifyReport, err)
+				}
+				defer out.Close()
+			}
+			if err := report.WriteJSON(out); err != nil {
+				return fmt.Errorf("unable to write report. err=%w", err)
+			}
+		}
+		return report.Err()
+	},
+}
+
+func verifyInit() {
+
+	cmdVerify.PersistentFlags().StringVarP(&verifyDstDirectory, "dst", "d", ".", "Destination directory to verify")
+	cmdVerify.MarkPersistentFlagRequired("dst")
+	cmdVerify.PersistentFlags().StringVarP(&verifySrcDirectory, "src", "s", "", "Original source directory to compare with")
+	cmdVerify.PersistentFlags().StringVarP(&verifyDstFileFormat, "format", "", "2006/2006-01-02", "Destination directory format used when copying from --src")
+	cmdVerify.PersistentFlags().StringVarP(&verifySets, "sets", "", "", "Sets mode used when copying from --src: subdir or sidecar")
+	cmdVerify.PersistentFlags().DurationVarP(&verifySetGap, "set-gap", "", time.Second, "Set gap used when copying from --src")
+	cmdVerify.PersistentFlags().DurationVarP(&verifyEventGap, "event-gap", "", 4*time.Hour, "Event gap used when copying from --src")
+	cmdVerify.PersistentFlags().Float64VarP(&verifyEventDist, "event-distance", "", 50, "Event distance used when copying from --src")
+	cmdVerify.PersistentFlags().StringVarP(&verifyPlaces, "places", "", "", "Places file used when copying from --src")
+	cmdVerify.PersistentFlags().StringVarP(&verifyManifest, "manifest", "m", "", "Checksum manifest to compare with")
+	cmdVerify.PersistentFlags().IntVarP(&verifyNumWorkers, "num-workers", "", runtime.NumCPU(), "number of workers. Default to runtime.NumCPU()")
+	cmdVerify.PersistentFlags().StringVarP(&verifyReport, "report", "", "", "Write a JSON report to this file, - for stdout")
+
+	rootCmd.AddCommand(cmdVerify)
+
+}
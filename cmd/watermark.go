@@ -1,10 +1,19 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"image/color"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/vfoucault/goPhoto/pkg/engine"
+	"github.com/vfoucault/goPhoto/pkg/metadata"
+	"github.com/vfoucault/goPhoto/pkg/utils"
 	"github.com/vfoucault/goPhoto/pkg/watermark"
 )
 
@@ -14,6 +23,15 @@ var (
 	watermarkWatermarkText  string
 	watermarkWatermarkColor string
 	watermarkWatermarkSize  float64
+	watermarkFormat         string
+	watermarkFormatOptions  string
+	watermarkStrip          string
+	watermarkOutput         string
+	watermarkFlatten        bool
+	watermarkIncremental    bool
+	watermarkPrune          bool
+	watermarkNumWorkers     int
+	watermarkMaxMemory      string
 )
 
 var cmdWatermark = &cobra.Command{
@@ -22,7 +40,23 @@ var cmdWatermark = &cobra.Command{
 	Long:    "Watermark photos from source to destination",
 	Example: ``,
 	Args:    cobra.MinimumNArgs(0),
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		strip, err := metadata.ParseStrip(watermarkStrip)
+		if err != nil {
+			return err
+		}
+		if watermarkPrune && !watermarkIncremental {
+			return fmt.Errorf("--prune needs --incremental")
+		}
+		format, err := utils.ParseFormat(watermarkFormat, watermarkFormatOptions)
+		if err != nil {
+			return err
+		}
+		maxMemory, err := parseSize(watermarkMaxMemory)
+		if err != nil {
+			return err
+		}
 
 		// Watermark
 		wm := watermark.WaterMark{Size: watermarkWatermarkSize, Text: watermarkWatermarkText}
@@ -32,13 +66,20 @@ var cmdWatermark = &cobra.Command{
 		case "black":
 			wm.Color = color.Black
 		default:
-			log.Errorf("unable to process color %s. only white and black", watermarkWatermarkColor)
-		}
-		log.Infof("calling add watermark with %s, %s, %s", watermarkSrcDirectory, watermarkDstDirectory, len(watermarkWatermarkText))
-		err := watermark.AddWatermarkToImage(watermarkSrcDirectory, watermarkDstDirectory, len(watermarkWatermarkText) > 0, wm)
-		if err != nil {
-			log.Errorf("unable to start image resize processing. err=%v", err.Error())
+			return fmt.Errorf("unable to process color %s. only white and black", watermarkWatermarkColor)
 		}
+		log.Infof("calling add watermark with %s, %s, %s", watermarkSrcDirectory, watermarkDstDirectory, watermarkWatermarkText)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return watermark.AddWatermarkToImage(ctx, watermarkSrcDirectory, watermarkDstDirectory, watermark.Options{
+			Strip:       strip,
+			Format:      format,
+			Template:    watermarkOutput,
+			Flatten:     watermarkFlatten,
+			Incremental: watermarkIncremental,
+			Prune:       watermarkPrune,
+			Engine:      engine.Options{Workers: watermarkNumWorkers, MaxMemory: maxMemory},
+		}, len(watermarkWatermarkText) > 0, wm)
 	},
 }
 
@@ -48,6 +89,15 @@ func watermarkInit() {
 	cmdWatermark.PersistentFlags().StringVarP(&watermarkDstDirectory, "dst", "d", ".", "Destination directory")
 	cmdWatermark.MarkPersistentFlagRequired("src")
 	cmdWatermark.MarkPersistentFlagRequired("dst")
+	cmdWatermark.PersistentFlags().StringVarP(&watermarkFormat, "format", "", utils.FormatAuto, "Output format: auto (same as the source), jpeg, png, gif, tiff or webp")
+	cmdWatermark.PersistentFlags().StringVarP(&watermarkFormatOptions, "format-options", "", "", "Comma separated encoder options: quality=1..100 (jpeg, webp), subsampling=444|422|420 and progressive (jpeg), lossless (webp), compression=default|fast|best|none (png) or deflate|none (tiff), colors=1..256 (gif)")
+	cmdWatermark.PersistentFlags().StringVarP(&watermarkOutput, "output", "", "", "Output file name template below the destination, with {name}, {ext}, {dir} (source subdirectory), e.g. {dir}/{name}_wm{ext}")
+	cmdWatermark.PersistentFlags().BoolVarP(&watermarkFlatten, "flatten", "", false, "Write every output to the destination directory instead of mirroring the source tree")
+	cmdWatermark.PersistentFlags().BoolVarP(&watermarkIncremental, "incremental", "", false, "Skip the outputs whose source and settings did not change since the last incremental run")
+	cmdWatermark.PersistentFlags().BoolVarP(&watermarkPrune, "prune", "", false, "With --incremental, remove the outputs of deleted sources")
+	cmdWatermark.PersistentFlags().IntVarP(&watermarkNumWorkers, "num-workers", "", runtime.NumCPU(), "Number of photos processed at once")
+	cmdWatermark.PersistentFlags().StringVarP(&watermarkMaxMemory, "max-memory", "", "2G", "Memory budget of the photos processed at once, e.g. 512M, 0 for no limit. A larger photo is processed alone")
+	cmdWatermark.PersistentFlags().StringVarP(&watermarkStrip, "strip", "", "", "Metadata not copied to the outputs: comma separated gps, serial, exif, xmp, iptc or all")
 	cmdWatermark.PersistentFlags().StringVarP(&watermarkWatermarkText, "watermark", "", "", "Watermark text")
 	cmdWatermark.PersistentFlags().StringVarP(&watermarkWatermarkColor, "watermark-color", "", "white", "Watermark color")
 	cmdWatermark.PersistentFlags().Float64VarP(&watermarkWatermarkSize, "watermark-size", "", 25, "Watermark color (black / white)")
@@ -0,0 +1,151 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testImage is a smooth gradient, with a transparent corner when alpha
+func testImage(w, h int, alpha bool) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint8(255)
+			if alpha && x < w/2 && y < h/2 {
+				a = uint8(x * 255 / w)
+			}
+			m.SetNRGBA(x, y, color.NRGBA{uint8(128 + 100*math.Sin(float64(x)/9)), uint8(128 + 100*math.Cos(float64(y)/13)), uint8((x + y) % 256), a})
+		}
+	}
+	return m
+}
+
+// psnr of the decoded colors at against a
+func psnr(a *image.NRGBA, at func(x, y int) (r, g, b float64)) float64 {
+	var se float64
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := a.NRGBAAt(x, y)
+			r, g, b := at(x, y)
+			for _, d := range []float64{float64(c.R) - r, float64(c.G) - g, float64(c.B) - b} {
+				se += d * d
+			}
+		}
+	}
+	return 10 * math.Log10(255*255*float64(3*bounds.Dx()*bounds.Dy())/se)
+}
+
+func TestEncodeJPEG(t *testing.T) {
+	tests := []struct {
+		name       string
+		w, h       int
+		opts       JPEGOptions
+		gray       bool
+		minQuality float64
+	}{
+		{name: "baseline 4:2:0", w: 33, h: 47, opts: JPEGOptions{Quality: 90}, minQuality: 35},
+		{name: "baseline 4:4:4", w: 1, h: 1, opts: JPEGOptions{Subsampling: Subsample444}, minQuality: 35},
+		{name: "progressive 4:2:2", w: 120, h: 80, opts: JPEGOptions{Subsampling: Subsample422, Progressive: true}, minQuality: 35},
+		{name: "progressive gray", w: 50, h: 30, opts: JPEGOptions{Progressive: true}, gray: true, minQuality: 35},
+	}
+	for _, tt := range tests {
+		src := testImage(tt.w, tt.h, false)
+		var img image.Image = src
+		if tt.gray {
+			g := image.NewGray(src.Rect)
+			for i := range g.Pix {
+				g.Pix[i] = src.Pix[4*i]
+				src.Pix[4*i+1], src.Pix[4*i+2] = src.Pix[4*i], src.Pix[4*i]
+			}
+			img = g
+		}
+		var buf bytes.Buffer
+		if err := EncodeJPEG(&buf, img, &tt.opts); err != nil {
+			t.Fatalf("%v: EncodeJPEG() err=%v", tt.name, err)
+		}
+		got, err := jpeg.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%v: jpeg.Decode() err=%v", tt.name, err)
+		}
+		quality := psnr(src, func(x, y int) (float64, float64, float64) {
+			r, g, b, _ := got.At(x, y).RGBA()
+			return float64(r >> 8), float64(g >> 8), float64(b >> 8)
+		})
+		if got.Bounds() != src.Bounds() || quality < tt.minQuality {
+			t.Errorf("%v: decoded %v at %.1fdB, want %v at %vdB", tt.name, got.Bounds(), quality, src.Bounds(), tt.minQuality)
+		}
+	}
+}
+
+func TestEncodeWebP(t *testing.T) {
+	tests := []struct {
+		name       string
+		w, h       int
+		opts       WebPOptions
+		alpha      bool
+		minQuality float64
+	}{
+		{name: "lossy", w: 160, h: 120, opts: WebPOptions{Quality: 90}, minQuality: 35},
+		{name: "lossy low quality", w: 17, h: 9, opts: WebPOptions{Quality: 10}, minQuality: 25},
+		{name: "lossy alpha", w: 40, h: 33, alpha: true, minQuality: 35},
+		{name: "lossless", w: 160, h: 120, opts: WebPOptions{Lossless: true}, minQuality: math.Inf(1)},
+		{name: "lossless alpha", w: 1, h: 1, opts: WebPOptions{Lossless: true}, alpha: true, minQuality: math.Inf(1)},
+	}
+	for _, tt := range tests {
+		src := testImage(tt.w, tt.h, tt.alpha)
+		var buf bytes.Buffer
+		if err := EncodeWebP(&buf, src, &tt.opts); err != nil {
+			t.Fatalf("%v: EncodeWebP() err=%v", tt.name, err)
+		}
+		got, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%v: webp.Decode() err=%v", tt.name, err)
+		}
+		var at func(x, y int) (float64, float64, float64)
+		alphaAt := func(x, y int) uint8 { return 255 }
+		var ycc *image.YCbCr
+		switch m := got.(type) {
+		case *image.NRGBA:
+			at = func(x, y int) (float64, float64, float64) {
+				c := m.NRGBAAt(x, y)
+				return float64(c.R), float64(c.G), float64(c.B)
+			}
+			alphaAt = func(x, y int) uint8 { return m.NRGBAAt(x, y).A }
+		case *image.NYCbCrA:
+			ycc = &m.YCbCr
+			alphaAt = func(x, y int) uint8 { return m.A[m.AOffset(x, y)] }
+		case *image.YCbCr:
+			ycc = m
+		default:
+			t.Fatalf("%v: decoded %T", tt.name, got)
+		}
+		if ycc != nil {
+			// the decoder reads the limited range of WebP as full range
+			at = func(x, y int) (float64, float64, float64) {
+				c := ycc.YCbCrAt(x, y)
+				l, cb, cr := 1.164*(float64(c.Y)-16), float64(c.Cb)-128, float64(c.Cr)-128
+				return float64(clampByte(l + 1.596*cr)), float64(clampByte(l - 0.391*cb - 0.813*cr)), float64(clampByte(l + 2.018*cb))
+			}
+		}
+		if got.Bounds() != src.Bounds() {
+			t.Fatalf("%v: decoded %v, want %v", tt.name, got.Bounds(), src.Bounds())
+		}
+		if quality := psnr(src, at); quality < tt.minQuality {
+			t.Errorf("%v: decoded at %.1fdB, want %vdB", tt.name, quality, tt.minQuality)
+		}
+		for y := 0; y < tt.h; y++ {
+			for x := 0; x < tt.w; x++ {
+				if a := alphaAt(x, y); a != src.NRGBAAt(x, y).A {
+					t.Fatalf("%v: alpha at %v,%v = %v, want %v", tt.name, x, y, a, src.NRGBAAt(x, y).A)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,811 @@
+package codec
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Subsampling is the chroma subsampling of a JPEG
+type Subsampling int
+
+// Subsample420 halves the chroma both ways, as the standard library encoder
+const (
+	Subsample420 Subsampling = iota
+	Subsample422
+	Subsample444
+)
+
+// JPEGOptions of EncodeJPEG
+type JPEGOptions struct {
+	// Quality from 1 to 100, 90 when 0
+	Quality     int
+	Subsampling Subsampling
+	// Progressive writes the DC coefficients first, then the AC bands of each
+	// component, instead of one baseline scan
+	Progressive bool
+}
+
+// JPEG markers
+const (
+	markerSOF0 = 0xC0
+	markerSOF2 = 0xC2
+	markerDHT  = 0xC4
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+)
+
+// baseQuant are the quantization tables of Annex K, in natural order
+var baseQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffmanSpec is a Huffman table as written in a DHT segment: the number of
+// codes of each length from 1 to 16 and the symbols by increasing length
+type huffmanSpec struct {
+	count  [16]byte
+	symbol []byte
+}
+
+// standardHuffman are the tables of Annex K, used by baseline scans:
+// luminance DC, chrominance DC, luminance AC and chrominance AC
+var standardHuffman = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// unzig maps the zig-zag order of the coefficients to their natural order
+var unzig [64]int
+
+// dctCos[u][x] is C(u)/2 * cos((2x+1)uπ/16), C(0) being 1/√2
+var dctCos [8][8]float64
+
+func init() {
+	x, y := 0, 0
+	for i := range unzig {
+		unzig[i] = 8*y + x
+		if (x+y)%2 == 0 {
+			switch {
+			case x == 7:
+				y++
+			case y == 0:
+				x++
+			default:
+				x, y = x+1, y-1
+			}
+		} else {
+			switch {
+			case y == 7:
+				x++
+			case x == 0:
+				y++
+			default:
+				x, y = x-1, y+1
+			}
+		}
+	}
+	for u := range dctCos {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := range dctCos[u] {
+			dctCos[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+}
+
+// huffmanCode is the code of every symbol of a table, of size 0 when unused
+type huffmanCode struct {
+	code [256]uint16
+	size [256]byte
+}
+
+func (s *huffmanSpec) codes() *huffmanCode {
+	h := &huffmanCode{}
+	code, k := uint16(0), 0
+	for i, n := range s.count {
+		for ; n > 0; n-- {
+			h.code[s.symbol[k]] = code
+			h.size[s.symbol[k]] = byte(i + 1)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return h
+}
+
+// optimalHuffman builds the table of the frequencies of the 256 symbols with
+// codes up to 16 bits, following Annex K.2
+func optimalHuffman(freq *[256]int) huffmanSpec {
+	var f [257]int
+	copy(f[:], freq[:])
+	// a reserved symbol keeps any code from being all ones
+	f[256] = 1
+	var size [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		c1, c2 := -1, -1
+		for i, v := range f {
+			if v > 0 && (c1 < 0 || v <= f[c1]) {
+				c1 = i
+			}
+		}
+		for i, v := range f {
+			if v > 0 && i != c1 && (c2 < 0 || v <= f[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		f[c1] += f[c2]
+		f[c2] = 0
+		for size[c1]++; others[c1] >= 0; size[c1]++ {
+			c1 = others[c1]
+		}
+		others[c1] = c2
+		for size[c2]++; others[c2] >= 0; size[c2]++ {
+			c2 = others[c2]
+		}
+	}
+	var bits [33]int
+	for _, s := range size {
+		if s > 0 {
+			bits[s]++
+		}
+	}
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+	var spec huffmanSpec
+	for i := range spec.count {
+		spec.count[i] = byte(bits[i+1])
+	}
+	for s := 1; s <= 32; s++ {
+		for sym := 0; sym < 256; sym++ {
+			if size[sym] == s {
+				spec.symbol = append(spec.symbol, byte(sym))
+			}
+		}
+	}
+	return spec
+}
+
+// jpegComponent is one of the Y, Cb and Cr components, or the gray one
+type jpegComponent struct {
+	id   byte
+	h, v int
+	// quant is the index of the quantization table, also that of the
+	// Huffman tables
+	quant int
+	// blocksW is the number of blocks of a row of MCUs, blocksH that of a
+	// column. Scans of the component alone only cover the blocks holding
+	// pixels, width by height.
+	blocksW, blocksH int
+	width, height    int
+	// coef are the quantized coefficients in zig-zag order, a row of MCUs
+	// when baseline, the whole image when progressive
+	coef []int16
+	pred int
+}
+
+// block returns the coefficients of block (x, y), y being relative to the
+// rows held by coef
+func (c *jpegComponent) block(x, y int) []int16 {
+	i := 64 * (y*c.blocksW + x)
+	return c.coef[i : i+64]
+}
+
+type jpegEncoder struct {
+	w     *bufio.Writer
+	err   error
+	img   image.Image
+	gray  bool
+	comps []*jpegComponent
+	// hMax and vMax are the largest sampling factors
+	hMax, vMax   int
+	mcusX, mcusY int
+	quant        [2][64]int
+	// strip holds the full resolution Y, Cb and Cr of a row of MCUs
+	strip       [3][]float64
+	stripW      int
+	row         [3][]float64
+	bits, nBits uint32
+	counting    bool
+	freq        [4][256]int
+	huffman     [4]*huffmanCode
+	eobRun      int
+}
+
+// EncodeJPEG writes img as a JPEG. Unlike image/jpeg, the chroma subsampling
+// can be chosen and the scans can be progressive.
+func EncodeJPEG(w io.Writer, img image.Image, o *JPEGOptions) error {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
+		return fmt.Errorf("unable to encode a %dx%d image as JPEG", b.Dx(), b.Dy())
+	}
+	var opts JPEGOptions
+	if o != nil {
+		opts = *o
+	}
+	quality := opts.Quality
+	if quality <= 0 {
+		quality = 90
+	} else if quality > 100 {
+		quality = 100
+	}
+	e := &jpegEncoder{img: img, w: bufio.NewWriter(w)}
+	e.scaleQuant(quality)
+	e.layout(opts.Subsampling, opts.Progressive)
+	e.write([]byte{0xFF, markerSOI})
+	e.writeDQT()
+	e.writeSOF(opts.Progressive)
+	if opts.Progressive {
+		e.writeProgressive()
+	} else {
+		e.writeBaseline()
+	}
+	e.write([]byte{0xFF, markerEOI})
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// scaleQuant scales the base tables as libjpeg does, the result being in
+// zig-zag order
+func (e *jpegEncoder) scaleQuant(quality int) {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for i := range e.quant {
+		for z := range e.quant[i] {
+			q := (baseQuant[i][unzig[z]]*scale + 50) / 100
+			if q < 1 {
+				q = 1
+			} else if q > 255 {
+				q = 255
+			}
+			e.quant[i][z] = q
+		}
+	}
+}
+
+func (e *jpegEncoder) layout(subsampling Subsampling, progressive bool) {
+	_, e.gray = e.img.(*image.Gray)
+	if e.gray {
+		e.comps = []*jpegComponent{{id: 1, h: 1, v: 1}}
+	} else {
+		h, v := 2, 2
+		switch subsampling {
+		case Subsample422:
+			v = 1
+		case Subsample444:
+			h, v = 1, 1
+		}
+		e.comps = []*jpegComponent{
+			{id: 1, h: h, v: v},
+			{id: 2, h: 1, v: 1, quant: 1},
+			{id: 3, h: 1, v: 1, quant: 1},
+		}
+	}
+	e.hMax, e.vMax = e.comps[0].h, e.comps[0].v
+	b := e.img.Bounds()
+	e.mcusX = (b.Dx() + 8*e.hMax - 1) / (8 * e.hMax)
+	e.mcusY = (b.Dy() + 8*e.vMax - 1) / (8 * e.vMax)
+	e.stripW = 8 * e.hMax * e.mcusX
+	for i, c := range e.comps {
+		c.blocksW = e.mcusX * c.h
+		c.blocksH = e.mcusY * c.v
+		c.width = (b.Dx()*c.h + e.hMax - 1) / e.hMax
+		c.height = (b.Dy()*c.v + e.vMax - 1) / e.vMax
+		rows := c.v
+		if progressive {
+			rows = c.blocksH
+		}
+		c.coef = make([]int16, 64*c.blocksW*rows)
+		e.strip[i] = make([]float64, e.stripW*8*e.vMax)
+		e.row[i] = make([]float64, b.Dx())
+	}
+}
+
+func (e *jpegEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *jpegEncoder) writeSegment(marker byte, payload []byte) {
+	e.write([]byte{0xFF, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)})
+	e.write(payload)
+}
+
+func (e *jpegEncoder) writeDQT() {
+	var payload []byte
+	for i := range e.quant[:e.tables()] {
+		payload = append(payload, byte(i))
+		for _, q := range e.quant[i] {
+			payload = append(payload, byte(q))
+		}
+	}
+	e.writeSegment(markerDQT, payload)
+}
+
+func (e *jpegEncoder) writeSOF(progressive bool) {
+	b := e.img.Bounds()
+	payload := []byte{8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), byte(len(e.comps))}
+	for _, c := range e.comps {
+		payload = append(payload, c.id, byte(c.h<<4|c.v), byte(c.quant))
+	}
+	marker := byte(markerSOF0)
+	if progressive {
+		marker = markerSOF2
+	}
+	e.writeSegment(marker, payload)
+}
+
+// writeDHT writes table id of class 0 (DC) or 1 (AC)
+func (e *jpegEncoder) writeDHT(class, id int, spec huffmanSpec) {
+	payload := append([]byte{byte(class<<4 | id)}, spec.count[:]...)
+	e.writeSegment(markerDHT, append(payload, spec.symbol...))
+	e.huffman[2*class+id] = spec.codes()
+}
+
+// writeSOS starts a scan of comps for the coefficients start to end
+func (e *jpegEncoder) writeSOS(comps []*jpegComponent, start, end int) {
+	payload := []byte{byte(len(comps))}
+	for _, c := range comps {
+		payload = append(payload, c.id, byte(c.quant<<4|c.quant))
+	}
+	payload = append(payload, byte(start), byte(end), 0)
+	e.writeSegment(markerSOS, payload)
+	e.bits, e.nBits = 0, 0
+	e.eobRun = 0
+	for _, c := range comps {
+		c.pred = 0
+	}
+}
+
+// emit writes the n low bits of bits, stuffing a zero after every 0xFF
+func (e *jpegEncoder) emit(bits, n uint32) {
+	if e.counting {
+		return
+	}
+	e.bits |= bits << (32 - e.nBits - n)
+	e.nBits += n
+	for e.nBits >= 8 {
+		b := byte(e.bits >> 24)
+		e.write([]byte{b})
+		if b == 0xFF {
+			e.write([]byte{0})
+		}
+		e.bits <<= 8
+		e.nBits -= 8
+	}
+}
+
+// emitHuff writes the code of symbol in table 2*class+id, or counts it
+func (e *jpegEncoder) emitHuff(table int, symbol byte) {
+	if e.counting {
+		e.freq[table][symbol]++
+		return
+	}
+	h := e.huffman[table]
+	e.emit(uint32(h.code[symbol]), uint32(h.size[symbol]))
+}
+
+// emitValue writes a coefficient of category size after its symbol
+func (e *jpegEncoder) emitValue(table int, run byte, v int) {
+	a := v
+	if a < 0 {
+		a = -a
+		v--
+	}
+	size := 0
+	for ; a > 0; a >>= 1 {
+		size++
+	}
+	e.emitHuff(table, run<<4|byte(size))
+	if size > 0 {
+		e.emit(uint32(v)&(1<<size-1), uint32(size))
+	}
+}
+
+// flush pads the last byte of a scan with ones
+func (e *jpegEncoder) flush() {
+	e.emit(0x7F, 7)
+	e.bits, e.nBits = 0, 0
+}
+
+func (e *jpegEncoder) emitDC(c *jpegComponent, block []int16) {
+	dc := int(block[0])
+	e.emitValue(c.quant, 0, dc-c.pred)
+	c.pred = dc
+}
+
+// emitAC writes the coefficients start to end of a block, ending with an
+// EOB, or adding to the run of EOBs when progressive
+func (e *jpegEncoder) emitAC(table int, block []int16, start, end int, progressive bool) {
+	last := start - 1
+	for k := end; k >= start; k-- {
+		if block[k] != 0 {
+			last = k
+			break
+		}
+	}
+	if progressive {
+		if last < start {
+			e.eobRun++
+			if e.eobRun == 0x7FFF {
+				e.flushEOB(table)
+			}
+			return
+		}
+		e.flushEOB(table)
+	}
+	run := byte(0)
+	for k := start; k <= last; k++ {
+		if block[k] == 0 {
+			run++
+			continue
+		}
+		for ; run >= 16; run -= 16 {
+			e.emitHuff(table, 0xF0)
+		}
+		e.emitValue(table, run, int(block[k]))
+		run = 0
+	}
+	if last == end {
+		return
+	}
+	if progressive {
+		e.eobRun++
+		if e.eobRun == 0x7FFF {
+			e.flushEOB(table)
+		}
+		return
+	}
+	e.emitHuff(table, 0)
+}
+
+// flushEOB writes the pending run of blocks ending with zeros
+func (e *jpegEncoder) flushEOB(table int) {
+	if e.eobRun == 0 {
+		return
+	}
+	n := 0
+	for r := e.eobRun; r > 1; r >>= 1 {
+		n++
+	}
+	e.emitHuff(table, byte(n<<4))
+	if n > 0 {
+		e.emit(uint32(e.eobRun)&(1<<n-1), uint32(n))
+	}
+	e.eobRun = 0
+}
+
+// writeBaseline writes the whole image in one interleaved scan with the
+// standard tables
+func (e *jpegEncoder) writeBaseline() {
+	for i, c := range e.comps[:e.tables()] {
+		e.writeDHT(0, c.quant, standardHuffman[i])
+		e.writeDHT(1, c.quant, standardHuffman[2+i])
+	}
+	e.writeSOS(e.comps, 0, 63)
+	for my := 0; my < e.mcusY; my++ {
+		e.quantizeRow(my, 0)
+		for mx := 0; mx < e.mcusX; mx++ {
+			for _, c := range e.comps {
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						block := c.block(mx*c.h+h, v)
+						e.emitDC(c, block)
+						e.emitAC(2+c.quant, block, 1, 63, false)
+					}
+				}
+			}
+		}
+	}
+	e.flush()
+}
+
+// progressiveScan is a spectral band of components
+type progressiveScan struct {
+	comps      []int
+	start, end int
+}
+
+// writeProgressive writes the DC of all the components, then the low and
+// high AC bands of the luminance around the AC of the chrominance, with
+// Huffman tables computed for each scan
+func (e *jpegEncoder) writeProgressive() {
+	for my := 0; my < e.mcusY; my++ {
+		e.quantizeRow(my, my)
+	}
+	scans := []progressiveScan{{comps: []int{0, 1, 2}}, {[]int{0}, 1, 5}, {[]int{2}, 1, 63}, {[]int{1}, 1, 63}, {[]int{0}, 6, 63}}
+	if e.gray {
+		scans = []progressiveScan{{comps: []int{0}}, {[]int{0}, 1, 5}, {[]int{0}, 6, 63}}
+	}
+	for _, scan := range scans {
+		var comps []*jpegComponent
+		for _, i := range scan.comps {
+			comps = append(comps, e.comps[i])
+		}
+		e.freq = [4][256]int{}
+		e.counting = true
+		e.writeScan(comps, scan.start, scan.end)
+		e.counting = false
+		// Cb and Cr share their tables
+		var written [2]bool
+		for _, c := range comps {
+			if written[c.quant] {
+				continue
+			}
+			written[c.quant] = true
+			if scan.start == 0 {
+				e.writeDHT(0, c.quant, optimalHuffman(&e.freq[c.quant]))
+			} else {
+				e.writeDHT(1, c.quant, optimalHuffman(&e.freq[2+c.quant]))
+			}
+		}
+		e.writeSOS(comps, scan.start, scan.end)
+		e.writeScan(comps, scan.start, scan.end)
+	}
+}
+
+// tables is the number of quantization and Huffman tables of each class
+func (e *jpegEncoder) tables() int {
+	if e.gray {
+		return 1
+	}
+	return 2
+}
+
+func (e *jpegEncoder) writeScan(comps []*jpegComponent, start, end int) {
+	e.eobRun = 0
+	for _, c := range comps {
+		c.pred = 0
+	}
+	if len(comps) > 1 {
+		for my := 0; my < e.mcusY; my++ {
+			for mx := 0; mx < e.mcusX; mx++ {
+				for _, c := range comps {
+					for v := 0; v < c.v; v++ {
+						for h := 0; h < c.h; h++ {
+							e.emitDC(c, c.block(mx*c.h+h, my*c.v+v))
+						}
+					}
+				}
+			}
+		}
+	} else {
+		c := comps[0]
+		for y := 0; y < (c.height+7)/8; y++ {
+			for x := 0; x < (c.width+7)/8; x++ {
+				block := c.block(x, y)
+				if start == 0 {
+					e.emitDC(c, block)
+				} else {
+					e.emitAC(2+c.quant, block, start, end, true)
+				}
+			}
+		}
+	}
+	e.flushEOB(2 + comps[0].quant)
+	e.flush()
+}
+
+// quantizeRow converts, subsamples, transforms and quantizes the row of MCUs
+// my into the rows of coef starting at the block row at*v
+func (e *jpegEncoder) quantizeRow(my, at int) {
+	b := e.img.Bounds()
+	stripH := 8 * e.vMax
+	for py := 0; py < stripH; py++ {
+		y := my*stripH + py
+		if y >= b.Dy() {
+			y = b.Dy() - 1
+		}
+		e.readRow(b.Min.Y + y)
+		for i := range e.comps {
+			dst := e.strip[i][py*e.stripW : (py+1)*e.stripW]
+			n := copy(dst, e.row[i])
+			for x := n; x < len(dst); x++ {
+				dst[x] = e.row[i][n-1]
+			}
+		}
+	}
+	var samples, coef [64]float64
+	for i, c := range e.comps {
+		fx, fy := e.hMax/c.h, e.vMax/c.v
+		area := float64(fx * fy)
+		for by := 0; by < c.v; by++ {
+			for bx := 0; bx < c.blocksW; bx++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						sum := 0.0
+						for dy := 0; dy < fy; dy++ {
+							row := e.strip[i][((8*by+y)*fy+dy)*e.stripW:]
+							for dx := 0; dx < fx; dx++ {
+								sum += row[(8*bx+x)*fx+dx]
+							}
+						}
+						samples[8*y+x] = sum/area - 128
+					}
+				}
+				fdct(&samples, &coef)
+				block := c.block(bx, at*c.v+by)
+				q := &e.quant[c.quant]
+				for z := range block {
+					block[z] = int16(math.Round(coef[unzig[z]] / float64(q[z])))
+				}
+			}
+		}
+	}
+}
+
+// fdct is the separable forward DCT of an 8x8 block
+func fdct(in, out *[64]float64) {
+	var tmp [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < 8; x++ {
+				sum += in[8*y+x] * dctCos[u][x]
+			}
+			tmp[8*y+u] = sum
+		}
+	}
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < 8; y++ {
+				sum += tmp[8*y+u] * dctCos[v][y]
+			}
+			out[8*v+u] = sum
+		}
+	}
+}
+
+// readRow converts the row y of the image to Y, Cb and Cr, or gray
+func (e *jpegEncoder) readRow(y int) {
+	b := e.img.Bounds()
+	yy, cb, cr := e.row[0], e.row[1%len(e.row)], e.row[2%len(e.row)]
+	switch m := e.img.(type) {
+	case *image.Gray:
+		p := m.Pix[m.PixOffset(b.Min.X, y):]
+		for x := range yy {
+			yy[x] = float64(p[x])
+		}
+		return
+	case *image.YCbCr:
+		for x := range yy {
+			yy[x] = float64(m.Y[m.YOffset(b.Min.X+x, y)])
+			c := m.COffset(b.Min.X+x, y)
+			cb[x], cr[x] = float64(m.Cb[c]), float64(m.Cr[c])
+		}
+		return
+	}
+	for x := range yy {
+		r, g, bl := e.rgb(b.Min.X+x, y)
+		yy[x] = 0.299*r + 0.587*g + 0.114*bl
+		cb[x] = -0.168736*r - 0.331264*g + 0.5*bl + 128
+		cr[x] = 0.5*r - 0.418688*g - 0.081312*bl + 128
+	}
+}
+
+// rgb returns the color of a pixel, premultiplied by its alpha as
+// image/jpeg does
+func (e *jpegEncoder) rgb(x, y int) (float64, float64, float64) {
+	switch m := e.img.(type) {
+	case *image.RGBA:
+		p := m.Pix[m.PixOffset(x, y):]
+		return float64(p[0]), float64(p[1]), float64(p[2])
+	case *image.NRGBA:
+		p := m.Pix[m.PixOffset(x, y):]
+		a := float64(p[3]) / 255
+		return float64(p[0]) * a, float64(p[1]) * a, float64(p[2]) * a
+	}
+	r, g, b, _ := e.img.At(x, y).RGBA()
+	return float64(r >> 8), float64(g >> 8), float64(b >> 8)
+}
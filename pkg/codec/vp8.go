@@ -0,0 +1,702 @@
+package codec
+
+import (
+	"image"
+	"math"
+)
+
+// VP8, the lossy WebP bitstream. The encoder writes one key frame of
+// macroblocks predicted as a whole, 16x16 luma and 8x8 chroma, from their
+// reconstructed neighbors. The residuals go through the DCT, the WHT of the
+// luma DCs and the quantizer, and are coded with the default probabilities.
+
+// vp8MaxSize is the largest width or height
+const vp8MaxSize = 1<<14 - 1
+
+// Macroblock predictors, in the order of their trees
+const (
+	predDC = iota
+	predV
+	predH
+	predTM
+)
+
+// Token planes
+const (
+	planeYAfterY2 = 0
+	planeY2       = 1
+	planeUV       = 2
+)
+
+// The quantizer steps of RFC 6386, section 14.1
+var (
+	dcSteps = [128]int{
+		4, 5, 6, 7, 8, 9, 10, 10, 11, 12, 13, 14, 15, 16, 17, 17,
+		18, 19, 20, 20, 21, 21, 22, 22, 23, 23, 24, 25, 25, 26, 27, 28,
+		29, 30, 31, 32, 33, 34, 35, 36, 37, 37, 38, 39, 40, 41, 42, 43,
+		44, 45, 46, 46, 47, 48, 49, 50, 51, 52, 53, 54, 55, 56, 57, 58,
+		59, 60, 61, 62, 63, 64, 65, 66, 67, 68, 69, 70, 71, 72, 73, 74,
+		75, 76, 76, 77, 78, 79, 80, 81, 82, 83, 84, 85, 86, 87, 88, 89,
+		91, 93, 95, 96, 98, 100, 101, 102, 104, 106, 108, 110, 112, 114, 116, 118,
+		122, 124, 126, 128, 130, 132, 134, 136, 138, 140, 143, 145, 148, 151, 154, 157,
+	}
+	acSteps = [128]int{
+		4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19,
+		20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35,
+		36, 37, 38, 39, 40, 41, 42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
+		52, 53, 54, 55, 56, 57, 58, 60, 62, 64, 66, 68, 70, 72, 74, 76,
+		78, 80, 82, 84, 86, 88, 90, 92, 94, 96, 98, 100, 102, 104, 106, 108,
+		110, 112, 114, 116, 119, 122, 125, 128, 131, 134, 137, 140, 143, 146, 149, 152,
+		155, 158, 161, 164, 167, 170, 173, 177, 181, 185, 189, 193, 197, 201, 205, 209,
+		213, 217, 221, 225, 229, 234, 239, 245, 249, 254, 259, 264, 269, 274, 279, 284,
+	}
+	// bands maps the position of a coefficient to its probability band
+	bands = [17]int{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	// zigzag maps the coding order of a coefficient to its position
+	zigzag = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	// categoryProb are the probabilities of the extra bits of the
+	// categories 3 to 6
+	categoryProb = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// dct4Cos[k][n] is a(k) * cos((2n+1)kπ/8), the orthonormal 4 point DCT
+var dct4Cos [4][4]float64
+
+func init() {
+	for k := range dct4Cos {
+		a := math.Sqrt(0.5)
+		if k == 0 {
+			a = 0.5
+		}
+		for n := range dct4Cos[k] {
+			dct4Cos[k][n] = a * math.Cos(float64(2*n+1)*float64(k)*math.Pi/8)
+		}
+	}
+}
+
+// boolEncoder is the boolean entropy encoder of RFC 6386, section 7
+type boolEncoder struct {
+	buf         []byte
+	rng, bottom uint32
+	count       int
+}
+
+func newBoolEncoder() *boolEncoder {
+	return &boolEncoder{rng: 255, count: 24}
+}
+
+// put writes bit, prob being the probability out of 256 that it is 0
+func (e *boolEncoder) put(bit bool, prob uint8) {
+	split := 1 + (e.rng-1)*uint32(prob)>>8
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			i := len(e.buf) - 1
+			for ; i >= 0 && e.buf[i] == 0xff; i-- {
+				e.buf[i] = 0
+			}
+			if i >= 0 {
+				e.buf[i]++
+			}
+		}
+		e.bottom <<= 1
+		e.count--
+		if e.count == 0 {
+			e.buf = append(e.buf, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.count = 8
+		}
+	}
+}
+
+// putLiteral writes the n low bits of v, most significant first
+func (e *boolEncoder) putLiteral(v, n int) {
+	for n > 0 {
+		n--
+		e.put(v>>n&1 == 1, 128)
+	}
+}
+
+// bytes flushes the pending bits and returns the partition
+func (e *boolEncoder) bytes() []byte {
+	for i := 0; i < 32; i++ {
+		e.put(false, 128)
+	}
+	return e.buf
+}
+
+// vp8Context holds whether the blocks next to a macroblock have non zero
+// coefficients: the bottom row of the one above or the right column of the
+// one on the left
+type vp8Context struct {
+	y    [4]int
+	u, v [2]int
+	y2   int
+}
+
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+	// src holds the Y, U and V planes padded to whole macroblocks, rec
+	// their reconstruction
+	src, rec [3][]uint8
+	stride   [3]int
+	// y1, y2 and uv are the DC and AC steps of the luma, of the WHT of the
+	// luma DCs and of the chroma
+	y1, y2, uv [2]int
+	header     *boolEncoder
+	tokens     *boolEncoder
+	top        []vp8Context
+	left       vp8Context
+}
+
+// encodeVP8 returns the VP8 key frame of m at quality 1 to 100
+func encodeVP8(m *image.NRGBA, quality int) []byte {
+	e := &vp8Encoder{width: m.Rect.Dx(), height: m.Rect.Dy(), header: newBoolEncoder(), tokens: newBoolEncoder()}
+	e.mbw, e.mbh = (e.width+15)/16, (e.height+15)/16
+	e.top = make([]vp8Context, e.mbw)
+	e.readPlanes(m)
+	q := qualityIndex(quality)
+	e.y1 = [2]int{dcSteps[q], acSteps[q]}
+	e.y2 = [2]int{2 * dcSteps[q], acSteps[q] * 155 / 100}
+	if e.y2[1] < 8 {
+		e.y2[1] = 8
+	}
+	uvDC := q
+	if uvDC > 117 {
+		uvDC = 117
+	}
+	e.uv = [2]int{dcSteps[uvDC], acSteps[q]}
+	level := e.y1[1] / 4
+	if level > 63 {
+		level = 63
+	}
+	e.writeHeader(q, level)
+	for mby := 0; mby < e.mbh; mby++ {
+		e.left = vp8Context{}
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+	first := e.header.bytes()
+	tokens := e.tokens.bytes()
+	tag := len(first)<<5 | 1<<4
+	out := []byte{
+		byte(tag), byte(tag >> 8), byte(tag >> 16),
+		0x9d, 0x01, 0x2a,
+		byte(e.width), byte(e.width >> 8), byte(e.height), byte(e.height >> 8),
+	}
+	out = append(out, first...)
+	return append(out, tokens...)
+}
+
+// qualityIndex maps a quality to a quantizer index as libwebp does
+func qualityIndex(quality int) int {
+	c := float64(quality) / 100
+	linear := 2*c - 1
+	if c < 0.75 {
+		linear = c * 2 / 3
+	}
+	q := int(127 * (1 - math.Cbrt(linear)))
+	if q < 0 {
+		return 0
+	} else if q > 127 {
+		return 127
+	}
+	return q
+}
+
+// readPlanes converts m to the limited range YUV of WebP, the chroma
+// averaged over 2x2 pixels, replicating the last row and column
+func (e *vp8Encoder) readPlanes(m *image.NRGBA) {
+	e.stride = [3]int{16 * e.mbw, 8 * e.mbw, 8 * e.mbw}
+	for i := range e.src {
+		rows := 16 * e.mbh
+		if i > 0 {
+			rows = 8 * e.mbh
+		}
+		e.src[i] = make([]uint8, e.stride[i]*rows)
+		e.rec[i] = make([]uint8, e.stride[i]*rows)
+	}
+	rgb := func(x, y int) (float64, float64, float64) {
+		if x >= e.width {
+			x = e.width - 1
+		}
+		if y >= e.height {
+			y = e.height - 1
+		}
+		p := m.Pix[m.PixOffset(m.Rect.Min.X+x, m.Rect.Min.Y+y):]
+		return float64(p[0]), float64(p[1]), float64(p[2])
+	}
+	for y := 0; y < 16*e.mbh; y++ {
+		for x := 0; x < e.stride[0]; x++ {
+			r, g, b := rgb(x, y)
+			e.src[0][y*e.stride[0]+x] = clampByte(16 + 0.256788*r + 0.504129*g + 0.097906*b)
+		}
+	}
+	for y := 0; y < 8*e.mbh; y++ {
+		for x := 0; x < e.stride[1]; x++ {
+			var r, g, b float64
+			for i := 0; i < 4; i++ {
+				pr, pg, pb := rgb(2*x+i%2, 2*y+i/2)
+				r, g, b = r+pr/4, g+pg/4, b+pb/4
+			}
+			e.src[1][y*e.stride[1]+x] = clampByte(128 - 0.148223*r - 0.290993*g + 0.439216*b)
+			e.src[2][y*e.stride[2]+x] = clampByte(128 + 0.439216*r - 0.367788*g - 0.071427*b)
+		}
+	}
+}
+
+func clampByte(v float64) uint8 {
+	v = math.Round(v)
+	if v < 0 {
+		return 0
+	} else if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// writeHeader starts the first partition with the frame header: no
+// segments, no filter deltas, one token partition, the default token
+// probabilities and no skipped macroblocks
+func (e *vp8Encoder) writeHeader(q, level int) {
+	h := e.header
+	// color space and clamping
+	h.put(false, 128)
+	h.put(false, 128)
+	// segmentation
+	h.put(false, 128)
+	// normal loop filter, its level and sharpness
+	h.put(false, 128)
+	h.putLiteral(level, 6)
+	h.putLiteral(0, 3)
+	h.put(false, 128)
+	h.putLiteral(0, 2)
+	h.putLiteral(q, 7)
+	for i := 0; i < 5; i++ {
+		h.put(false, 128)
+	}
+	// refresh entropy probabilities
+	h.put(false, 128)
+	for i := range tokenUpdateProb {
+		for j := range tokenUpdateProb[i] {
+			for k := range tokenUpdateProb[i][j] {
+				for _, p := range tokenUpdateProb[i][j][k] {
+					h.put(false, p)
+				}
+			}
+		}
+	}
+	h.put(false, 128)
+}
+
+// encodeMacroblock picks the predictors, writes them to the first partition
+// and the residuals to the token partition, and reconstructs the macroblock
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) {
+	var pred [256]uint8
+	mode := e.bestPredictor(pred[:], []int{0}, 16*mbx, 16*mby, 16)
+	h := e.header
+	// 16x16 luma predictor, not per 4x4 block
+	h.put(true, 145)
+	switch mode {
+	case predDC:
+		h.put(false, 156)
+		h.put(false, 163)
+	case predV:
+		h.put(false, 156)
+		h.put(true, 163)
+	case predH:
+		h.put(true, 156)
+		h.put(false, 128)
+	case predTM:
+		h.put(true, 156)
+		h.put(true, 128)
+	}
+	var levels [16][16]int
+	var dc [16]float64
+	y0, stride := 16*mby*e.stride[0]+16*mbx, e.stride[0]
+	for b := 0; b < 16; b++ {
+		at := y0 + 4*(b/4)*stride + 4*(b%4)
+		coef := fdct4(e.src[0][at:], pred[4*(b/4)*16+4*(b%4):], stride, 16)
+		dc[b] = coef[0]
+		for k := 1; k < 16; k++ {
+			levels[b][k] = quantize(coef[k], e.y1[1], 0.375)
+		}
+	}
+	var y2 [16]int
+	for k, c := range fwht(dc) {
+		y2[k] = quantize(c, e.y2[btoi(k > 0)], 0.5)
+	}
+	nz := e.putCoefficients(planeY2, e.left.y2+e.top[mbx].y2, &y2, 0)
+	e.left.y2, e.top[mbx].y2 = nz, nz
+	var deq [16]int
+	for k := range deq {
+		deq[k] = y2[k] * e.y2[btoi(k > 0)]
+	}
+	dcs := iwht(&deq)
+	for by := 0; by < 4; by++ {
+		for bx := 0; bx < 4; bx++ {
+			b := 4*by + bx
+			nz := e.putCoefficients(planeYAfterY2, e.left.y[by]+e.top[mbx].y[bx], &levels[b], 1)
+			e.left.y[by], e.top[mbx].y[bx] = nz, nz
+			var coef [16]int
+			coef[0] = dcs[b]
+			for k := 1; k < 16; k++ {
+				coef[k] = levels[b][k] * e.y1[1]
+			}
+			at := y0 + 4*by*stride + 4*bx
+			idct4(&coef, pred[4*by*16+4*bx:], 16, e.rec[0][at:], stride)
+		}
+	}
+
+	var uPred, vPred [64]uint8
+	mode = e.bestPredictor(uPred[:], []int{1, 2}, 8*mbx, 8*mby, 8)
+	switch mode {
+	case predDC:
+		h.put(false, 142)
+	case predV:
+		h.put(true, 142)
+		h.put(false, 114)
+	case predH:
+		h.put(true, 142)
+		h.put(true, 114)
+		h.put(false, 183)
+	case predTM:
+		h.put(true, 142)
+		h.put(true, 114)
+		h.put(true, 183)
+	}
+	predictBlock(uPred[:], mode, e.rec[1], e.stride[1], 8*mbx, 8*mby, 8)
+	predictBlock(vPred[:], mode, e.rec[2], e.stride[2], 8*mbx, 8*mby, 8)
+	e.encodeChroma(1, uPred[:], e.left.u[:], e.top[mbx].u[:], mbx, mby)
+	e.encodeChroma(2, vPred[:], e.left.v[:], e.top[mbx].v[:], mbx, mby)
+}
+
+// encodeChroma codes and reconstructs the four blocks of a chroma plane
+func (e *vp8Encoder) encodeChroma(plane int, pred []uint8, left, top []int, mbx, mby int) {
+	stride := e.stride[plane]
+	c0 := 8*mby*stride + 8*mbx
+	for by := 0; by < 2; by++ {
+		for bx := 0; bx < 2; bx++ {
+			at := c0 + 4*by*stride + 4*bx
+			p := pred[4*by*8+4*bx:]
+			coef := fdct4(e.src[plane][at:], p, stride, 8)
+			var levels [16]int
+			var deq [16]int
+			for k := range levels {
+				bias := 0.375
+				if k == 0 {
+					bias = 0.5
+				}
+				levels[k] = quantize(coef[k], e.uv[btoi(k > 0)], bias)
+				deq[k] = levels[k] * e.uv[btoi(k > 0)]
+			}
+			nz := e.putCoefficients(planeUV, left[by]+top[bx], &levels, 0)
+			left[by], top[bx] = nz, nz
+			idct4(&deq, p, 8, e.rec[plane][at:], stride)
+		}
+	}
+}
+
+// bestPredictor fills pred with the predictor of the n x n block at (x, y)
+// closest to the source of planes, and returns it. Predictors reading
+// outside of the image are skipped.
+func (e *vp8Encoder) bestPredictor(pred []uint8, planes []int, x, y, n int) int {
+	best, bestCost := predDC, -1
+	candidate := make([]uint8, n*n)
+	for mode := predDC; mode <= predTM; mode++ {
+		if (mode == predV || mode == predTM) && y == 0 || (mode == predH || mode == predTM) && x == 0 {
+			continue
+		}
+		cost := 0
+		for _, plane := range planes {
+			stride := e.stride[plane]
+			predictBlock(candidate, mode, e.rec[plane], stride, x, y, n)
+			for j := 0; j < n; j++ {
+				for i := 0; i < n; i++ {
+					d := int(e.src[plane][(y+j)*stride+x+i]) - int(candidate[j*n+i])
+					cost += d * d
+				}
+			}
+		}
+		if bestCost < 0 || cost < bestCost {
+			best, bestCost = mode, cost
+			if len(planes) == 1 {
+				copy(pred, candidate)
+			}
+		}
+	}
+	return best
+}
+
+// predictBlock fills pred with mode for the n x n block at (x, y) of the
+// reconstructed plane rec. The DC of a block on the top or left edge only
+// averages the other side, 128 in the corner.
+func predictBlock(pred []uint8, mode int, rec []uint8, stride, x, y, n int) {
+	var top []uint8
+	if y > 0 {
+		top = rec[(y-1)*stride+x:]
+	}
+	switch mode {
+	case predDC:
+		sum, count := 0, 0
+		if y > 0 {
+			for i := 0; i < n; i++ {
+				sum += int(top[i])
+			}
+			count += n
+		}
+		if x > 0 {
+			for j := 0; j < n; j++ {
+				sum += int(rec[(y+j)*stride+x-1])
+			}
+			count += n
+		}
+		v := uint8(128)
+		if count > 0 {
+			v = uint8((sum + count/2) / count)
+		}
+		for i := range pred[:n*n] {
+			pred[i] = v
+		}
+	case predV:
+		for j := 0; j < n; j++ {
+			copy(pred[j*n:(j+1)*n], top[:n])
+		}
+	case predH:
+		for j := 0; j < n; j++ {
+			v := rec[(y+j)*stride+x-1]
+			for i := 0; i < n; i++ {
+				pred[j*n+i] = v
+			}
+		}
+	case predTM:
+		tl := int(rec[(y-1)*stride+x-1])
+		for j := 0; j < n; j++ {
+			l := int(rec[(y+j)*stride+x-1])
+			for i := 0; i < n; i++ {
+				pred[j*n+i] = clampByte(float64(l + int(top[i]) - tl))
+			}
+		}
+	}
+}
+
+// fdct4 returns the DCT of the 4x4 residual of src over pred, scaled to the
+// inverse transform of RFC 6386: twice the orthonormal DCT
+func fdct4(src, pred []uint8, srcStride, predStride int) [16]float64 {
+	var r, tmp, out [16]float64
+	for j := 0; j < 4; j++ {
+		for i := 0; i < 4; i++ {
+			r[4*j+i] = float64(int(src[j*srcStride+i]) - int(pred[j*predStride+i]))
+		}
+	}
+	for j := 0; j < 4; j++ {
+		for u := 0; u < 4; u++ {
+			sum := 0.0
+			for i := 0; i < 4; i++ {
+				sum += r[4*j+i] * dct4Cos[u][i]
+			}
+			tmp[4*j+u] = sum
+		}
+	}
+	for v := 0; v < 4; v++ {
+		for u := 0; u < 4; u++ {
+			sum := 0.0
+			for j := 0; j < 4; j++ {
+				sum += tmp[4*j+u] * dct4Cos[v][j]
+			}
+			out[4*v+u] = 2 * sum
+		}
+	}
+	return out
+}
+
+// idct4 adds the inverse DCT of coef to pred into dst, as the decoder does
+func idct4(coef *[16]int, pred []uint8, predStride int, dst []uint8, dstStride int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int
+	for i := 0; i < 4; i++ {
+		a := coef[i] + coef[8+i]
+		b := coef[i] - coef[8+i]
+		c := (coef[4+i]*c2)>>16 - (coef[12+i]*c1)>>16
+		d := (coef[4+i]*c1)>>16 + (coef[12+i]*c2)>>16
+		m[i] = [4]int{a + d, b + c, b - c, a - d}
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		for i, v := range [4]int{a + d, b + c, b - c, a - d} {
+			dst[j*dstStride+i] = clampByte(float64(int(pred[j*predStride+i]) + v>>3))
+		}
+	}
+}
+
+// fwht returns the WHT of the 16 luma DCs, the inverse of iwht
+func fwht(dc [16]float64) [16]float64 {
+	h := [4][4]float64{{1, 1, 1, 1}, {1, 1, -1, -1}, {1, -1, -1, 1}, {1, -1, 1, -1}}
+	var tmp, out [16]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				tmp[4*i+j] += h[i][k] * dc[4*k+j]
+			}
+		}
+	}
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				out[4*i+j] += tmp[4*i+k] * h[k][j]
+			}
+			out[4*i+j] /= 2
+		}
+	}
+	return out
+}
+
+// iwht returns the luma DCs of the dequantized WHT, as the decoder does
+func iwht(coef *[16]int) [16]int {
+	var m, out [16]int
+	for i := 0; i < 4; i++ {
+		a0 := coef[i] + coef[12+i]
+		a1 := coef[4+i] + coef[8+i]
+		a2 := coef[4+i] - coef[8+i]
+		a3 := coef[i] - coef[12+i]
+		m[i], m[8+i], m[4+i], m[12+i] = a0+a1, a0-a1, a3+a2, a3-a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[4*i] + 3
+		a0 := dc + m[4*i+3]
+		a1 := m[4*i+1] + m[4*i+2]
+		a2 := m[4*i+1] - m[4*i+2]
+		a3 := dc - m[4*i+3]
+		out[4*i], out[4*i+1], out[4*i+2], out[4*i+3] = (a0+a1)>>3, (a3+a2)>>3, (a0-a1)>>3, (a3-a2)>>3
+	}
+	return out
+}
+
+// quantize divides c by step, rounding up from bias
+func quantize(c float64, step int, bias float64) int {
+	level := int(math.Abs(c)/float64(step) + bias)
+	if level > 2048 {
+		level = 2048
+	}
+	if c < 0 {
+		return -level
+	}
+	return level
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// putCoefficients writes the tokens of the levels of a block from first,
+// ctx being the number of neighbors with non zero coefficients, and returns
+// 1 when some were not zero
+func (e *vp8Encoder) putCoefficients(plane, ctx int, levels *[16]int, first int) int {
+	t := e.tokens
+	probs := &tokenProb[plane]
+	last := -1
+	for n := 15; n >= first; n-- {
+		if levels[zigzag[n]] != 0 {
+			last = n
+			break
+		}
+	}
+	p := &probs[bands[first]][ctx]
+	if last < 0 {
+		t.put(false, p[0])
+		return 0
+	}
+	t.put(true, p[0])
+	for n := first; n <= last; {
+		v := levels[zigzag[n]]
+		n++
+		if v == 0 {
+			t.put(false, p[1])
+			p = &probs[bands[n]][0]
+			continue
+		}
+		t.put(true, p[1])
+		a := v
+		if a < 0 {
+			a = -a
+		}
+		if a == 1 {
+			t.put(false, p[2])
+			p2 := &probs[bands[n]][1]
+			t.put(v < 0, 128)
+			p = p2
+		} else {
+			t.put(true, p[2])
+			putLevel(t, p, a)
+			t.put(v < 0, 128)
+			p = &probs[bands[n]][2]
+		}
+		if n == 16 {
+			return 1
+		}
+		// end of block
+		t.put(n <= last, p[0])
+	}
+	return 1
+}
+
+// putLevel writes the token tree of a level above 1
+func putLevel(t *boolEncoder, p *[11]uint8, a int) {
+	switch {
+	case a == 2:
+		t.put(false, p[3])
+		t.put(false, p[4])
+	case a <= 4:
+		t.put(false, p[3])
+		t.put(true, p[4])
+		t.put(a == 4, p[5])
+	case a <= 6:
+		t.put(true, p[3])
+		t.put(false, p[6])
+		t.put(false, p[7])
+		t.put(a == 6, 159)
+	case a <= 10:
+		t.put(true, p[3])
+		t.put(false, p[6])
+		t.put(true, p[7])
+		t.put((a-7)&2 != 0, 165)
+		t.put((a-7)&1 != 0, 145)
+	default:
+		cat := 3
+		for cat > 0 && a < 3+8<<cat {
+			cat--
+		}
+		t.put(true, p[3])
+		t.put(true, p[6])
+		t.put(cat&2 != 0, p[8])
+		t.put(cat&1 != 0, p[9+cat>>1])
+		extra := a - 3 - 8<<cat
+		probs := categoryProb[cat]
+		for i, prob := range probs {
+			t.put(extra>>(len(probs)-1-i)&1 != 0, prob)
+		}
+	}
+}
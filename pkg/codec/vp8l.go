@@ -0,0 +1,605 @@
+package codec
+
+import (
+	"container/heap"
+	"math/bits"
+)
+
+// VP8L, the lossless WebP bitstream. The encoder subtracts the green from
+// red and blue, predicts every pixel from its neighbors with the best of the
+// 14 predictors of each tile, and codes the residuals with LZ77 and one
+// group of prefix codes.
+
+const (
+	vp8lSignature = 0x2f
+	// vp8lMaxSize is the largest width or height
+	vp8lMaxSize = 1 << 14
+	// vp8lTileBits is the log2 of the size of the predictor tiles
+	vp8lTileBits  = 4
+	vp8lLiterals  = 256
+	vp8lLengths   = 24
+	vp8lDistances = 40
+	// vp8lPlaneCodes is the number of distance codes of the 2D neighborhood
+	vp8lPlaneCodes = 120
+	vp8lMaxLength  = 4096
+	vp8lMaxDist    = 1<<20 - vp8lPlaneCodes
+	vp8lMinMatch   = 3
+	vp8lHashBits   = 16
+	vp8lChain      = 16
+)
+
+// VP8L transforms
+const (
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+)
+
+// codeLengthOrder is the order of the code lengths of the code length code
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// bitWriter writes bits least significant first
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.bits |= uint64(v) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nBits -= 8
+	}
+}
+
+// bytes pads the last byte with zeros and returns the stream
+func (w *bitWriter) bytes() []byte {
+	if w.nBits > 0 {
+		w.write(0, 8-w.nBits)
+	}
+	return w.buf
+}
+
+// encodeVP8L returns the VP8L bitstream of pixels, packed as ARGB
+func encodeVP8L(argb []uint32, width, height int, alpha bool) []byte {
+	var w bitWriter
+	w.write(vp8lSignature, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if alpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	// version
+	w.write(0, 3)
+	writeImageStream(&w, argb, width, height, true)
+	return w.bytes()
+}
+
+// writeImageStream writes the transforms and the main image, the headerless
+// stream of an ALPH chunk when subtractGreen is false
+func writeImageStream(w *bitWriter, argb []uint32, width, height int, subtractGreen bool) {
+	pix := make([]uint32, len(argb))
+	copy(pix, argb)
+	if subtractGreen {
+		w.write(1, 1)
+		w.write(transformSubtractGreen, 2)
+		for i, p := range pix {
+			g := p >> 8 & 0xff
+			r := (p>>16 - g) & 0xff
+			b := (p - g) & 0xff
+			pix[i] = p&0xff00ff00 | r<<16 | b
+		}
+	}
+	w.write(1, 1)
+	w.write(transformPredictor, 2)
+	w.write(vp8lTileBits-2, 3)
+	modes, tilesW := predict(pix, width, height)
+	writeEntropyImage(w, modes, tilesW, false)
+	// no more transforms
+	w.write(0, 1)
+	writeEntropyImage(w, pix, width, true)
+}
+
+// writeEntropyImage writes the prefix codes and the LZ77 coded pixels, with
+// no color cache and, for the main image, a single prefix code group
+func writeEntropyImage(w *bitWriter, pix []uint32, width int, main bool) {
+	w.write(0, 1)
+	if main {
+		w.write(0, 1)
+	}
+	tokens := lz77(pix, width)
+	var freq [5][]int
+	for i, n := range []int{vp8lLiterals + vp8lLengths, vp8lLiterals, vp8lLiterals, vp8lLiterals, vp8lDistances} {
+		freq[i] = make([]int, n)
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			freq[0][t.argb>>8&0xff]++
+			freq[1][t.argb>>16&0xff]++
+			freq[2][t.argb&0xff]++
+			freq[3][t.argb>>24]++
+			continue
+		}
+		code, _, _ := prefixCode(t.length)
+		freq[0][vp8lLiterals+code]++
+		code, _, _ = prefixCode(t.dist)
+		freq[4][code]++
+	}
+	// the first prefix code is always read, there must be one symbol
+	if len(tokens) == 0 {
+		freq[0][0] = 1
+	}
+	var codes [5]*prefixCodes
+	for i := range codes {
+		codes[i] = newPrefixCodes(freq[i], 15)
+		codes[i].writeTo(w)
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].put(w, int(t.argb>>8&0xff))
+			codes[1].put(w, int(t.argb>>16&0xff))
+			codes[2].put(w, int(t.argb&0xff))
+			codes[3].put(w, int(t.argb>>24))
+			continue
+		}
+		code, n, extra := prefixCode(t.length)
+		codes[0].put(w, vp8lLiterals+code)
+		w.write(uint32(extra), uint(n))
+		code, n, extra = prefixCode(t.dist)
+		codes[4].put(w, code)
+		w.write(uint32(extra), uint(n))
+	}
+}
+
+// prefixCode splits a length or distance code into its prefix code and its
+// extra bits
+func prefixCode(v int) (code, n, extra int) {
+	if v <= 4 {
+		return v - 1, 0, 0
+	}
+	v--
+	high := bits.Len(uint(v)) - 1
+	second := v >> (high - 1) & 1
+	n = high - 1
+	return 2*high + second, n, v & (1<<n - 1)
+}
+
+// vp8lToken is a literal pixel when length is 0, else a backward reference
+// with its distance code
+type vp8lToken struct {
+	argb         uint32
+	length, dist int
+}
+
+// lz77 finds the backward references of pix with hash chains on pairs of
+// pixels
+func lz77(pix []uint32, width int) []vp8lToken {
+	n := len(pix)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+	hash := func(i int) uint32 {
+		return (pix[i]*0x1e35a7bd ^ pix[i+1]*0x9e3779b1) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	var tokens []vp8lToken
+	for i := 0; i < n; {
+		best, dist := 0, 0
+		if i+1 < n {
+			max := n - i
+			if max > vp8lMaxLength {
+				max = vp8lMaxLength
+			}
+			for c, k := head[hash(i)], 0; c >= 0 && k < vp8lChain && i-int(c) <= vp8lMaxDist; c, k = prev[c], k+1 {
+				l := 0
+				for l < max && pix[int(c)+l] == pix[i+l] {
+					l++
+				}
+				if l > best {
+					best, dist = l, i-int(c)
+					if l == max {
+						break
+					}
+				}
+			}
+		}
+		if best < vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{argb: pix[i]})
+			insert(i)
+			i++
+			continue
+		}
+		tokens = append(tokens, vp8lToken{length: best, dist: distanceCode(dist, width)})
+		for end := i + best; i < end; i++ {
+			insert(i)
+		}
+	}
+	return tokens
+}
+
+// distanceCode maps the pixel above and the one on the left to their short
+// codes of the 2D neighborhood
+func distanceCode(dist, width int) int {
+	switch dist {
+	case width:
+		return 1
+	case 1:
+		return 2
+	}
+	return dist + vp8lPlaneCodes
+}
+
+// predict replaces pix by its residuals and returns the predictor of every
+// tile, in the green of the tile image
+func predict(pix []uint32, width, height int) ([]uint32, int) {
+	size := 1 << vp8lTileBits
+	tilesW, tilesH := (width+size-1)/size, (height+size-1)/size
+	modes := make([]uint32, tilesW*tilesH)
+	residuals := make([]uint32, len(pix))
+	for ty := 0; ty < tilesH; ty++ {
+		for tx := 0; tx < tilesW; tx++ {
+			best, bestCost := 0, -1
+			for mode := 0; mode < 14; mode++ {
+				cost := 0
+				for y := ty * size; y < (ty+1)*size && y < height; y++ {
+					for x := tx * size; x < (tx+1)*size && x < width; x++ {
+						i := y*width + x
+						r := subPixels(pix[i], predictPixel(pix, i, x, y, width, mode))
+						cost += absByte(r) + absByte(r>>8) + absByte(r>>16) + absByte(r>>24)
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[ty*tilesW+tx] = uint32(best) << 8
+			for y := ty * size; y < (ty+1)*size && y < height; y++ {
+				for x := tx * size; x < (tx+1)*size && x < width; x++ {
+					i := y*width + x
+					residuals[i] = subPixels(pix[i], predictPixel(pix, i, x, y, width, best))
+				}
+			}
+		}
+	}
+	copy(pix, residuals)
+	return modes, tilesW
+}
+
+func absByte(v uint32) int {
+	b := int(int8(v))
+	if b < 0 {
+		return -b
+	}
+	return b
+}
+
+// predictPixel predicts pixel i with mode, the first row and column having
+// their own predictors
+func predictPixel(pix []uint32, i, x, y, width, mode int) uint32 {
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return pix[i-1]
+	case x == 0:
+		return pix[i-width]
+	}
+	l, t, tl, tr := pix[i-1], pix[i-width], pix[i-width-1], pix[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPixel(l, t, tl)
+	case 12:
+		return perChannel(l, t, tl, func(a, b, c int) int { return a + b - c })
+	}
+	return perChannel(average2(l, t), tl, 0, func(a, b, _ int) int { return a + (a-b)/2 })
+}
+
+func average2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+// selectPixel returns l or t, the one closer to the gradient l+t-tl
+func selectPixel(l, t, tl uint32) uint32 {
+	pl, pt := 0, 0
+	for s := 0; s < 32; s += 8 {
+		c := int(tl >> s & 0xff)
+		pl += abs(c - int(t>>s&0xff))
+		pt += abs(c - int(l>>s&0xff))
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+// perChannel applies f to the channels and clamps the results to a byte
+func perChannel(a, b, c uint32, f func(a, b, c int) int) uint32 {
+	var p uint32
+	for s := 0; s < 32; s += 8 {
+		v := f(int(a>>s&0xff), int(b>>s&0xff), int(c>>s&0xff))
+		if v < 0 {
+			v = 0
+		} else if v > 255 {
+			v = 255
+		}
+		p |= uint32(v) << s
+	}
+	return p
+}
+
+// subPixels subtracts b from a channel by channel, modulo 256
+func subPixels(a, b uint32) uint32 {
+	ag := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	rb := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// prefixCodes are canonical Huffman codes, written bit reversed
+type prefixCodes struct {
+	// lengths are the code lengths as written in the stream
+	lengths []int
+	codes   []uint32
+	// used is the number of symbols of non zero length, a lone symbol
+	// takes no bits
+	used int
+}
+
+func newPrefixCodes(freq []int, limit int) *prefixCodes {
+	p := &prefixCodes{lengths: huffmanLengths(freq, limit)}
+	for _, l := range p.lengths {
+		if l > 0 {
+			p.used++
+		}
+	}
+	p.codes = canonicalCodes(p.lengths)
+	return p
+}
+
+func (p *prefixCodes) put(w *bitWriter, symbol int) {
+	if p.used > 1 {
+		w.write(p.codes[symbol], uint(p.lengths[symbol]))
+	}
+}
+
+// writeTo writes a simple code for up to two literals, else the code lengths
+// coded with the code length code
+func (p *prefixCodes) writeTo(w *bitWriter) {
+	var symbols []int
+	for s, l := range p.lengths {
+		if l > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) <= 2 && (len(symbols) == 0 || symbols[len(symbols)-1] < 256) {
+		if len(symbols) == 0 {
+			symbols = []int{0}
+		}
+		w.write(1, 1)
+		w.write(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(symbols[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(symbols[0]), 8)
+		}
+		if len(symbols) == 2 {
+			w.write(uint32(symbols[1]), 8)
+		}
+		return
+	}
+	w.write(0, 1)
+	// run length code the lengths: 16 repeats the previous length 3 to 6
+	// times, 17 and 18 repeat zeros 3 to 10 and 11 to 138 times
+	type rle struct{ symbol, extra int }
+	var runs []rle
+	for i := 0; i < len(p.lengths); {
+		l := p.lengths[i]
+		n := 1
+		for i+n < len(p.lengths) && p.lengths[i+n] == l {
+			n++
+		}
+		i += n
+		if l == 0 {
+			for n >= 11 {
+				r := n
+				if r > 138 {
+					r = 138
+				}
+				runs = append(runs, rle{18, r - 11})
+				n -= r
+			}
+			if n >= 3 {
+				runs = append(runs, rle{17, n - 3})
+				n = 0
+			}
+		} else {
+			runs = append(runs, rle{l, 0})
+			n--
+			for n >= 3 {
+				r := n
+				if r > 6 {
+					r = 6
+				}
+				runs = append(runs, rle{16, r - 3})
+				n -= r
+			}
+		}
+		for ; n > 0; n-- {
+			runs = append(runs, rle{l, 0})
+		}
+	}
+	freq := make([]int, len(codeLengthOrder))
+	for _, r := range runs {
+		freq[r.symbol]++
+	}
+	lengthCodes := newPrefixCodes(freq, 7)
+	n := len(codeLengthOrder)
+	for n > 4 && lengthCodes.lengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	w.write(uint32(n-4), 4)
+	for _, s := range codeLengthOrder[:n] {
+		w.write(uint32(lengthCodes.lengths[s]), 3)
+	}
+	// all the symbols are coded
+	w.write(0, 1)
+	for _, r := range runs {
+		lengthCodes.put(w, r.symbol)
+		switch r.symbol {
+		case 16:
+			w.write(uint32(r.extra), 2)
+		case 17:
+			w.write(uint32(r.extra), 3)
+		case 18:
+			w.write(uint32(r.extra), 7)
+		}
+	}
+}
+
+// huffmanLengths returns the code lengths of the frequencies, up to limit
+// bits. Frequencies are halved until the tree is shallow enough.
+func huffmanLengths(freq []int, limit int) []int {
+	f := make([]int, len(freq))
+	copy(f, freq)
+	for {
+		lengths := treeLengths(f)
+		max := 0
+		for _, l := range lengths {
+			if l > max {
+				max = l
+			}
+		}
+		if max <= limit {
+			return lengths
+		}
+		for i, v := range f {
+			if v > 0 {
+				f[i] = (v + 1) / 2
+			}
+		}
+	}
+}
+
+type huffmanNode struct {
+	freq, index int
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	return h[i].freq < h[j].freq || h[i].freq == h[j].freq && h[i].index < h[j].index
+}
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// treeLengths returns the depths of the leaves of the Huffman tree, a lone
+// symbol having length 1
+func treeLengths(freq []int) []int {
+	lengths := make([]int, len(freq))
+	h := &huffmanHeap{}
+	parent := make([]int, len(freq))
+	for s, f := range freq {
+		parent[s] = -1
+		if f > 0 {
+			*h = append(*h, huffmanNode{f, s})
+		}
+	}
+	switch h.Len() {
+	case 0:
+		return lengths
+	case 1:
+		lengths[(*h)[0].index] = 1
+		return lengths
+	}
+	heap.Init(h)
+	for h.Len() > 1 {
+		a := heap.Pop(h).(huffmanNode)
+		b := heap.Pop(h).(huffmanNode)
+		node := len(parent)
+		parent = append(parent, -1)
+		parent[a.index], parent[b.index] = node, node
+		heap.Push(h, huffmanNode{a.freq + b.freq, node})
+	}
+	for s, f := range freq {
+		if f == 0 {
+			continue
+		}
+		for n := s; parent[n] >= 0; n = parent[n] {
+			lengths[s]++
+		}
+	}
+	return lengths
+}
+
+// canonicalCodes assigns consecutive codes to the symbols of each length, in
+// symbol order, and reverses them for the least significant first stream
+func canonicalCodes(lengths []int) []uint32 {
+	var count [16]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < 16; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for s, l := range lengths {
+		if l > 0 {
+			codes[s] = bits.Reverse32(next[l]) >> (32 - l)
+			next[l]++
+		}
+	}
+	return codes
+}
@@ -0,0 +1,109 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// WebPOptions of EncodeWebP
+type WebPOptions struct {
+	// Quality from 1 to 100 of lossy images, 90 when 0
+	Quality int
+	// Lossless ignores Quality
+	Lossless bool
+}
+
+// EncodeWebP writes img as a lossy VP8 WebP, or VP8L when o.Lossless. The
+// transparency of lossy images is kept losslessly in an alpha chunk.
+func EncodeWebP(w io.Writer, img image.Image, o *WebPOptions) error {
+	var opts WebPOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.Quality == 0 {
+		opts.Quality = 90
+	} else if opts.Quality < 1 || opts.Quality > 100 {
+		return fmt.Errorf("unable to encode WebP at quality %d", opts.Quality)
+	}
+	b := img.Bounds()
+	limit := vp8MaxSize
+	if opts.Lossless {
+		limit = vp8lMaxSize
+	}
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() > limit || b.Dy() > limit {
+		return fmt.Errorf("unable to encode a %dx%d image as WebP", b.Dx(), b.Dy())
+	}
+	m := toNRGBA(img)
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	alpha := !m.Opaque()
+	var chunks bytes.Buffer
+	if opts.Lossless {
+		writeChunk(&chunks, "VP8L", encodeVP8L(argbPixels(m, false), width, height, alpha))
+	} else {
+		if alpha {
+			// the canvas size follows the alpha flag and 3 reserved bytes
+			vp8x := []byte{0x10, 0, 0, 0, byte(width - 1), byte((width - 1) >> 8), byte((width - 1) >> 16),
+				byte(height - 1), byte((height - 1) >> 8), byte((height - 1) >> 16)}
+			writeChunk(&chunks, "VP8X", vp8x)
+			// the alpha values are the green of a lossless image stream,
+			// unfiltered
+			var bw bitWriter
+			writeImageStream(&bw, argbPixels(m, true), width, height, false)
+			writeChunk(&chunks, "ALPH", append([]byte{1}, bw.bytes()...))
+		}
+		writeChunk(&chunks, "VP8 ", encodeVP8(m, opts.Quality))
+	}
+	header := make([]byte, 12)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+chunks.Len()))
+	copy(header[8:], "WEBP")
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := chunks.WriteTo(w)
+	return err
+}
+
+// writeChunk appends a RIFF chunk, padded to an even size
+func writeChunk(buf *bytes.Buffer, fourCC string, data []byte) {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+	buf.WriteString(fourCC)
+	buf.Write(size[:])
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// toNRGBA returns img as non premultiplied RGBA with its origin at 0, 0
+func toNRGBA(img image.Image) *image.NRGBA {
+	if m, ok := img.(*image.NRGBA); ok && m.Rect.Min == (image.Point{}) {
+		return m
+	}
+	b := img.Bounds()
+	m := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(m, m.Rect, img, b.Min, draw.Src)
+	return m
+}
+
+// argbPixels packs the pixels of m as ARGB, or only their alpha in the green
+func argbPixels(m *image.NRGBA, alphaOnly bool) []uint32 {
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	argb := make([]uint32, 0, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := m.Pix[m.PixOffset(x, y):]
+			if alphaOnly {
+				argb = append(argb, 0xff000000|uint32(p[3])<<8)
+				continue
+			}
+			argb = append(argb, uint32(p[3])<<24|uint32(p[0])<<16|uint32(p[1])<<8|uint32(p[2]))
+		}
+	}
+	return argb
+}
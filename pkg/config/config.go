@@ -1,9 +1,28 @@
 package config
 
 import (
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
 	log "github.com/sirupsen/logrus"
 )
 
+// Sets modes, how bursts and brackets are presented
+const (
+	// SetsSubdir places each set in its own subdirectory
+	SetsSubdir = "subdir"
+	// SetsSidecar tags the photos of a set in XMP sidecars
+	SetsSidecar = "sidecar"
+)
+
+// Preflight modes, an empty mode aborts like PreflightAbort
+const (
+	PreflightAbort = "abort"
+	PreflightWarn  = "warn"
+	PreflightOff   = "off"
+)
+
 type Config struct {
 	DestFileFormat  string
 	DestDirectory   string
@@ -11,6 +30,42 @@ type Config struct {
 	NoRecurse       bool
 	Verbose         bool
 	Workers         int
+	Watch           bool
+	WatchSettle     time.Duration
+	ManifestPath    string
+	BagIt           bool
+	BagInfo         map[string]string
+	ReportPath      string
+	ReportFormat    string
+	Preflight       string
+	// ReadLimit and WriteLimit are shared by all the workers, in bytes per
+	// second, 0 is unlimited
+	ReadLimit  int64
+	WriteLimit int64
+	// IOClass and Nice lower the priority of the process, see throttle.SetPriority
+	IOClass string
+	Nice    int
+	// ReadWorkers is the number of readers extracting metadata and hashes,
+	// Workers the number of writers copying to the destination
+	ReadWorkers int
+	// DeviceReaders bounds the concurrent reads per source device, 0 is unlimited
+	DeviceReaders int
+	// AutoTune varies the number of writers with the observed byte rate, up
+	// to Workers
+	AutoTune bool
+	// SimilarHash is the perceptual hash used to flag the photos looking like
+	// another imported or already present one, empty disables it
+	SimilarHash      string
+	SimilarThreshold int
+	// Sets groups bursts and brackets, empty disables it. Photos of a set
+	// are at most SetGap apart.
+	Sets   string
+	SetGap time.Duration
+	// EventGap and EventDistance (km) split the photos into events when
+	// DestFileFormat holds {event}. PlacesPath names the events after places.
+	EventGap      time.Duration
+	EventDistance float64
+	PlacesPath    string
 }
 
 func (c *Config) PrintConfig() {
@@ -20,5 +75,68 @@ func (c *Config) PrintConfig() {
 	log.Infof(" * SourceDirectory = %v", c.SourceDirectory)
 	log.Infof(" * NoRecurse = %v", c.NoRecurse)
 	log.Infof(" * Verbose = %v", c.Verbose)
-	log.Infof(" * Running with %d workers", c.Workers)
+	log.Infof(" * Running with %d readers and %d writers", c.ReadWorkers, c.Workers)
+	if c.DeviceReaders > 0 {
+		log.Infof(" * At most %d readers per source device", c.DeviceReaders)
+	}
+	if c.AutoTune {
+		log.Infof(" * Auto-tuning the number of writers")
+	}
+	if c.ManifestPath != "" {
+		log.Infof(" * ManifestPath = %v", c.ManifestPath)
+	}
+	if c.BagIt {
+		log.Infof(" * Writing a BagIt bag")
+	}
+	if c.ReportPath != "" {
+		log.Infof(" * ReportPath = %v", c.ReportPath)
+	}
+	if c.Preflight != "" {
+		log.Infof(" * Preflight = %v", c.Preflight)
+	}
+	if c.ReadLimit > 0 || c.WriteLimit > 0 {
+		log.Infof(" * ReadLimit = %v, WriteLimit = %v", limitString(c.ReadLimit), limitString(c.WriteLimit))
+	}
+	if c.IOClass != "" || c.Nice != 0 {
+		log.Infof(" * IOClass = %v, Nice = %d", c.IOClass, c.Nice)
+	}
+	if c.SimilarHash != "" {
+		log.Infof(" * Flagging similar photos with %v, threshold %d", c.SimilarHash, c.SimilarThreshold)
+	}
+	if c.Sets != "" {
+		log.Infof(" * Grouping bursts and brackets in %v, at most %v apart", c.Sets, c.SetGap)
+	}
+	if strings.Contains(c.DestFileFormat, "{event}") {
+		log.Infof(" * Splitting events on %v pauses or %vkm trips", c.EventGap, c.EventDistance)
+		if c.PlacesPath != "" {
+			log.Infof(" * PlacesPath = %v", c.PlacesPath)
+		}
+	}
+	if c.Watch {
+		log.Infof(" * Watching source, settle time %v", c.WatchSettle)
+	}
+}
+
+func limitString(bytesPerSec int64) string {
+	if bytesPerSec <= 0 {
+		return "unlimited"
+	}
+	return bytefmt.ByteSize(uint64(bytesPerSec)) + "/s"
+}
+
+// CardProfile overrides the copy settings for a given memory card, matched
+// by volume UUID or label
+type CardProfile struct {
+	Name           string `mapstructure:"name"`
+	UUID           string `mapstructure:"uuid"`
+	Label          string `mapstructure:"label"`
+	DestDirectory  string `mapstructure:"dest_directory"`
+	DestFileFormat string `mapstructure:"dest_file_format"`
+}
+
+func (p *CardProfile) Matches(uuid, label string) bool {
+	if p.UUID != "" {
+		return strings.EqualFold(p.UUID, uuid)
+	}
+	return p.Label != "" && p.Label == label
 }
@@ -0,0 +1,168 @@
+package dupes
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// Actions on the extra copies of a group
+const (
+	ActionReport   = "report"
+	ActionDelete   = "delete"
+	ActionHardlink = "hardlink"
+	ActionMove     = "move"
+)
+
+// Applier applies an action to the extra copies of every group
+type Applier struct {
+	Action string
+	// Quarantine is the directory extras are moved to by ActionMove, their
+	// absolute path is mirrored below it
+	Quarantine string
+	// DryRun only logs what would be done
+	DryRun bool
+	// ForceSimilar deletes or moves the extras of similar groups whose
+	// contents differ from the kept copy too. They are left alone by default,
+	// identical extras are handled as in any group.
+	ForceSimilar bool
+}
+
+func (a *Applier) Validate() error {
+	switch a.Action {
+	case ActionReport, ActionDelete, ActionHardlink:
+	case ActionMove:
+		if a.Quarantine == "" {
+			return fmt.Errorf("action %v needs a quarantine directory", a.Action)
+		}
+	default:
+		return fmt.Errorf("unknown action %v. only %s, %s, %s or %s", a.Action, ActionReport, ActionDelete, ActionHardlink, ActionMove)
+	}
+	return nil
+}
+
+// Apply runs the action on every extra copy. Copies that could not be
+// handled are returned as a *utils.PartialError.
+func (a *Applier) Apply(groups []*Group) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	if a.Action == ActionReport {
+		return nil
+	}
+	var errs utils.ErrorCollector
+	total := 0
+	for _, g := range groups {
+		keep := g.Keep()
+		for _, extra := range g.Extras() {
+			total += 1
+			if err := a.refused(g, keep, extra); err != nil {
+				log.Errorf("unable to %v %v. err=%v", a.Action, extra.Path, err.Error())
+				errs.Add(extra.Path, err)
+				continue
+			}
+			if a.DryRun {
+				log.Infof("would %v %v, keeping %v", a.Action, extra.Path, keep.Path)
+				continue
+			}
+			var err error
+			switch a.Action {
+			case ActionHardlink:
+				err = hardlink(keep.Path, extra.Path)
+			case ActionDelete:
+				err = os.Remove(extra.Path)
+			case ActionMove:
+				err = move(extra.Path, a.quarantinePath(extra.Path))
+			}
+			if err != nil {
+				log.Errorf("unable to %v %v. err=%v", a.Action, extra.Path, err.Error())
+				errs.Add(extra.Path, err)
+				continue
+			}
+			log.Debugf("%v %v, keeping %v", a.Action, extra.Path, keep.Path)
+		}
+	}
+	return errs.Err(total)
+}
+
+// refused tells why extra is left alone, nil when it is not
+func (a *Applier) refused(g *Group, keep, extra File) error {
+	switch {
+	case !g.Similar, extra.Sha256 != "" && extra.Sha256 == keep.Sha256:
+		return nil
+	case a.Action == ActionHardlink:
+		return fmt.Errorf("not linked to %v, the contents differ", keep.Path)
+	case !a.ForceSimilar:
+		return fmt.Errorf("only similar to %v, similar copies are kept unless forced", keep.Path)
+	}
+	return nil
+}
+
+func (a *Applier) quarantinePath(filePath string) string {
+	rel := strings.TrimPrefix(filePath, filepath.VolumeName(filePath))
+	return filepath.Join(a.Quarantine, rel)
+}
+
+// hardlink replaces extra with a hard link to keep. The link is created
+// next to extra then renamed over it, so extra is never lost.
+func hardlink(keep, extra string) error {
+	keepInfo, err := os.Stat(keep)
+	if err != nil {
+		return err
+	}
+	extraInfo, err := os.Stat(extra)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(keepInfo, extraInfo) {
+		return nil
+	}
+	tmp := extra + ".gophoto-link"
+	if err := os.Link(keep, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, extra); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// move renames src to dst, copying it when they are on different filesystems
+func move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%v already exists", dst)
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,287 @@
+package dupes
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/phash"
+	"github.com/vfoucault/goPhoto/pkg/photo"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// File is one copy of a duplicated photo
+type File struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Sha256  string    `json:"sha256"`
+	// hash is the perceptual hash, in similar groups
+	hash phash.Hash
+}
+
+// Group holds byte-identical files, or perceptually similar ones when
+// Similar is set. Once ordered with Sort, Files[0] is the copy to keep.
+type Group struct {
+	// Sha256 is empty for similar groups
+	Sha256  string `json:"sha256,omitempty"`
+	Similar bool   `json:"similar,omitempty"`
+	Files   []File `json:"files"`
+	// threshold is the largest distance of a similar copy to the kept one
+	threshold int
+}
+
+// Keep returns the best copy
+func (g *Group) Keep() File {
+	return g.Files[0]
+}
+
+// Extras returns every copy but the best one
+func (g *Group) Extras() []File {
+	return g.Files[1:]
+}
+
+// Wasted returns the size of the extra copies
+func (g *Group) Wasted() int64 {
+	var size int64
+	for _, f := range g.Extras() {
+		size += f.Size
+	}
+	return size
+}
+
+// Find hashes the photos of every root with workers readers and returns the
+// groups of identical files, largest files first. Files that cannot be read
+// are returned as a *utils.PartialError along with the groups.
+func Find(ctx context.Context, roots []string, workers int) ([]*Group, error) {
+	all, err := scan(ctx, roots, workers)
+	var groups []*Group
+	for _, g := range all {
+		if len(g.Files) > 1 {
+			groups = append(groups, g)
+		}
+	}
+	return groups, err
+}
+
+// scan returns every file of roots grouped by sha256, largest files first
+func scan(ctx context.Context, roots []string, workers int) ([]*Group, error) {
+	var errs utils.ErrorCollector
+	total := 0
+	seen := make(map[string]bool)
+	bySum := make(map[string]*Group)
+	for _, root := range roots {
+		copier := photo.NewCopier(&config.Config{SourceDirectory: root, ReadWorkers: workers}, ctx)
+		copier.HashOnly = true
+		err := copier.Search()
+		copier.Stop()
+		if err != nil {
+			return nil, err
+		}
+		total += len(copier.Photos) + copier.Errors.Len()
+		for _, failed := range append(copier.Errors.Failed(), copier.WalkErrors.Failed()...) {
+			errs.Add(failed.Path, failed.Err)
+		}
+		for _, p := range copier.Photos {
+			filePath, err := filepath.Abs(filepath.Join(p.Path, p.FileName))
+			if err != nil {
+				errs.Add(filepath.Join(p.Path, p.FileName), err)
+				continue
+			}
+			// overlapping roots
+			if seen[filePath] {
+				continue
+			}
+			// a symlink hashes as its target, keeping it could delete the
+			// only real copy
+			info, err := os.Lstat(filePath)
+			if err != nil {
+				errs.Add(filePath, err)
+				continue
+			}
+			if !info.Mode().IsRegular() {
+				log.Debugf("skipping %v, not a regular file", filePath)
+				total--
+				continue
+			}
+			seen[filePath] = true
+			sum := hex.EncodeToString(p.Sha256)
+			g, ok := bySum[sum]
+			if !ok {
+				g = &Group{Sha256: sum}
+				bySum[sum] = g
+			}
+			g.Files = append(g.Files, File{Path: filePath, Size: p.Size, ModTime: p.Mtime, Sha256: sum})
+		}
+	}
+
+	groups := make([]*Group, 0, len(bySum))
+	for _, g := range bySum {
+		groups = append(groups, g)
+	}
+	sortGroups(groups)
+	return groups, errs.Err(total)
+}
+
+func sortGroups(groups []*Group) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Files[0].Size != groups[j].Files[0].Size {
+			return groups[i].Files[0].Size > groups[j].Files[0].Size
+		}
+		return groups[i].Files[0].Path < groups[j].Files[0].Path
+	})
+}
+
+// Rule compares two copies of a file: negative when a should be kept over
+// b, positive when b should be kept over a, 0 when the rule can't tell
+type Rule func(a, b File) int
+
+// ParseRules parses a comma separated list of rules, applied in order:
+//
+//	prefer:<dir>  keep the copies under dir
+//	avoid:<dir>   don't keep the copies under dir
+//	shortest      keep the shortest path
+//	longest       keep the longest path
+//	oldest        keep the oldest modification time
+//	newest        keep the newest modification time
+//	largest       keep the largest file, for similar groups
+//	smallest      keep the smallest file, for similar groups
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		name, arg, _ := strings.Cut(spec, ":")
+		switch name {
+		case "":
+			continue
+		case "prefer", "avoid":
+			if arg == "" {
+				return nil, fmt.Errorf("rule %v needs a directory, e.g. %v:/srv/photos", name, name)
+			}
+			dir, err := filepath.Abs(arg)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve %v. err=%w", arg, err)
+			}
+			rules = append(rules, underRule(dir, name == "prefer"))
+		case "shortest":
+			rules = append(rules, func(a, b File) int { return len(a.Path) - len(b.Path) })
+		case "longest":
+			rules = append(rules, func(a, b File) int { return len(b.Path) - len(a.Path) })
+		case "oldest":
+			rules = append(rules, func(a, b File) int { return compareTime(a.ModTime, b.ModTime) })
+		case "newest":
+			rules = append(rules, func(a, b File) int { return compareTime(b.ModTime, a.ModTime) })
+		case "largest":
+			rules = append(rules, func(a, b File) int { return compareSize(b.Size, a.Size) })
+		case "smallest":
+			rules = append(rules, func(a, b File) int { return compareSize(a.Size, b.Size) })
+		default:
+			return nil, fmt.Errorf("unknown rule %v. only prefer:<dir>, avoid:<dir>, shortest, longest, oldest, newest, largest or smallest", spec)
+		}
+	}
+	return rules, nil
+}
+
+func underRule(dir string, prefer bool) Rule {
+	under := func(f File) bool {
+		return f.Path == dir || strings.HasPrefix(f.Path, dir+string(filepath.Separator))
+	}
+	return func(a, b File) int {
+		ua, ub := under(a), under(b)
+		switch {
+		case ua == ub:
+			return 0
+		case ua == prefer:
+			return -1
+		default:
+			return 1
+		}
+	}
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareSize(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Sort orders the copies of every group by rules, ties are broken by path
+// so that the result is stable. The copies of a similar group that are not
+// within its threshold of the kept copy are removed from the group.
+func Sort(groups []*Group, rules []Rule) {
+	for _, g := range groups {
+		sort.SliceStable(g.Files, func(i, j int) bool {
+			for _, rule := range rules {
+				if c := rule(g.Files[i], g.Files[j]); c != 0 {
+					return c < 0
+				}
+			}
+			return g.Files[i].Path < g.Files[j].Path
+		})
+		if g.Similar {
+			g.Files = near(g.Files, g.threshold)
+		}
+	}
+}
+
+// near returns files[0] and the files within threshold of it. Similar
+// groups chain matches, the ends of a chain can be far apart.
+func near(files []File, threshold int) []File {
+	kept := files[:1]
+	for _, f := range files[1:] {
+		if d := phash.Distance(files[0].hash, f.hash); d > threshold {
+			log.Debugf("leaving %v out, %d bits from %v", f.Path, d, files[0].Path)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// DropSameFiles removes the copies that are the same file as the kept one,
+// hard links of it, and the groups left with a single copy. Call it after
+// Sort.
+func DropSameFiles(groups []*Group) []*Group {
+	var kept []*Group
+	for _, g := range groups {
+		keepInfo, err := os.Stat(g.Keep().Path)
+		if err != nil {
+			kept = append(kept, g)
+			continue
+		}
+		files := g.Files[:1]
+		for _, extra := range g.Extras() {
+			if info, err := os.Stat(extra.Path); err == nil && os.SameFile(keepInfo, info) {
+				log.Debugf("skipping %v, same file as %v", extra.Path, g.Keep().Path)
+				continue
+			}
+			files = append(files, extra)
+		}
+		g.Files = files
+		if len(g.Files) > 1 {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
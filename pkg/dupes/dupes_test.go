@@ -0,0 +1,183 @@
+package dupes
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vfoucault/goPhoto/pkg/phash"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+func TestFindAndApply(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"library/2022/a.jpg": "photo a",
+		"import/a.jpg":       "photo a",
+		"import/old/a.jpg":   "photo a",
+		"import/b.jpg":       "photo b",
+		"library/b.txt":      "photo b",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(filePath), 0750)
+		if err := os.WriteFile(filePath, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := Find(context.Background(), []string{filepath.Join(root, "library"), filepath.Join(root, "import")}, 2)
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Files) != 3 {
+		t.Fatalf("Find() got groups %+v", groups)
+	}
+	rules, err := ParseRules("avoid:" + filepath.Join(root, "import") + ",shortest")
+	if err != nil {
+		t.Fatalf("ParseRules() error = %v", err)
+	}
+	Sort(groups, rules)
+	want := []string{"library/2022/a.jpg", "import/a.jpg", "import/old/a.jpg"}
+	for i, f := range groups[0].Files {
+		if f.Path != filepath.Join(root, want[i]) {
+			t.Errorf("Sort() got file %d = %v, want %v", i, f.Path, want[i])
+		}
+	}
+
+	quarantine := filepath.Join(root, "quarantine")
+	if err := (&Applier{Action: ActionMove, Quarantine: quarantine}).Apply(groups); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "import/a.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Apply() left import/a.jpg")
+	}
+	if _, err := os.Stat(filepath.Join(quarantine, root, "import/old/a.jpg")); err != nil {
+		t.Errorf("Apply() did not move import/old/a.jpg. err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "library/2022/a.jpg")); err != nil {
+		t.Errorf("Apply() removed the kept copy")
+	}
+}
+
+func TestLinks(t *testing.T) {
+	root := t.TempDir()
+	original := filepath.Join(root, "sub/original_long_name.jpg")
+	os.MkdirAll(filepath.Dir(original), 0750)
+	if err := os.WriteFile(original, []byte("only copy"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(original, filepath.Join(root, "a.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(original, filepath.Join(root, "b.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := Find(context.Background(), []string{root}, 2)
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Files) != 2 {
+		t.Fatalf("Find() got groups %+v, want the hard links only", groups)
+	}
+	rules, _ := ParseRules("shortest")
+	Sort(groups, rules)
+	if groups = DropSameFiles(groups); len(groups) != 0 {
+		t.Fatalf("DropSameFiles() got groups %+v", groups)
+	}
+	if err := (&Applier{Action: ActionDelete}).Apply(groups); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := os.Stat(original); err != nil {
+		t.Errorf("Apply() removed the only copy")
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	root := t.TempDir()
+	// a burst: every frame is 3 bits from the previous one
+	var files []File
+	for i, hash := range []phash.Hash{0, 0x7, 0x3f} {
+		filePath := filepath.Join(root, fmt.Sprintf("frame%d.jpg", i))
+		if err := os.WriteFile(filePath, []byte(filePath), 0640); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, File{Path: filePath, hash: hash})
+	}
+	groups := []*Group{{Similar: true, Files: files, threshold: 4}}
+	rules, _ := ParseRules("shortest")
+	Sort(groups, rules)
+	if len(groups[0].Files) != 2 {
+		t.Fatalf("Sort() kept %+v, want the frames within 4 bits of frame0", groups[0].Files)
+	}
+
+	err := (&Applier{Action: ActionDelete}).Apply(groups)
+	if _, ok := err.(*utils.PartialError); !ok {
+		t.Errorf("Apply() error = %v, want a refusal", err)
+	}
+	if _, err := os.Stat(files[1].Path); err != nil {
+		t.Errorf("Apply() deleted a similar copy without ForceSimilar")
+	}
+	if err := (&Applier{Action: ActionDelete, ForceSimilar: true}).Apply(groups); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := os.Stat(files[1].Path); !os.IsNotExist(err) {
+		t.Errorf("Apply() left %v with ForceSimilar", files[1].Path)
+	}
+	if _, err := os.Stat(files[2].Path); err != nil {
+		t.Errorf("Apply() deleted %v, too far from the kept copy", files[2].Path)
+	}
+}
+
+func TestSimilarIdentical(t *testing.T) {
+	root := t.TempDir()
+	write := func(name string, size int) {
+		img := image.NewGray(image.Rect(0, 0, size, size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				img.SetGray(x, y, color.Gray{Y: uint8((x*4 + y*2) * 64 / size)})
+			}
+		}
+		f, err := os.Create(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 95}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.jpg", 128)
+	write("b.jpg", 128)
+	write("resized.jpg", 64)
+
+	groups, err := FindSimilar(context.Background(), []string{root}, 2, phash.PHash, 10)
+	if err != nil {
+		t.Fatalf("FindSimilar() error = %v", err)
+	}
+	if len(groups) != 1 || !groups[0].Similar || len(groups[0].Files) != 3 {
+		t.Fatalf("FindSimilar() got groups %+v", groups)
+	}
+	rules, _ := ParseRules("largest,shortest")
+	Sort(groups, rules)
+	if keep := groups[0].Keep().Path; keep != filepath.Join(root, "a.jpg") {
+		t.Fatalf("Sort() kept %v", keep)
+	}
+
+	err = (&Applier{Action: ActionDelete}).Apply(groups)
+	if partial, ok := err.(*utils.PartialError); !ok || len(partial.Failed) != 1 {
+		t.Errorf("Apply() error = %v, want the resized copy refused", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "b.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Apply() left the identical copy")
+	}
+	if _, err := os.Stat(filepath.Join(root, "resized.jpg")); err != nil {
+		t.Errorf("Apply() deleted the resized copy without ForceSimilar")
+	}
+}
@@ -0,0 +1,116 @@
+package dupes
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/phash"
+)
+
+// FindSimilar is Find for perceptually similar photos: it also groups the
+// photos whose perceptual hashes are at most threshold bits apart, such as
+// resized or re-encoded copies. Groups holding different contents are
+// marked Similar. Photos that cannot be decoded are only matched by content.
+// Matches are chained, Sort keeps the copies within threshold of the kept
+// one only.
+func FindSimilar(ctx context.Context, roots []string, workers int, algorithm string, threshold int) ([]*Group, error) {
+	if err := phash.Validate(algorithm); err != nil {
+		return nil, err
+	}
+	all, err := scan(ctx, roots, workers)
+	if all == nil {
+		return nil, err
+	}
+
+	hashes := make([]phash.Hash, len(all))
+	decoded := make([]bool, len(all))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				hash, err := hashFile(all[i].Files[0].Path, algorithm)
+				if err != nil {
+					log.Warnf("unable to compute the perceptual hash of %v. err=%v", all[i].Files[0].Path, err.Error())
+					continue
+				}
+				hashes[i], decoded[i] = hash, true
+			}
+		}()
+	}
+	for i := range all {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// union-find of the groups within threshold of each other
+	parent := make([]int, len(all))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	tree := &phash.BKTree{}
+	for i := range all {
+		if !decoded[i] {
+			continue
+		}
+		for _, m := range tree.Search(hashes[i], threshold) {
+			parent[find(m.Value.(int))] = find(i)
+		}
+		tree.Add(hashes[i], i)
+	}
+
+	clusters := make(map[int][]int)
+	for i := range all {
+		root := find(i)
+		clusters[root] = append(clusters[root], i)
+	}
+	var groups []*Group
+	for _, members := range clusters {
+		if len(members) == 1 {
+			if g := all[members[0]]; len(g.Files) > 1 {
+				groups = append(groups, g)
+			}
+			continue
+		}
+		similar := &Group{Similar: true, threshold: threshold}
+		for _, i := range members {
+			for _, f := range all[i].Files {
+				f.hash = hashes[i]
+				similar.Files = append(similar.Files, f)
+			}
+		}
+		groups = append(groups, similar)
+	}
+	sortGroups(groups)
+	return groups, err
+}
+
+func hashFile(filePath, algorithm string) (phash.Hash, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return phash.Read(f, algorithm)
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// BytesPerPixel estimates the memory used per source pixel while a photo is
+// processed: the decoded image, its upright copy and the resampled outputs
+const BytesPerPixel = 12
+
+// Options of Run
+type Options struct {
+	// Workers is the number of tasks processed at once, one per CPU when 0
+	Workers int
+	// MaxMemory bounds the estimated memory of the photos processed at once,
+	// 0 is unlimited. A photo larger than MaxMemory is processed alone.
+	MaxMemory int64
+}
+
+// Process handles one task, it should return early once ctx is done
+type Process func(ctx context.Context, task *utils.Task) error
+
+// Result is the outcome of one task
+type Result struct {
+	Task *utils.Task
+	Err  error
+}
+
+// Collect walks root and returns the task made by newTask for every image.
+// Entries that cannot be read are added to errs. The walk stops when ctx is
+// done.
+func Collect(ctx context.Context, root string, errs *utils.ErrorCollector, newTask func(aPath string, f os.FileInfo) *utils.Task) ([]*utils.Task, error) {
+	var tasks []*utils.Task
+	err := filepath.Walk(root, func(aPath string, f os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if aPath == root {
+				return err
+			}
+			log.Errorf("unable to read %v. err=%v", aPath, err.Error())
+			errs.Add(aPath, err)
+			return nil
+		}
+		if utils.IsImage(f) {
+			tasks = append(tasks, newTask(aPath, f))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read source directory %v. err=%w", root, err)
+	}
+	return tasks, nil
+}
+
+// Run processes the tasks with opts.Workers workers and returns their
+// results, in order. A panic fails its task only. Tasks not started when ctx
+// is done fail with the context error.
+func Run(ctx context.Context, tasks []*utils.Task, opts Options, process Process) []Result {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	memory := newBudget(opts.MaxMemory)
+	results := make([]Result, len(tasks))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				task := tasks[i]
+				cost := Cost(task)
+				if err := memory.acquire(ctx, cost); err != nil {
+					results[i] = Result{Task: task, Err: err}
+					continue
+				}
+				results[i] = Result{Task: task, Err: run(ctx, process, task)}
+				memory.release(cost)
+			}
+		}()
+	}
+feed:
+	for i := range tasks {
+		select {
+		case queue <- i:
+		case <-ctx.Done():
+			for j := i; j < len(tasks); j++ {
+				results[j] = Result{Task: tasks[j], Err: ctx.Err()}
+			}
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+func run(ctx context.Context, process Process, task *utils.Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to process %v. panic=%v", path.Join(task.Path, task.Name), r)
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return process(ctx, task)
+}
+
+// Cost estimates the memory needed to process the task from the dimensions
+// of its source, 0 when they cannot be read
+func Cost(task *utils.Task) int64 {
+	f, err := os.Open(path.Join(task.Path, task.Name))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0
+	}
+	return int64(config.Width) * int64(config.Height) * BytesPerPixel
+}
+
+// Failed logs and collects the errors of results, the context errors of
+// an interrupted run are left out
+func Failed(results []Result, errs *utils.ErrorCollector) {
+	for _, r := range results {
+		if r.Err == nil || errors.Is(r.Err, context.Canceled) {
+			continue
+		}
+		log.Errorf(r.Err.Error())
+		errs.Add(path.Join(r.Task.Path, r.Task.Name), r.Err)
+	}
+}
+
+// budget is a weighted semaphore of bytes
+type budget struct {
+	max     int64
+	mutex   sync.Mutex
+	used    int64
+	changed chan struct{}
+}
+
+func newBudget(max int64) *budget {
+	return &budget{max: max, changed: make(chan struct{})}
+}
+
+// acquire waits until n bytes fit in the budget, or nothing else is running
+func (b *budget) acquire(ctx context.Context, n int64) error {
+	if b.max <= 0 {
+		return nil
+	}
+	for {
+		b.mutex.Lock()
+		if b.used == 0 || b.used+n <= b.max {
+			b.used += n
+			b.mutex.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *budget) release(n int64) {
+	if b.max <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used -= n
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+func newTasks(n int) []*utils.Task {
+	tasks := make([]*utils.Task, n)
+	for i := range tasks {
+		tasks[i] = &utils.Task{Path: "/nonexistent", Name: fmt.Sprintf("%d.jpg", i)}
+	}
+	return tasks
+}
+
+func TestRun(t *testing.T) {
+	tasks := newTasks(20)
+	var running, most int32
+	results := Run(context.Background(), tasks, Options{Workers: 3}, func(ctx context.Context, task *utils.Task) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		switch task.Name {
+		case "3.jpg":
+			return errors.New("failed")
+		case "5.jpg":
+			var m map[string]int
+			m["panic"]++
+		}
+		return nil
+	})
+	if most > 3 {
+		t.Errorf("%d tasks ran at once, want at most 3", most)
+	}
+	for i, r := range results {
+		if r.Task != tasks[i] {
+			t.Fatalf("result %d is for %v", i, r.Task.Name)
+		}
+		if failed := i == 3 || i == 5; (r.Err != nil) != failed {
+			t.Errorf("task %d: err=%v", i, r.Err)
+		}
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	tasks := newTasks(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	var done int32
+	results := Run(ctx, tasks, Options{Workers: 1}, func(ctx context.Context, task *utils.Task) error {
+		if atomic.AddInt32(&done, 1) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if done > 3 {
+		t.Errorf("%d tasks ran after cancel", done)
+	}
+	for i, r := range results[3:] {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("task %d: err=%v, want canceled", i+3, r.Err)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := newBudget(100)
+	ctx := context.Background()
+	if err := b.acquire(ctx, 60); err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		b.acquire(ctx, 60)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired over budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.release(60)
+	<-acquired
+	b.release(60)
+	// larger than the budget, alone
+	if err := b.acquire(ctx, 500); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := b.acquire(canceled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("err=%v, want canceled", err)
+	}
+}
@@ -0,0 +1,163 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/photo"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+const dcimDirectory = "DCIM"
+
+// Daemon polls the mount table and runs the copier against every newly
+// mounted volume holding a DCIM directory
+type Daemon struct {
+	// Config holds the default copy settings, overridden by a matching profile
+	Config        *config.Config
+	Profiles      []config.CardProfile
+	State         *State
+	PollInterval  time.Duration
+	MountInfoPath string
+	mounted       map[string]bool
+}
+
+func NewDaemon(cfg *config.Config, profiles []config.CardProfile, state *State, pollInterval time.Duration) *Daemon {
+	return &Daemon{
+		Config:        cfg,
+		Profiles:      profiles,
+		State:         state,
+		PollInterval:  pollInterval,
+		MountInfoPath: MountInfoPath,
+		mounted:       make(map[string]bool),
+	}
+}
+
+// Run polls until ctx is cancelled. Cards already mounted when the daemon
+// starts are imported as well.
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.PollInterval <= 0 {
+		d.PollInterval = 2 * time.Second
+	}
+	log.Infof("Watching %v for memory cards every %v", d.MountInfoPath, d.PollInterval)
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.poll(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Daemon) poll(ctx context.Context) error {
+	mounts, err := ReadMountInfo(d.MountInfoPath)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool, len(mounts))
+	for _, m := range mounts {
+		current[m.MountPoint] = true
+		if d.mounted[m.MountPoint] {
+			continue
+		}
+		d.mounted[m.MountPoint] = true
+		if ctx.Err() != nil {
+			return nil
+		}
+		d.handleMount(ctx, m)
+	}
+	// forget unmounted volumes so they are looked at again when re-inserted
+	for mountPoint := range d.mounted {
+		if !current[mountPoint] {
+			log.Debugf("volume %v unmounted", mountPoint)
+			delete(d.mounted, mountPoint)
+		}
+	}
+	return nil
+}
+
+func (d *Daemon) handleMount(ctx context.Context, m Mount) {
+	dcim := filepath.Join(m.MountPoint, dcimDirectory)
+	if f, err := os.Stat(dcim); err != nil || !f.IsDir() {
+		return
+	}
+	volume := ResolveVolume(m)
+	if volume.UUID == "" {
+		content, err := ListingHash(dcim)
+		if err != nil {
+			log.Warnf("skipping card mounted on %v, it has no UUID and its content cannot be listed. err=%v", m.MountPoint, err.Error())
+			return
+		}
+		volume.Content = content
+	}
+	if d.State.IsImported(volume.ID()) {
+		log.Infof("card %v mounted on %v was already imported, skipping", volume.ID(), m.MountPoint)
+		return
+	}
+
+	cfg := *d.Config
+	cfg.SourceDirectory = dcim
+	var profileName string
+	if profile := d.profileFor(volume); profile != nil {
+		profileName = profile.Name
+		if profile.DestDirectory != "" {
+			cfg.DestDirectory = profile.DestDirectory
+		}
+		if profile.DestFileFormat != "" {
+			cfg.DestFileFormat = profile.DestFileFormat
+		}
+	}
+	log.Infof("new card %v mounted on %v, importing with profile %q", volume.ID(), m.MountPoint, profileName)
+
+	result, err := photo.Copy(ctx, photo.Options{Config: &cfg})
+	if result != nil {
+		log.Infof("card %v: copied %d, skipped %d, failed %d photos in %v", volume.ID(), result.Copied, result.Skipped, result.Failed, result.Duration)
+	}
+	var partial *utils.PartialError
+	var failed int
+	switch {
+	case errors.As(err, &partial):
+		// recorded, the card is imported again on next mount and the photos
+		// already copied are skipped
+		failed = len(partial.Failed)
+		for _, f := range partial.Failed {
+			log.Errorf("card %v: unable to import %v. err=%v", volume.ID(), f.Path, f.Err)
+		}
+	case err != nil:
+		log.Errorf("unable to import card %v. err=%v", volume.ID(), err.Error())
+		return
+	}
+	if ctx.Err() != nil {
+		// interrupted, the card will be imported again on next run
+		return
+	}
+	err = d.State.MarkImported(volume.ID(), ImportRecord{
+		Label:         volume.Label,
+		Profile:       profileName,
+		DestDirectory: cfg.DestDirectory,
+		ImportedAt:    time.Now(),
+		Failed:        failed,
+	})
+	if err != nil {
+		log.Errorf("unable to record import of card %v. err=%v", volume.ID(), err.Error())
+	}
+}
+
+func (d *Daemon) profileFor(v Volume) *config.CardProfile {
+	for i := range d.Profiles {
+		if d.Profiles[i].Matches(v.UUID, v.Label) {
+			return &d.Profiles[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package ingest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	MountInfoPath = "/proc/self/mountinfo"
+	diskByUUID    = "/dev/disk/by-uuid"
+	diskByLabel   = "/dev/disk/by-label"
+)
+
+// Mount is a single entry of /proc/self/mountinfo
+type Mount struct {
+	ID         int
+	MountPoint string
+	FSType     string
+	Source     string
+}
+
+// Volume identifies the filesystem behind a mount
+type Volume struct {
+	UUID       string
+	Label      string
+	Device     string
+	MountPoint string
+	// Content is a hash of the DCIM listing, for volumes without UUID
+	Content string
+}
+
+// ID returns the most stable identifier known for the volume, empty when
+// there is none. Labels and mount points are shared by many cards and are
+// not used.
+func (v Volume) ID() string {
+	if v.UUID != "" {
+		return v.UUID
+	}
+	if v.Content != "" {
+		return "dcim:" + v.Content
+	}
+	return ""
+}
+
+// ListingHash returns the sha256 of the paths, sizes and modification times
+// of the files below dir. It changes when photos are added to the card.
+func ListingHash(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(aPath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, aPath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\t%d\t%d\n", filepath.ToSlash(rel), f.Size(), f.ModTime().Unix())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to list %v. err=%w", dir, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func ReadMountInfo(mountInfoPath string) ([]Mount, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mount table %v. err=%v", mountInfoPath, err.Error())
+	}
+	defer f.Close()
+	return ParseMountInfo(f)
+}
+
+// ParseMountInfo parses the mountinfo format described in proc(5):
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func ParseMountInfo(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep == -1 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("unable to parse mountinfo line %q", scanner.Text())
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse mount id %q. err=%v", fields[0], err.Error())
+		}
+		mounts = append(mounts, Mount{
+			ID:         id,
+			MountPoint: unescapeMountField(fields[4]),
+			FSType:     fields[sep+1],
+			Source:     unescapeMountField(fields[sep+2]),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes (\040 for space...) used by the kernel
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ResolveVolume looks up the UUID and label of the mount source in /dev/disk
+func ResolveVolume(m Mount) Volume {
+	v := Volume{Device: m.Source, MountPoint: m.MountPoint}
+	device, err := filepath.EvalSymlinks(m.Source)
+	if err != nil {
+		return v
+	}
+	v.UUID = findDiskLink(diskByUUID, device)
+	v.Label = findDiskLink(diskByLabel, device)
+	return v
+}
+
+func findDiskLink(dir, device string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, e.Name()))
+		if err == nil && target == device {
+			// udev escapes spaces in labels as \x20
+			return strings.ReplaceAll(e.Name(), `\x20`, " ")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Mount
+		wantErr bool
+	}{
+		{
+			name: "Should parse mounts with and without optional fields",
+			input: `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+98 22 8:17 / /media/user/EOS_DIGITAL rw,nosuid,nodev shared:54 master:2 - vfat /dev/sdb1 rw,uid=1000
+99 22 8:33 / /media/user/NO\040NAME rw - exfat /dev/sdc1 rw
+`,
+			want: []Mount{
+				{ID: 22, MountPoint: "/", FSType: "ext4", Source: "/dev/sda1"},
+				{ID: 98, MountPoint: "/media/user/EOS_DIGITAL", FSType: "vfat", Source: "/dev/sdb1"},
+				{ID: 99, MountPoint: "/media/user/NO NAME", FSType: "exfat", Source: "/dev/sdc1"},
+			},
+		},
+		{
+			name:    "Should fail on a line without separator",
+			input:   "22 1 8:1 / / rw,relatime shared:1 ext4 /dev/sda1 rw\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMountInfo(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMountInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMountInfo() got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeID(t *testing.T) {
+	dcim := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dcim, "IMG_0001.JPG"), []byte("photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ListingHash(dcim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		volume Volume
+		want   string
+	}{
+		{Volume{UUID: "1234-ABCD", Label: "EOS_DIGITAL", Content: content}, "1234-ABCD"},
+		{Volume{Label: "EOS_DIGITAL", MountPoint: "/media/card", Content: content}, "dcim:" + content},
+		{Volume{Label: "EOS_DIGITAL", MountPoint: "/media/card"}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.volume.ID(); got != tt.want {
+			t.Errorf("ID() of %+v = %v, want %v", tt.volume, got, tt.want)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dcim, "IMG_0002.JPG"), []byte("new photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := ListingHash(dcim); other == content {
+		t.Errorf("ListingHash() unchanged after a new photo")
+	}
+}
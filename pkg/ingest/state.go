@@ -0,0 +1,78 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ImportRecord is what is remembered about an imported card
+type ImportRecord struct {
+	Label         string    `json:"label,omitempty"`
+	Profile       string    `json:"profile,omitempty"`
+	DestDirectory string    `json:"dest_directory"`
+	ImportedAt    time.Time `json:"imported_at"`
+	// Failed is the number of photos that could not be copied, the card is
+	// imported again until it is 0
+	Failed int `json:"failed,omitempty"`
+}
+
+// State keeps track of the volumes already imported, persisted as JSON
+type State struct {
+	Path    string
+	Volumes map[string]ImportRecord
+	mutex   sync.Mutex
+}
+
+func DefaultStatePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to get the config dir. err=%v", err.Error())
+	}
+	return filepath.Join(dir, "photo-copier", "ingested.json"), nil
+}
+
+func LoadState(statePath string) (*State, error) {
+	s := &State{Path: statePath, Volumes: make(map[string]ImportRecord)}
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unable to read state file %v. err=%v", statePath, err.Error())
+	}
+	if err := json.Unmarshal(data, &s.Volumes); err != nil {
+		return nil, fmt.Errorf("unable to decode state file %v. err=%v", statePath, err.Error())
+	}
+	return s, nil
+}
+
+// IsImported tells whether id was imported without failures
+func (s *State) IsImported(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	record, ok := s.Volumes[id]
+	return ok && record.Failed == 0
+}
+
+// MarkImported records id as imported and saves the state file
+func (s *State) MarkImported(id string, record ImportRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Volumes[id] = record
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0750); err != nil {
+		return fmt.Errorf("unable to create directory for state file %v. err=%v", s.Path, err.Error())
+	}
+	data, err := json.MarshalIndent(s.Volumes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("unable to write state file %v. err=%v", tmp, err.Error())
+	}
+	return os.Rename(tmp, s.Path)
+}
@@ -0,0 +1,30 @@
+package ingest
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "ingested.json")
+	s, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkImported("1234-ABCD", ImportRecord{Failed: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsImported("1234-ABCD") {
+		t.Errorf("IsImported() = true for a card with failures")
+	}
+	if err := s.MarkImported("1234-ABCD", ImportRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.IsImported("1234-ABCD") || loaded.IsImported("other") {
+		t.Errorf("LoadState() got volumes %+v", loaded.Volumes)
+	}
+}
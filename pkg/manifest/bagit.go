@@ -0,0 +1,193 @@
+package manifest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BagIt 1.0 (RFC 8493) layout
+const (
+	BagPayloadDirectory = "data"
+	bagDeclaration      = "bagit.txt"
+	bagInfo             = "bag-info.txt"
+	bagSoftwareAgent    = "goPhoto photo-copier"
+)
+
+// IsBag tells whether root is a BagIt bag
+func IsBag(root string) bool {
+	_, err := os.Stat(filepath.Join(root, bagDeclaration))
+	return err == nil
+}
+
+// IsBagTagFile tells whether the slash separated path rel, relative to the
+// root of a bag, is a tag file: any file outside the payload directory, such
+// as bagit.txt, bag-info.txt or the manifests
+func IsBagTagFile(rel string) bool {
+	return !strings.HasPrefix(rel, BagPayloadDirectory+"/")
+}
+
+// Paths of bag manifests percent encode CR, LF and %, RFC 8493 section 2.1.3
+var (
+	bagPathEncoder = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	bagPathDecoder = strings.NewReplacer("%25", "%", "%0D", "\r", "%0d", "\r", "%0A", "\n", "%0a", "\n")
+)
+
+// IsBagManifest tells whether manifestPath is a payload manifest of the bag
+// root, whose paths are percent encoded
+func IsBagManifest(root, manifestPath string) bool {
+	rel, err := filepath.Rel(root, manifestPath)
+	if err != nil || !IsBag(root) {
+		return false
+	}
+	matched, _ := path.Match("manifest-*.txt", filepath.ToSlash(rel))
+	return matched
+}
+
+// ReadBagManifest loads a payload or tag manifest of a bag
+func ReadBagManifest(manifestPath string) (*Manifest, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open manifest %v. err=%w", manifestPath, err)
+	}
+	defer f.Close()
+	return ParseBagManifest(f)
+}
+
+// ParseBagManifest parses "<hash> <path>" lines, the hash and the percent
+// encoded path being separated by spaces or tabs
+func ParseBagManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{Entries: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		idx := strings.IndexAny(line, " \t")
+		name := strings.TrimLeft(line[idx+1:], " \t")
+		if idx <= 0 || name == "" {
+			return nil, fmt.Errorf("unable to parse bag manifest line %q", line)
+		}
+		algorithm, err := algorithmForLength(idx)
+		if err != nil {
+			return nil, err
+		}
+		if m.Algorithm == "" {
+			m.Algorithm = algorithm
+		} else if m.Algorithm != algorithm {
+			return nil, fmt.Errorf("manifest mixes %s and %s hashes", m.Algorithm, algorithm)
+		}
+		m.Entries[bagPathDecoder.Replace(name)] = strings.ToLower(line[:idx])
+	}
+	return m, scanner.Err()
+}
+
+// writeBagManifest writes m with percent encoded paths
+func (m *Manifest) writeBagManifest(manifestPath string) error {
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		return fmt.Errorf("unable to create manifest %v. err=%v", manifestPath, err.Error())
+	}
+	bw := bufio.NewWriter(f)
+	for _, name := range m.names() {
+		fmt.Fprintf(bw, "%s  %s\n", m.Entries[name], bagPathEncoder.Replace(name))
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write manifest %v. err=%v", manifestPath, err.Error())
+	}
+	return f.Close()
+}
+
+// WriteBag turns root into a BagIt bag. payload holds paths relative to root,
+// all below BagPayloadDirectory, and is merged with the payload manifest
+// already present in root. info adds user fields to bag-info.txt.
+func WriteBag(root string, payload *Manifest, info map[string]string) error {
+	for name := range payload.Entries {
+		if !strings.HasPrefix(name, BagPayloadDirectory+"/") {
+			return fmt.Errorf("bag payload file %s is not in %s/", name, BagPayloadDirectory)
+		}
+	}
+	manifestName := fmt.Sprintf("manifest-%s.txt", payload.Algorithm)
+	full, err := ReadBagManifest(filepath.Join(root, manifestName))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		full = New(payload.Algorithm)
+	}
+	if full.Algorithm == "" {
+		full.Algorithm = payload.Algorithm
+	}
+	if err := full.Merge(payload); err != nil {
+		return err
+	}
+	if err := full.writeBagManifest(filepath.Join(root, manifestName)); err != nil {
+		return err
+	}
+
+	var octets int64
+	for name := range full.Entries {
+		f, err := os.Stat(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("unable to stat bag payload file %s. err=%v", name, err.Error())
+		}
+		octets += f.Size()
+	}
+
+	declaration := "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n"
+	if err := os.WriteFile(filepath.Join(root, bagDeclaration), []byte(declaration), 0640); err != nil {
+		return fmt.Errorf("unable to write %s. err=%v", bagDeclaration, err.Error())
+	}
+
+	fields := map[string]string{
+		"Bagging-Date":       time.Now().Format("2006-01-02"),
+		"Bag-Software-Agent": bagSoftwareAgent,
+		"Payload-Oxum":       fmt.Sprintf("%d.%d", octets, len(full.Entries)),
+	}
+	for k, v := range info {
+		fields[k] = v
+	}
+	if err := writeBagInfo(filepath.Join(root, bagInfo), fields); err != nil {
+		return err
+	}
+
+	tags := New(payload.Algorithm)
+	for _, name := range []string{bagDeclaration, bagInfo, manifestName} {
+		sum, err := HashFile(filepath.Join(root, name), payload.Algorithm)
+		if err != nil {
+			return err
+		}
+		tags.Entries[name] = sum
+	}
+	return tags.writeBagManifest(filepath.Join(root, fmt.Sprintf("tagmanifest-%s.txt", payload.Algorithm)))
+}
+
+func writeBagInfo(infoPath string, fields map[string]string) error {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	f, err := os.Create(infoPath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s. err=%v", infoPath, err.Error())
+	}
+	bw := bufio.NewWriter(f)
+	for _, k := range keys {
+		fmt.Fprintf(bw, "%s: %s\n", k, fields[k])
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write %s. err=%v", infoPath, err.Error())
+	}
+	return f.Close()
+}
@@ -0,0 +1,100 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteBag(t *testing.T) {
+	root := t.TempDir()
+	payload := map[string]string{
+		"data/2022/2022-04-30/img001.jpg": "a",
+		"data/2022/2022-04-30/100%.jpg":   "bb",
+		"data/2022/2022-04-30/a\nb\r.jpg": "ccc",
+	}
+	for name, content := range payload {
+		filePath := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(filePath), 0750)
+		if err := os.WriteFile(filePath, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(names ...string) {
+		t.Helper()
+		m := New(SHA256)
+		for _, name := range names {
+			sum, err := HashFile(filepath.Join(root, filepath.FromSlash(name)), SHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Entries[name] = sum
+		}
+		if err := WriteBag(root, m, map[string]string{"Source-Organization": "Studio"}); err != nil {
+			t.Fatalf("WriteBag() error = %v", err)
+		}
+	}
+	// a second import adds to the payload manifest
+	write("data/2022/2022-04-30/img001.jpg")
+	write("data/2022/2022-04-30/100%.jpg", "data/2022/2022-04-30/a\nb\r.jpg")
+
+	read := func(name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+	tests := []struct {
+		file      string
+		lines     int
+		wantLines []string
+	}{
+		{bagDeclaration, 2, []string{"BagIt-Version: 1.0", "Tag-File-Character-Encoding: UTF-8"}},
+		{bagInfo, 4, []string{"Bag-Software-Agent: " + bagSoftwareAgent, "Payload-Oxum: 6.3", "Source-Organization: Studio"}},
+		{"manifest-sha256.txt", 3, []string{"  data/2022/2022-04-30/100%25.jpg", "  data/2022/2022-04-30/a%0Ab%0D.jpg", "  data/2022/2022-04-30/img001.jpg"}},
+		{"tagmanifest-sha256.txt", 3, []string{"  bag-info.txt", "  bagit.txt", "  manifest-sha256.txt"}},
+	}
+	for _, tt := range tests {
+		content := read(tt.file)
+		if strings.Count(content, "\n") != tt.lines {
+			t.Errorf("%v has %d lines, want %d:\n%v", tt.file, strings.Count(content, "\n"), tt.lines, content)
+		}
+		for _, line := range tt.wantLines {
+			if !strings.Contains(content, line+"\n") {
+				t.Errorf("%v does not hold %q:\n%v", tt.file, line, content)
+			}
+		}
+	}
+
+	got, err := ReadBagManifest(filepath.Join(root, "manifest-sha256.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string)
+	for name := range got.Entries {
+		names[name] = payload[name]
+	}
+	if !reflect.DeepEqual(names, payload) {
+		t.Errorf("ReadBagManifest() got %v, want the payload %v", got.Entries, payload)
+	}
+	tags, err := ReadBagManifest(filepath.Join(root, "tagmanifest-sha256.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, sum := range tags.Entries {
+		if want, _ := HashFile(filepath.Join(root, name), SHA256); sum != want {
+			t.Errorf("tag manifest has %v for %v, want %v", sum, name, want)
+		}
+	}
+	if !IsBag(root) || !IsBagManifest(root, filepath.Join(root, "manifest-sha256.txt")) || IsBagManifest(root, filepath.Join(root, "tagmanifest-sha256.txt")) {
+		t.Errorf("IsBag() or IsBagManifest() do not recognize the bag")
+	}
+
+	if err := WriteBag(root, &Manifest{Algorithm: SHA256, Entries: map[string]string{"img.jpg": ""}}, nil); err == nil {
+		t.Errorf("WriteBag() accepted a payload file outside %v/", BagPayloadDirectory)
+	}
+}
@@ -0,0 +1,169 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DerivedName is the file name of the derived manifest, at the root of the
+// destination
+const DerivedName = ".gophoto-derived.json"
+
+// DerivedEntry records how an output was made
+type DerivedEntry struct {
+	// Source is the absolute path of the source photo
+	Source  string    `json:"source"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Sha256  string    `json:"sha256"`
+	// Params identifies the processing parameters
+	Params string `json:"params"`
+}
+
+// Derived tracks the outputs generated from source photos, so that outputs
+// whose source and parameters did not change are not generated again.
+// Entries are keyed by slash separated paths relative to Root.
+type Derived struct {
+	Root    string                  `json:"-"`
+	Entries map[string]DerivedEntry `json:"outputs"`
+	mutex   sync.Mutex
+}
+
+// ReadDerived loads the derived manifest of the root directory, empty when
+// there is none yet
+func ReadDerived(root string) (*Derived, error) {
+	d := &Derived{Root: root, Entries: make(map[string]DerivedEntry)}
+	data, err := os.ReadFile(filepath.Join(root, DerivedName))
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read derived manifest in %v. err=%w", root, err)
+	}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("unable to parse derived manifest in %v. err=%w", root, err)
+	}
+	if d.Entries == nil {
+		d.Entries = make(map[string]DerivedEntry)
+	}
+	return d, nil
+}
+
+func (d *Derived) key(output string) (string, error) {
+	rel, err := filepath.Rel(d.Root, output)
+	if err != nil {
+		return "", fmt.Errorf("unable to find %v in %v. err=%w", output, d.Root, err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// SourceEntry describes the current state of a source, without hashing it
+func SourceEntry(source, params string) (DerivedEntry, error) {
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return DerivedEntry{}, fmt.Errorf("unable to resolve %v. err=%w", source, err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return DerivedEntry{}, fmt.Errorf("unable to stat %v. err=%w", source, err)
+	}
+	return DerivedEntry{Source: abs, Size: info.Size(), ModTime: info.ModTime().UTC(), Params: params}, nil
+}
+
+// UpToDate tells whether output exists and was made from the source of e with
+// the same parameters. A source with a new modification time is hashed and
+// still up to date when its content did not change.
+func (d *Derived) UpToDate(output string, e DerivedEntry) bool {
+	key, err := d.key(output)
+	if err != nil {
+		return false
+	}
+	d.mutex.Lock()
+	recorded, ok := d.Entries[key]
+	d.mutex.Unlock()
+	if !ok || recorded.Source != e.Source || recorded.Params != e.Params || recorded.Size != e.Size {
+		return false
+	}
+	if _, err := os.Stat(output); err != nil {
+		return false
+	}
+	if recorded.ModTime.Equal(e.ModTime) {
+		return true
+	}
+	sum, err := HashFile(e.Source, SHA256)
+	if err != nil || sum != recorded.Sha256 {
+		return false
+	}
+	// touched, not modified
+	recorded.ModTime = e.ModTime
+	d.mutex.Lock()
+	d.Entries[key] = recorded
+	d.mutex.Unlock()
+	return true
+}
+
+// Record stores the entry of a generated output, hashing its source when
+// e has no hash yet
+func (d *Derived) Record(output string, e DerivedEntry) error {
+	key, err := d.key(output)
+	if err != nil {
+		return err
+	}
+	if e.Sha256 == "" {
+		if e.Sha256, err = HashFile(e.Source, SHA256); err != nil {
+			return err
+		}
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.Entries[key] = e
+	return nil
+}
+
+// Prune removes the outputs whose source no longer exists, and returns them
+func (d *Derived) Prune() ([]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var pruned []string
+	for key, e := range d.Entries {
+		if _, err := os.Stat(e.Source); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		output := filepath.Join(d.Root, filepath.FromSlash(key))
+		if err := os.Remove(output); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return pruned, fmt.Errorf("unable to prune %v. err=%w", output, err)
+		}
+		delete(d.Entries, key)
+		pruned = append(pruned, output)
+	}
+	sort.Strings(pruned)
+	return pruned, nil
+}
+
+// Write saves the derived manifest in its root directory
+func (d *Derived) Write() error {
+	d.mutex.Lock()
+	data, err := json.MarshalIndent(d, "", "  ")
+	d.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(d.Root, DerivedName)
+	if err := os.MkdirAll(d.Root, 0750); err != nil {
+		return fmt.Errorf("unable to create directory %v. err=%w", d.Root, err)
+	}
+	tmp := manifestPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("unable to write derived manifest %v. err=%w", manifestPath, err)
+	}
+	if err := os.Rename(tmp, manifestPath); err != nil {
+		return fmt.Errorf("unable to write derived manifest %v. err=%w", manifestPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDerived(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "src.jpg")
+	output := filepath.Join(dir, "out", "src.jpg")
+	if err := os.WriteFile(source, []byte("photo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(output), 0700)
+	if err := os.WriteFile(output, []byte("small photo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ReadDerived(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := SourceEntry(source, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.UpToDate(output, e) {
+		t.Errorf("unrecorded output up to date")
+	}
+	if err := d.Record(output, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = ReadDerived(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(name, params string, want bool) {
+		t.Helper()
+		e, err := SourceEntry(source, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.UpToDate(output, e); got != want {
+			t.Errorf("%v: up to date %v, want %v", name, got, want)
+		}
+	}
+	check("unchanged", "web", true)
+	check("other parameters", "thumb", false)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(source, later, later)
+	check("touched", "web", true)
+	os.WriteFile(source, []byte("PHOTO"), 0600)
+	os.Chtimes(source, later.Add(time.Hour), later.Add(time.Hour))
+	check("modified", "web", false)
+
+	os.Remove(source)
+	pruned, err := d.Prune()
+	if err != nil || len(pruned) != 1 || pruned[0] != output {
+		t.Errorf("Prune() = %v, %v", pruned, err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("pruned output still there")
+	}
+	if len(d.Entries) != 0 {
+		t.Errorf("pruned entry kept: %v", d.Entries)
+	}
+}
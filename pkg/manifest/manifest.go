@@ -0,0 +1,191 @@
+package manifest
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	MD5    = "md5"
+	SHA256 = "sha256"
+)
+
+// Manifest maps slash separated paths, relative to Root, to hex encoded hashes
+type Manifest struct {
+	Algorithm string
+	Entries   map[string]string
+}
+
+func New(algorithm string) *Manifest {
+	return &Manifest{Algorithm: algorithm, Entries: make(map[string]string)}
+}
+
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case MD5:
+		return md5.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %s", algorithm)
+	}
+}
+
+func HashFile(filePath, algorithm string) (string, error) {
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file %v. err=%v", filePath, err.Error())
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to compute %s for file %s. err=%v", algorithm, filePath, err.Error())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Read loads a md5sum or sha256sum compatible file. The algorithm is
+// deduced from the length of the hashes.
+func Read(manifestPath string) (*Manifest, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open manifest %v. err=%w", manifestPath, err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func Parse(r io.Reader) (*Manifest, error) {
+	m := &Manifest{Entries: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		sum, name, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		algorithm, err := algorithmForLength(len(sum))
+		if err != nil {
+			return nil, err
+		}
+		if m.Algorithm == "" {
+			m.Algorithm = algorithm
+		} else if m.Algorithm != algorithm {
+			return nil, fmt.Errorf("manifest mixes %s and %s hashes", m.Algorithm, algorithm)
+		}
+		m.Entries[name] = strings.ToLower(sum)
+	}
+	return m, scanner.Err()
+}
+
+// parseLine splits "<hash>  <name>" or "<hash> *<name>". Names holding a
+// newline or a backslash are escaped and the line is prefixed by a backslash.
+func parseLine(line string) (string, string, error) {
+	escaped := strings.HasPrefix(line, `\`)
+	if escaped {
+		line = line[1:]
+	}
+	idx := strings.Index(line, " ")
+	if idx <= 0 || len(line) < idx+2 || (line[idx+1] != ' ' && line[idx+1] != '*') {
+		return "", "", fmt.Errorf("unable to parse manifest line %q", line)
+	}
+	name := line[idx+2:]
+	if escaped {
+		name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+	}
+	return line[:idx], name, nil
+}
+
+func algorithmForLength(length int) (string, error) {
+	switch length {
+	case hex.EncodedLen(md5.Size):
+		return MD5, nil
+	case hex.EncodedLen(sha256.Size):
+		return SHA256, nil
+	default:
+		return "", fmt.Errorf("unknown hash length %d", length)
+	}
+}
+
+// Merge adds the entries of other, overriding existing paths
+func (m *Manifest) Merge(other *Manifest) error {
+	if other.Algorithm != "" && m.Algorithm != other.Algorithm {
+		return fmt.Errorf("unable to merge %s manifest into %s manifest", other.Algorithm, m.Algorithm)
+	}
+	for name, sum := range other.Entries {
+		m.Entries[name] = sum
+	}
+	return nil
+}
+
+// names returns the paths of the manifest, sorted
+func (m *Manifest) names() []string {
+	names := make([]string, 0, len(m.Entries))
+	for name := range m.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Write outputs the manifest sorted by path, in a format understood by
+// md5sum -c or sha256sum -c
+func (m *Manifest) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, name := range m.names() {
+		prefix := ""
+		if strings.ContainsAny(name, "\\\n") {
+			prefix = `\`
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(name)
+		}
+		if _, err := fmt.Fprintf(bw, "%s%s  %s\n", prefix, m.Entries[name], name); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (m *Manifest) WriteFile(manifestPath string) error {
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		return fmt.Errorf("unable to create manifest %v. err=%v", manifestPath, err.Error())
+	}
+	if err := m.Write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write manifest %v. err=%v", manifestPath, err.Error())
+	}
+	return f.Close()
+}
+
+// Update merges m into the manifest stored at manifestPath, creating it if needed
+func (m *Manifest) Update(manifestPath string) error {
+	existing, err := Read(manifestPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		existing = New(m.Algorithm)
+	}
+	if existing.Algorithm == "" {
+		existing.Algorithm = m.Algorithm
+	}
+	if err := existing.Merge(m); err != nil {
+		return err
+	}
+	return existing.WriteFile(manifestPath)
+}
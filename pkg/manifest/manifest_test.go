@@ -0,0 +1,55 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		wantAlgorithm string
+		wantEntries   map[string]string
+		wantErr       bool
+	}{
+		{
+			name:          "Should parse a sha256sum file in text and binary mode",
+			input:         "98ea6e4f216f2fb4b69fff9b3a44842c38686ca685f3f55dc48c5d3fb1107be4  2022/2022-04-30/img 001.jpg\n98EA6E4F216F2FB4B69FFF9B3A44842C38686CA685F3F55DC48C5D3FB1107BE4 *img002.jpg\n",
+			wantAlgorithm: SHA256,
+			wantEntries: map[string]string{
+				"2022/2022-04-30/img 001.jpg": "98ea6e4f216f2fb4b69fff9b3a44842c38686ca685f3f55dc48c5d3fb1107be4",
+				"img002.jpg":                  "98ea6e4f216f2fb4b69fff9b3a44842c38686ca685f3f55dc48c5d3fb1107be4",
+			},
+		},
+		{
+			name:          "Should unescape names",
+			input:         "\\764efa883dda1e11db47671c4a3bbd9e  a\\\\b.jpg\n",
+			wantAlgorithm: MD5,
+			wantEntries:   map[string]string{`a\b.jpg`: "764efa883dda1e11db47671c4a3bbd9e"},
+		},
+		{
+			name:    "Should refuse mixed algorithms",
+			input:   "764efa883dda1e11db47671c4a3bbd9e  a.jpg\n98ea6e4f216f2fb4b69fff9b3a44842c38686ca685f3f55dc48c5d3fb1107be4  b.jpg\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Algorithm != tt.wantAlgorithm {
+				t.Errorf("Parse() got algorithm=%s want %s", got.Algorithm, tt.wantAlgorithm)
+			}
+			if !reflect.DeepEqual(got.Entries, tt.wantEntries) {
+				t.Errorf("Parse() got %v want %v", got.Entries, tt.wantEntries)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EXIF tags rewritten or removed by fixEXIF
+const (
+	tagImageWidth      = 0x0100
+	tagImageLength     = 0x0101
+	tagStripOffsets    = 0x0111
+	tagOrientation     = 0x0112
+	tagStripByteCounts = 0x0117
+	tagThumbnail       = 0x0201
+	tagThumbnailLength = 0x0202
+	tagExifIFD         = 0x8769
+	tagGPSIFD          = 0x8825
+	tagMakerNote       = 0x927C
+	tagPixelXDimension = 0xA002
+	tagPixelYDimension = 0xA003
+	tagCameraOwnerName = 0xA430
+	tagBodySerial      = 0xA431
+	tagLensSerial      = 0xA435
+)
+
+// EXIF value types
+const (
+	typeShort = 3
+	typeLong  = 4
+)
+
+// typeSizes are the sizes in bytes of the values of each type
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiff edits the IFDs of an EXIF payload in place
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// fixEXIF returns a copy of the EXIF payload with the orientation reset, the
+// dimensions set and the stripped tags removed. The thumbnail IFD is dropped,
+// it shows the original image. The layout is kept, the bytes of what is
+// removed are zeroed so that no stripped data is left behind.
+func fixEXIF(payload []byte, width, height int, strip Strip) ([]byte, error) {
+	data := append([]byte(nil), payload[len(exifHeader):]...)
+	t := &tiff{data: data}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("truncated TIFF header")
+	}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order %q", data[:2])
+	}
+	ifd0 := int(t.order.Uint32(data[4:]))
+	if err := t.check(ifd0); err != nil {
+		return nil, err
+	}
+	if strip.GPS {
+		if gpsIFD, ok := t.get(ifd0, tagGPSIFD); ok {
+			t.wipeIFD(int(gpsIFD))
+		}
+		t.remove(ifd0, tagGPSIFD)
+	}
+	t.setInt(ifd0, tagOrientation, 1)
+	t.setInt(ifd0, tagImageWidth, uint32(width))
+	t.setInt(ifd0, tagImageLength, uint32(height))
+	if ifd1 := int(t.order.Uint32(data[t.next(ifd0):])); ifd1 != 0 && t.check(ifd1) == nil {
+		if offset, ok := t.get(ifd1, tagThumbnail); ok {
+			length, _ := t.get(ifd1, tagThumbnailLength)
+			t.wipe(int(offset), int(offset)+int(length))
+		}
+		offsets, counts := t.values(ifd1, tagStripOffsets), t.values(ifd1, tagStripByteCounts)
+		for i := 0; i < len(offsets) && i < len(counts); i++ {
+			t.wipe(int(offsets[i]), int(offsets[i])+int(counts[i]))
+		}
+		t.wipeIFD(ifd1)
+	}
+	// no next IFD
+	t.order.PutUint32(data[t.next(ifd0):], 0)
+
+	if exifIFD, ok := t.get(ifd0, tagExifIFD); ok {
+		if err := t.check(int(exifIFD)); err != nil {
+			return nil, err
+		}
+		t.setInt(int(exifIFD), tagPixelXDimension, uint32(width))
+		t.setInt(int(exifIFD), tagPixelYDimension, uint32(height))
+		if strip.Serial {
+			for _, tag := range []uint16{tagBodySerial, tagLensSerial, tagCameraOwnerName, tagMakerNote} {
+				t.remove(int(exifIFD), tag)
+			}
+		}
+	}
+	return append(append([]byte(nil), exifHeader...), data...), nil
+}
+
+// check verifies that the IFD at offset fits in the data
+func (t *tiff) check(offset int) error {
+	if offset < 8 || offset+2 > len(t.data) || t.next(offset)+4 > len(t.data) {
+		return fmt.Errorf("invalid IFD offset %d", offset)
+	}
+	return nil
+}
+
+func (t *tiff) count(ifd int) int {
+	return int(t.order.Uint16(t.data[ifd:]))
+}
+
+// next is the offset of the pointer to the next IFD
+func (t *tiff) next(ifd int) int {
+	return ifd + 2 + 12*t.count(ifd)
+}
+
+// find returns the offset of the entry of tag in the IFD
+func (t *tiff) find(ifd int, tag uint16) (int, bool) {
+	for i := 0; i < t.count(ifd); i++ {
+		entry := ifd + 2 + 12*i
+		if t.order.Uint16(t.data[entry:]) == tag {
+			return entry, true
+		}
+	}
+	return 0, false
+}
+
+// get returns the value of a single SHORT or LONG tag
+func (t *tiff) get(ifd int, tag uint16) (uint32, bool) {
+	entry, ok := t.find(ifd, tag)
+	if !ok {
+		return 0, false
+	}
+	switch t.order.Uint16(t.data[entry+2:]) {
+	case typeShort:
+		return uint32(t.order.Uint16(t.data[entry+8:])), true
+	case typeLong:
+		return t.order.Uint32(t.data[entry+8:]), true
+	}
+	return 0, false
+}
+
+// values returns the values of a SHORT or LONG tag
+func (t *tiff) values(ifd int, tag uint16) []uint32 {
+	entry, ok := t.find(ifd, tag)
+	if !ok {
+		return nil
+	}
+	start, end := t.value(entry)
+	var values []uint32
+	switch t.order.Uint16(t.data[entry+2:]) {
+	case typeShort:
+		for i := start; i+2 <= end; i += 2 {
+			values = append(values, uint32(t.order.Uint16(t.data[i:])))
+		}
+	case typeLong:
+		for i := start; i+4 <= end; i += 4 {
+			values = append(values, t.order.Uint32(t.data[i:]))
+		}
+	}
+	return values
+}
+
+// value returns the byte range of the value of an entry, inline or not.
+// The range is empty when it does not fit in the data.
+func (t *tiff) value(entry int) (int, int) {
+	size := typeSizes[t.order.Uint16(t.data[entry+2:])] * int(t.order.Uint32(t.data[entry+4:]))
+	start := entry + 8
+	if size > 4 {
+		start = int(t.order.Uint32(t.data[entry+8:]))
+	}
+	if size < 0 || start < 0 || start+size > len(t.data) {
+		return 0, 0
+	}
+	return start, start + size
+}
+
+// wipe zeroes the bytes from start to end that are in the data
+func (t *tiff) wipe(start, end int) {
+	if start < 8 {
+		start = 8
+	}
+	for i := start; i < end && i < len(t.data); i++ {
+		t.data[i] = 0
+	}
+}
+
+// wipeIFD zeroes the IFD at offset and the values of its entries
+func (t *tiff) wipeIFD(offset int) {
+	if t.check(offset) != nil {
+		return
+	}
+	for i := 0; i < t.count(offset); i++ {
+		t.wipe(t.value(offset + 2 + 12*i))
+	}
+	t.wipe(offset, t.next(offset)+4)
+}
+
+// setInt sets a single SHORT or LONG tag when present
+func (t *tiff) setInt(ifd int, tag uint16, value uint32) {
+	entry, ok := t.find(ifd, tag)
+	if !ok {
+		return
+	}
+	switch t.order.Uint16(t.data[entry+2:]) {
+	case typeShort:
+		if value > 0xFFFF {
+			// LONG fits in the same 4 bytes
+			t.order.PutUint16(t.data[entry+2:], typeLong)
+			t.order.PutUint32(t.data[entry+8:], value)
+			return
+		}
+		t.order.PutUint16(t.data[entry+8:], uint16(value))
+	case typeLong:
+		t.order.PutUint32(t.data[entry+8:], value)
+	}
+}
+
+// remove deletes the entry of tag and zeroes its value
+func (t *tiff) remove(ifd int, tag uint16) {
+	entry, ok := t.find(ifd, tag)
+	if !ok {
+		return
+	}
+	if start, end := t.value(entry); start != entry+8 {
+		t.wipe(start, end)
+	}
+	end := t.next(ifd) + 4
+	copy(t.data[entry:], t.data[entry+12:end])
+	for i := end - 12; i < end; i++ {
+		t.data[i] = 0
+	}
+	t.order.PutUint16(t.data[ifd:], uint16(t.count(ifd)-1))
+}
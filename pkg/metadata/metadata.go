@@ -0,0 +1,186 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	markerSOI   = 0xD8
+	markerSOS   = 0xDA
+	markerEOI   = 0xD9
+	markerAPP1  = 0xE1
+	markerAPP13 = 0xED
+	// maxSegment is the largest payload of a JPEG segment
+	maxSegment = 0xFFFF - 2
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iptcHeader = []byte("Photoshop 3.0\x00")
+)
+
+// Strip selects the metadata left out of the outputs
+type Strip struct {
+	EXIF bool
+	XMP  bool
+	IPTC bool
+	// GPS removes the location from EXIF and XMP
+	GPS bool
+	// Serial removes the camera and lens serial numbers, the owner name and
+	// the maker notes from EXIF and XMP
+	Serial bool
+}
+
+// ParseStrip parses a comma separated list of gps, serial, exif, xmp, iptc or all
+func ParseStrip(s string) (Strip, error) {
+	var strip Strip
+	for _, rule := range strings.Split(s, ",") {
+		switch strings.TrimSpace(rule) {
+		case "":
+		case "gps":
+			strip.GPS = true
+		case "serial":
+			strip.Serial = true
+		case "exif":
+			strip.EXIF = true
+		case "xmp":
+			strip.XMP = true
+		case "iptc":
+			strip.IPTC = true
+		case "all":
+			strip.EXIF, strip.XMP, strip.IPTC = true, true, true
+		default:
+			return strip, fmt.Errorf("unknown metadata %v. only gps, serial, exif, xmp, iptc or all", rule)
+		}
+	}
+	return strip, nil
+}
+
+// Metadata holds the EXIF, XMP and IPTC segments of a JPEG, to be copied to
+// the images derived from it. Extended XMP is not copied.
+type Metadata struct {
+	EXIF []byte
+	XMP  []byte
+	IPTC []byte
+	// Strip is applied when embedding
+	Strip Strip
+}
+
+// Read returns the metadata of a JPEG file. Other formats have none and
+// return an empty Metadata.
+func Read(imagePath string, strip Strip) (*Metadata, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %v. err=%w", imagePath, err)
+	}
+	defer f.Close()
+	m := &Metadata{Strip: strip}
+	r := bufio.NewReader(f)
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return m, nil
+	}
+	for {
+		marker, payload, err := readSegment(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read metadata of %v. err=%w", imagePath, err)
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return m, nil
+		}
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) && m.EXIF == nil && !strip.EXIF:
+			m.EXIF = payload
+		case marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader) && m.XMP == nil && !strip.XMP:
+			m.XMP = payload
+		case marker == markerAPP13 && bytes.HasPrefix(payload, iptcHeader) && m.IPTC == nil && !strip.IPTC:
+			m.IPTC = payload
+		}
+	}
+}
+
+// readSegment reads the marker and payload of the next segment
+func readSegment(r *bufio.Reader) (byte, []byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if b != 0xFF {
+		return 0, nil, fmt.Errorf("invalid marker 0x%02X", b)
+	}
+	// fill bytes
+	marker := byte(0xFF)
+	for marker == 0xFF {
+		if marker, err = r.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+	if marker == markerEOI || (marker >= 0xD0 && marker <= 0xD7) {
+		return marker, nil, nil
+	}
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	if length < 2 {
+		return 0, nil, fmt.Errorf("invalid length of segment 0x%02X", marker)
+	}
+	if marker == markerSOS {
+		return marker, nil, nil
+	}
+	payload := make([]byte, length-2)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return marker, payload, nil
+}
+
+// Embed inserts the metadata into an encoded JPEG of width x height pixels,
+// the orientation is reset since the image was rotated upright on load
+func (m *Metadata) Embed(jpeg []byte, width, height int) ([]byte, error) {
+	if len(jpeg) < 2 || jpeg[0] != 0xFF || jpeg[1] != markerSOI {
+		return nil, fmt.Errorf("not a JPEG image")
+	}
+	if m == nil {
+		return jpeg, nil
+	}
+	var segments bytes.Buffer
+	if m.EXIF != nil {
+		exif, err := fixEXIF(m.EXIF, width, height, m.Strip)
+		if err != nil {
+			// better no EXIF than a location or a serial number left behind
+			log.Warnf("dropping unreadable EXIF. err=%v", err.Error())
+		} else {
+			writeSegment(&segments, markerAPP1, exif)
+		}
+	}
+	if m.XMP != nil {
+		writeSegment(&segments, markerAPP1, fixXMP(m.XMP, width, height, m.Strip))
+	}
+	if m.IPTC != nil {
+		writeSegment(&segments, markerAPP13, m.IPTC)
+	}
+	out := make([]byte, 0, len(jpeg)+segments.Len())
+	out = append(out, jpeg[:2]...)
+	out = append(out, segments.Bytes()...)
+	return append(out, jpeg[2:]...), nil
+}
+
+func writeSegment(w *bytes.Buffer, marker byte, payload []byte) {
+	if len(payload) > maxSegment {
+		log.Warnf("dropping metadata segment 0x%02X of %d bytes, too large", marker, len(payload))
+		return
+	}
+	w.Write([]byte{0xFF, marker})
+	binary.Write(w, binary.BigEndian, uint16(len(payload)+2))
+	w.Write(payload)
+}
@@ -0,0 +1,187 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEXIF returns a big endian EXIF payload with an orientation, a GPS IFD
+// holding a latitude, an Exif IFD holding the dimensions and a serial number,
+// and a thumbnail IFD
+func testEXIF() []byte {
+	var b bytes.Buffer
+	b.Write(exifHeader)
+	b.WriteString("MM\x00\x2a")
+	w := func(v interface{}) { binary.Write(&b, binary.BigEndian, v) }
+	entry := func(tag, typ uint16, count, value uint32) {
+		w(tag)
+		w(typ)
+		w(count)
+		w(value)
+	}
+	// IFD0 at 8, 3 entries: 8+2+36+4 = 50
+	w(uint32(8))
+	w(uint16(3))
+	entry(tagOrientation, typeShort, 1, 6<<16)
+	entry(tagExifIFD, typeLong, 1, 50)
+	entry(tagGPSIFD, typeLong, 1, 92)
+	w(uint32(122))
+	// Exif IFD at 50, 3 entries: 50+2+36+4 = 92
+	w(uint16(3))
+	entry(tagPixelXDimension, typeShort, 1, 4000<<16)
+	entry(tagPixelYDimension, typeLong, 1, 3000)
+	entry(tagBodySerial, 2, uint32(len(testSerial)), 152)
+	w(uint32(0))
+	// GPS IFD at 92, 2 entries: 92+2+24+4 = 122
+	w(uint16(2))
+	entry(0x0000, 1, 4, 0x02020000)
+	entry(0x0002, 5, 3, 165)
+	w(uint32(0))
+	// thumbnail IFD at 122, 2 entries: 122+2+24+4 = 152
+	w(uint16(2))
+	entry(tagThumbnail, typeLong, 1, 189)
+	entry(tagThumbnailLength, typeLong, 1, uint32(len(testThumbnail)))
+	w(uint32(0))
+	// values: serial at 152, latitude at 165, thumbnail at 189
+	b.WriteString(testSerial)
+	b.Write(testLatitude)
+	b.WriteString(testThumbnail)
+	return b.Bytes()
+}
+
+const (
+	testSerial    = "SN-987654321\x00"
+	testThumbnail = "THUMBDATA"
+)
+
+// testLatitude is 48/1 51/1 2400/100
+var testLatitude = []byte{0, 0, 0, 48, 0, 0, 0, 1, 0, 0, 0, 51, 0, 0, 0, 1, 0, 0, 9, 96, 0, 0, 0, 100}
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description tiff:Orientation="6" exif:PixelXDimension="4000" exif:GPSLatitude="48,51.4N" aux:SerialNumber="123">` +
+	`<exif:GPSLongitude>2,17.5E</exif:GPSLongitude><exif:PixelYDimension>3000</exif:PixelYDimension><dc:rights>me</dc:rights></rdf:Description></rdf:RDF></x:xmpmeta>`
+
+func TestFixEXIF(t *testing.T) {
+	fixed, err := fixEXIF(testEXIF(), 40, 30, Strip{GPS: true, Serial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf := &tiff{data: fixed[len(exifHeader):], order: binary.BigEndian}
+	if v, _ := tf.get(8, tagOrientation); v != 1 {
+		t.Errorf("orientation = %d, want 1", v)
+	}
+	if _, ok := tf.find(8, tagGPSIFD); ok {
+		t.Errorf("GPS IFD not removed")
+	}
+	if next := binary.BigEndian.Uint32(tf.data[tf.next(8):]); next != 0 {
+		t.Errorf("thumbnail IFD at %d not removed", next)
+	}
+	exifIFD, ok := tf.get(8, tagExifIFD)
+	if !ok {
+		t.Fatalf("Exif IFD lost")
+	}
+	if v, _ := tf.get(int(exifIFD), tagPixelXDimension); v != 40 {
+		t.Errorf("width = %d, want 40", v)
+	}
+	if v, _ := tf.get(int(exifIFD), tagPixelYDimension); v != 30 {
+		t.Errorf("height = %d, want 30", v)
+	}
+	if _, ok := tf.find(int(exifIFD), tagBodySerial); ok {
+		t.Errorf("serial number not removed")
+	}
+	// nothing stripped is left in the payload
+	leaks := map[string][]byte{
+		"serial number": []byte("987654321"),
+		"latitude":      testLatitude,
+		"GPS IFD entry": {0, 2, 0, 5, 0, 0, 0, 3},
+		"thumbnail":     []byte(testThumbnail),
+	}
+	for name, leak := range leaks {
+		if bytes.Contains(fixed, leak) {
+			t.Errorf("%v left in the payload", name)
+		}
+	}
+	kept, err := fixEXIF(testEXIF(), 40, 30, Strip{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(kept, testLatitude) || !bytes.Contains(kept, []byte("987654321")) {
+		t.Errorf("GPS and serial number removed without strip")
+	}
+	if bytes.Contains(kept, []byte(testThumbnail)) {
+		t.Errorf("thumbnail left in the payload")
+	}
+
+	if _, err := fixEXIF(append(exifHeader, "XX"...), 1, 1, Strip{}); err == nil {
+		t.Errorf("invalid EXIF accepted")
+	}
+}
+
+func TestFixXMP(t *testing.T) {
+	got := string(fixXMP([]byte(testXMP), 40, 30, Strip{GPS: true, Serial: true}))
+	for _, want := range []string{`tiff:Orientation="1"`, `exif:PixelXDimension="40"`, `<exif:PixelYDimension>30</exif:PixelYDimension>`, `<dc:rights>me</dc:rights>`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%v missing from %v", want, got)
+		}
+	}
+	for _, unwanted := range []string{"GPS", "SerialNumber"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("%v left in %v", unwanted, got)
+		}
+	}
+}
+
+func TestReadEmbed(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 4, 3)), nil); err != nil {
+		t.Fatal(err)
+	}
+	src := &Metadata{EXIF: testEXIF(), XMP: append(append([]byte(nil), xmpHeader...), testXMP...), IPTC: append(append([]byte(nil), iptcHeader...), "8BIM"...)}
+	data, err := src.Embed(encoded.Bytes(), 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcPath := filepath.Join(t.TempDir(), "a.jpg")
+	if err := os.WriteFile(srcPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("embedded JPEG does not decode. err=%v", err)
+	}
+
+	tests := []struct {
+		strip                       Strip
+		wantEXIF, wantXMP, wantIPTC bool
+	}{
+		{Strip{}, true, true, true},
+		{Strip{XMP: true, IPTC: true}, true, false, false},
+		{Strip{EXIF: true}, false, true, true},
+	}
+	for _, tt := range tests {
+		m, err := Read(srcPath, tt.strip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (m.EXIF != nil) != tt.wantEXIF || (m.XMP != nil) != tt.wantXMP || (m.IPTC != nil) != tt.wantIPTC {
+			t.Errorf("Read(%+v) exif=%v xmp=%v iptc=%v", tt.strip, m.EXIF != nil, m.XMP != nil, m.IPTC != nil)
+		}
+	}
+}
+
+func TestParseStrip(t *testing.T) {
+	strip, err := ParseStrip("gps, serial")
+	if err != nil || strip != (Strip{GPS: true, Serial: true}) {
+		t.Errorf("ParseStrip = %+v, %v", strip, err)
+	}
+	if strip, _ := ParseStrip("all"); !strip.EXIF || !strip.XMP || !strip.IPTC {
+		t.Errorf("all = %+v", strip)
+	}
+	if _, err := ParseStrip("thumbnail"); err == nil {
+		t.Errorf("unknown rule accepted")
+	}
+}
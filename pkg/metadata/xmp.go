@@ -0,0 +1,50 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	xmpGPS    = []string{`exif:GPS\w+`}
+	xmpSerial = []string{`aux:SerialNumber`, `aux:LensSerialNumber`, `aux:OwnerName`,
+		`exifEX:BodySerialNumber`, `exifEX:LensSerialNumber`, `exifEX:CameraOwnerName`}
+)
+
+// fixXMP returns a copy of the XMP payload with the orientation and
+// dimensions updated and the stripped properties removed. Properties are
+// either attributes or simple elements of the rdf:Description.
+func fixXMP(payload []byte, width, height int, strip Strip) []byte {
+	xmp := append([]byte(nil), payload...)
+	xmp = setProperty(xmp, `tiff:Orientation`, 1)
+	xmp = setProperty(xmp, `tiff:ImageWidth`, width)
+	xmp = setProperty(xmp, `tiff:ImageLength`, height)
+	xmp = setProperty(xmp, `exif:PixelXDimension`, width)
+	xmp = setProperty(xmp, `exif:PixelYDimension`, height)
+	if strip.GPS {
+		for _, name := range xmpGPS {
+			xmp = removeProperty(xmp, name)
+		}
+	}
+	if strip.Serial {
+		for _, name := range xmpSerial {
+			xmp = removeProperty(xmp, name)
+		}
+	}
+	return xmp
+}
+
+func setProperty(xmp []byte, name string, value int) []byte {
+	attribute := regexp.MustCompile(`(\s` + name + `=")[^"]*(")`)
+	element := regexp.MustCompile(`(<` + name + `>)[^<]*(</` + name + `>)`)
+	replacement := []byte(fmt.Sprintf("${1}%d${2}", value))
+	xmp = attribute.ReplaceAll(xmp, replacement)
+	return element.ReplaceAll(xmp, replacement)
+}
+
+func removeProperty(xmp []byte, name string) []byte {
+	attribute := regexp.MustCompile(`\s` + name + `="[^"]*"`)
+	element := regexp.MustCompile(`(?s)<` + name + `\b[^>]*?(/>|>.*?</` + name + `>)`)
+	xmp = attribute.ReplaceAll(xmp, nil)
+	return element.ReplaceAll(xmp, nil)
+}
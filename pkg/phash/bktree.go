@@ -0,0 +1,77 @@
+package phash
+
+// BKTree indexes hashes by Hamming distance, so that the hashes close to a
+// given one are found without comparing it to every hash
+type BKTree struct {
+	root *bkNode
+	size int
+}
+
+type bkNode struct {
+	hash     Hash
+	values   []interface{}
+	children map[int]*bkNode
+}
+
+// Match is a value found by Search
+type Match struct {
+	Hash     Hash
+	Value    interface{}
+	Distance int
+}
+
+func (t *BKTree) Len() int {
+	return t.size
+}
+
+// Add indexes value under hash
+func (t *BKTree) Add(hash Hash, value interface{}) {
+	t.size += 1
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, values: []interface{}{value}}
+		return
+	}
+	node := t.root
+	for {
+		d := Distance(node.hash, hash)
+		if d == 0 {
+			node.values = append(node.values, value)
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{hash: hash, values: []interface{}{value}}
+			return
+		}
+		node = child
+	}
+}
+
+// Search returns the values indexed at most maxDistance bits away from hash
+func (t *BKTree) Search(hash Hash, maxDistance int) []Match {
+	var matches []Match
+	if t.root == nil {
+		return nil
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := Distance(node.hash, hash)
+		if d <= maxDistance {
+			for _, v := range node.values {
+				matches = append(matches, Match{Hash: node.hash, Value: v, Distance: d})
+			}
+		}
+		// triangle inequality: only children between d-max and d+max can match
+		for cd, child := range node.children {
+			if cd >= d-maxDistance && cd <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return matches
+}
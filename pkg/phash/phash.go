@@ -0,0 +1,164 @@
+package phash
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/nfnt/resize"
+)
+
+// Algorithms
+const (
+	// AHash compares each pixel of an 8x8 thumbnail with the mean
+	AHash = "ahash"
+	// DHash compares each pixel of a 9x8 thumbnail with its right neighbour
+	DHash = "dhash"
+	// PHash compares the low frequencies of a 32x32 thumbnail DCT with their median
+	PHash = "phash"
+)
+
+// Hash is a 64 bits perceptual hash
+type Hash uint64
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Distance returns the number of different bits between two hashes
+func Distance(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// Validate returns an error for an unknown algorithm
+func Validate(algorithm string) error {
+	switch algorithm {
+	case AHash, DHash, PHash:
+		return nil
+	}
+	return fmt.Errorf("unknown perceptual hash %v. only %s, %s or %s", algorithm, AHash, DHash, PHash)
+}
+
+// Compute returns the perceptual hash of img
+func Compute(img image.Image, algorithm string) (Hash, error) {
+	switch algorithm {
+	case AHash:
+		return aHash(img), nil
+	case DHash:
+		return dHash(img), nil
+	case PHash:
+		return pHash(img), nil
+	}
+	return 0, Validate(algorithm)
+}
+
+// Read decodes an image and returns its perceptual hash
+func Read(r io.Reader, algorithm string) (Hash, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, fmt.Errorf("unable to decode image. err=%w", err)
+	}
+	return Compute(img, algorithm)
+}
+
+// gray returns the luminance of img scaled to w x h
+func gray(img image.Image, w, h int) [][]float64 {
+	small := resize.Resize(uint(w), uint(h), img, resize.Bilinear)
+	bounds := small.Bounds()
+	pixels := make([][]float64, h)
+	for y := 0; y < h; y++ {
+		pixels[y] = make([]float64, w)
+		for x := 0; x < w; x++ {
+			pixels[y][x] = float64(color.GrayModel.Convert(small.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y)
+		}
+	}
+	return pixels
+}
+
+func aHash(img image.Image) Hash {
+	pixels := gray(img, 8, 8)
+	var mean float64
+	for _, row := range pixels {
+		for _, p := range row {
+			mean += p
+		}
+	}
+	mean /= 64
+	var h Hash
+	for _, row := range pixels {
+		for _, p := range row {
+			h <<= 1
+			if p > mean {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+func dHash(img image.Image) Hash {
+	pixels := gray(img, 9, 8)
+	var h Hash
+	for _, row := range pixels {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if row[x] < row[x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+func pHash(img image.Image) Hash {
+	const size = 32
+	pixels := gray(img, size, size)
+
+	// separable 2D DCT-II, only the 8x8 low frequencies are needed
+	cos := make([][]float64, 8)
+	for u := range cos {
+		cos[u] = make([]float64, size)
+		for x := 0; x < size; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+	rows := make([][]float64, size)
+	for y := 0; y < size; y++ {
+		rows[y] = make([]float64, 8)
+		for u := 0; u < 8; u++ {
+			for x := 0; x < size; x++ {
+				rows[y][u] += pixels[y][x] * cos[u][x]
+			}
+		}
+	}
+	coefs := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coefs = append(coefs, sum)
+		}
+	}
+
+	// the DC coefficient is the mean brightness, left out of the median
+	sorted := append([]float64(nil), coefs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	var h Hash
+	for _, c := range coefs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
@@ -0,0 +1,86 @@
+package phash
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+// scene draws a few soft blobs, closer to a photo than a flat gradient
+func scene(seed int64, w, h int) image.Image {
+	r := rand.New(rand.NewSource(seed))
+	type blob struct{ x, y, size, value float64 }
+	var blobs []blob
+	for i := 0; i < 12; i++ {
+		blobs = append(blobs, blob{r.Float64(), r.Float64(), 0.05 + r.Float64()*0.2, r.Float64()*255 - 128})
+	}
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 128.0
+			for _, b := range blobs {
+				dx, dy := float64(x)/float64(w)-b.x, float64(y)/float64(h)-b.y
+				v += b.value * math.Exp(-(dx*dx+dy*dy)/(b.size*b.size))
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(math.Max(0, math.Min(255, v)))})
+		}
+	}
+	return img
+}
+
+func TestCompute(t *testing.T) {
+	original := scene(1, 600, 400)
+	resized := resize.Resize(200, 0, original, resize.Lanczos3)
+	other := scene(2, 600, 400)
+	for _, algorithm := range []string{AHash, DHash, PHash} {
+		h1, _ := Compute(original, algorithm)
+		h2, _ := Compute(resized, algorithm)
+		h3, _ := Compute(other, algorithm)
+		if d := Distance(h1, h2); d > 4 {
+			t.Errorf("%s: resized copy is %d bits apart", algorithm, d)
+		}
+		if d := Distance(h1, h3); d <= 10 {
+			t.Errorf("%s: different image is only %d bits apart", algorithm, d)
+		}
+	}
+	if _, err := Compute(original, "md5"); err == nil {
+		t.Errorf("Compute() with an unknown algorithm got no error")
+	}
+}
+
+func TestBKTree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tree := &BKTree{}
+	var hashes []Hash
+	for i := 0; i < 2000; i++ {
+		h := Hash(r.Uint64())
+		hashes = append(hashes, h)
+		tree.Add(h, i)
+	}
+	for _, query := range hashes[:20] {
+		var want []int
+		for i, h := range hashes {
+			if Distance(h, query) <= 24 {
+				want = append(want, i)
+			}
+		}
+		var got []int
+		for _, m := range tree.Search(query, 24) {
+			got = append(got, m.Value.(int))
+		}
+		sort.Ints(got)
+		if len(got) != len(want) {
+			t.Fatalf("Search() got %d matches, want %d", len(got), len(want))
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("Search() got %v, want %v", got, want)
+			}
+		}
+	}
+}
@@ -2,23 +2,24 @@ package photo
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/fs"
-	"io/ioutil"
 	"os"
-	"os/signal"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
-	"code.cloudfoundry.org/bytefmt"
-	"github.com/schollz/progressbar/v3"
-	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/manifest"
+	"github.com/vfoucault/goPhoto/pkg/throttle"
 	"github.com/vfoucault/goPhoto/pkg/utils"
+	"golang.org/x/time/rate"
 )
 
 type Copier struct {
@@ -27,15 +28,89 @@ type Copier struct {
 	Stats  struct {
 		Count   int
 		Skipped int
+		Failed  int
 		Size    int64
 	}
-	StatsMutex  sync.Mutex
-	Workers     []*Worker
-	CopyQueue   chan *Photo
-	Context     context.Context
-	CancelFunc  context.CancelFunc
-	Wg          sync.WaitGroup
-	ProgressBar *progressbar.ProgressBar
+	// Errors holds the files that could not be copied
+	Errors utils.ErrorCollector
+	// WalkErrors holds the paths of the source that could not be listed
+	WalkErrors utils.ErrorCollector
+	StatsMutex sync.Mutex
+	Workers    []*Worker
+	CopyQueue  chan *Photo
+	Context    context.Context
+	CancelFunc context.CancelFunc
+	Wg         sync.WaitGroup
+	// Log defaults to the logrus standard logger
+	Log logrus.FieldLogger
+	// FS defaults to OSFileSystem
+	FS FileSystem
+	// OnEvent receives the progress events, calls are serialized by EventMutex
+	OnEvent    func(Event)
+	EventMutex sync.Mutex
+	// Manifest collects the sha256 of the imported photos, by destination relative path
+	Manifest      *manifest.Manifest
+	ManifestMutex sync.Mutex
+	// Reporter receives one entry per source file when a report was requested
+	Reporter    Reporter
+	ReportMutex sync.Mutex
+	// Devices bounds the concurrent reads per source device
+	Devices *DeviceLimits
+	// HashOnly makes Search skip the EXIF date and close the files once
+	// hashed, for callers that only compare contents
+	HashOnly bool
+	// shrink stops a writer, see autoTune
+	shrink chan struct{}
+	// ReadLimiter and WriteLimiter throttle every worker, nil is unlimited
+	ReadLimiter  *rate.Limiter
+	WriteLimiter *rate.Limiter
+	// watched counts the photos found by the Watcher, they are not kept in
+	// Photos once processed
+	watched int
+}
+
+func (c *Copier) logger() logrus.FieldLogger {
+	if c.Log == nil {
+		return logrus.StandardLogger()
+	}
+	return c.Log
+}
+
+// reader throttles r with ReadLimiter
+func (c *Copier) reader(r io.Reader) io.Reader {
+	return throttle.NewReader(c.Context, r, c.ReadLimiter)
+}
+
+func (c *Copier) fileSystem() FileSystem {
+	if c.FS == nil {
+		return OSFileSystem{}
+	}
+	return c.FS
+}
+
+// Emit sends e to OnEvent with the current progress
+func (c *Copier) Emit(e Event) {
+	if c.OnEvent == nil {
+		return
+	}
+	if e.Total == 0 {
+		e.Total = c.Total()
+	}
+	e.Processed = c.Processed()
+	c.EventMutex.Lock()
+	defer c.EventMutex.Unlock()
+	c.OnEvent(e)
+}
+
+func (c *Copier) Report(e *ReportEntry) {
+	if c.Reporter == nil {
+		return
+	}
+	c.ReportMutex.Lock()
+	defer c.ReportMutex.Unlock()
+	if err := c.Reporter.Write(e); err != nil {
+		c.logger().Errorf("unable to write report entry for %v. err=%v", e.Source, err.Error())
+	}
 }
 
 func (c *Copier) IncrementStats(size int64) {
@@ -51,28 +126,101 @@ func (c *Copier) IncrementSkipped() {
 	c.Stats.Skipped += 1
 }
 
+func (c *Copier) IncrementFailed() {
+	c.StatsMutex.Lock()
+	defer c.StatsMutex.Unlock()
+	c.Stats.Failed += 1
+}
+
+// FailPhoto records a photo that could not be copied
+func (c *Copier) FailPhoto(p *Photo, err error, start time.Time) {
+	c.logger().Errorf(err.Error())
+	c.Errors.Add(path.Join(p.Path, p.FileName), err)
+	c.Report(p.ReportEntry(ActionFailed, err.Error(), start))
+	c.IncrementFailed()
+}
+
+// Total returns the number of photos to copy known so far
+func (c *Copier) Total() int {
+	c.StatsMutex.Lock()
+	defer c.StatsMutex.Unlock()
+	return len(c.Photos) + c.watched
+}
+
+// Processed returns the number of photos copied, skipped or failed so far
+func (c *Copier) Processed() int {
+	c.StatsMutex.Lock()
+	defer c.StatsMutex.Unlock()
+	return c.Stats.Count + c.Stats.Skipped + c.Stats.Failed
+}
+
+// AddToManifest records a photo present at its target path, nothing is done
+// if no manifest was requested
+func (c *Copier) AddToManifest(p *Photo) {
+	if c.Manifest == nil {
+		return
+	}
+	rel, err := filepath.Rel(c.Config.DestDirectory, path.Join(p.GetTargetPath(), p.FileName))
+	if err != nil {
+		c.logger().Errorf("unable to add %v to manifest. err=%v", p.FileName, err.Error())
+		return
+	}
+	c.ManifestMutex.Lock()
+	defer c.ManifestMutex.Unlock()
+	c.Manifest.Entries[filepath.ToSlash(rel)] = hex.EncodeToString(p.Sha256)
+}
+
+// WriteManifest writes the import manifest to Config.ManifestPath and, for
+// BagIt destinations, updates the bag files
+func (c *Copier) WriteManifest() error {
+	if c.Manifest == nil {
+		return nil
+	}
+	if c.Config.ManifestPath != "" {
+		if err := c.Manifest.Update(c.Config.ManifestPath); err != nil {
+			return err
+		}
+		c.logger().Infof("Wrote %d checksums to %v", len(c.Manifest.Entries), c.Config.ManifestPath)
+	}
+	if c.Config.BagIt {
+		if err := manifest.WriteBag(c.Config.DestDirectory, c.Manifest, c.Config.BagInfo); err != nil {
+			return err
+		}
+		c.logger().Infof("Updated BagIt bag %v", c.Config.DestDirectory)
+	}
+	return nil
+}
+
 func (c *Copier) CreateDestDirs() {
 	var dirs = make(map[string]int)
 	for _, x := range c.Photos {
 		dirs[x.GetTargetPath()] += 1
 	}
 	for k, _ := range dirs {
-		log.Debugf("creating directory %v", k)
-		err := os.MkdirAll(k, 0750)
+		c.logger().Debugf("creating directory %v", k)
+		err := c.fileSystem().MkdirAll(k, 0750)
 		if err != nil {
-			log.Errorf("unable to create directory %v. err=%v", k, err.Error())
+			c.logger().Errorf("unable to create directory %v. err=%v", k, err.Error())
 		}
 	}
 }
 
 func NewCopier(config *config.Config, pctx context.Context) *Copier {
 	ctx, cancel := context.WithCancel(pctx)
-	return &Copier{
-		Config:     config,
-		CopyQueue:  make(chan *Photo, 10000),
-		Context:    ctx,
-		CancelFunc: cancel,
+	c := &Copier{
+		Config:       config,
+		CopyQueue:    make(chan *Photo, 10000),
+		Context:      ctx,
+		CancelFunc:   cancel,
+		Devices:      NewDeviceLimits(config.DeviceReaders),
+		shrink:       make(chan struct{}),
+		ReadLimiter:  throttle.NewLimiter(config.ReadLimit),
+		WriteLimiter: throttle.NewLimiter(config.WriteLimit),
+	}
+	if config.ManifestPath != "" || config.BagIt {
+		c.Manifest = manifest.New(manifest.SHA256)
 	}
+	return c
 }
 
 func (c *Copier) Wait() {
@@ -81,8 +229,7 @@ func (c *Copier) Wait() {
 		case <-c.Context.Done():
 			return
 		default:
-			if len(c.Photos) == c.Stats.Count+c.Stats.Skipped {
-				c.ProgressBar.Clear()
+			if c.Total() == c.Processed() {
 				c.Stop()
 				break
 			}
@@ -93,123 +240,186 @@ func (c *Copier) Wait() {
 
 func (c *Copier) Start() error {
 	// check if target directory exists
-	_, err := os.Stat(c.Config.DestDirectory)
+	_, err := c.fileSystem().Stat(c.Config.DestDirectory)
 
 	if err != nil {
-		if err == os.ErrNotExist {
-			if err = os.MkdirAll(c.Config.DestDirectory, 0750); err != nil {
-				return fmt.Errorf("unable to create target directory %v. err=%v", c.Config.DestDirectory, err.Error())
-			}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("unable to access target directory %v. err=%w", c.Config.DestDirectory, err)
+		}
+		if err = c.fileSystem().MkdirAll(c.Config.DestDirectory, 0750); err != nil {
+			return fmt.Errorf("unable to create target directory %v. err=%w", c.Config.DestDirectory, err)
 		}
 	}
 
-	for _, x := range c.Photos {
-		c.CopyQueue <- x
+	// launch the writers before queueing, the queue is bounded
+	writers := c.Config.Workers
+	if writers < 1 {
+		writers = 1
+	}
+	if c.Config.AutoTune && writers > 2 {
+		writers = 2
+	}
+	for i := 0; i < writers; i++ {
+		c.startWriter()
+	}
+	if c.Config.AutoTune {
+		go c.autoTune(writers)
 	}
 
-	// launch workers
-	for i := 0; i < c.Config.Workers; i++ {
-		worker := NewWorker(i, c)
-		c.Wg.Add(1)
-		c.Workers = append(c.Workers, worker)
-		go worker.Start()
+	for _, x := range c.Photos {
+		select {
+		case c.CopyQueue <- x:
+		case <-c.Context.Done():
+			return nil
+		}
 	}
 	return nil
 }
 
 func (c *Copier) Stop() {
-	log.Debugf("Stopping copier")
+	c.logger().Debugf("Stopping copier")
 	c.CancelFunc()
 }
 
-func (c *Copier) Search() {
+type found struct {
+	info fs.FileInfo
+	dir  string
+}
+
+// Search lists the photos of the source directory, their metadata and
+// hashes are read by Config.ReadWorkers goroutines. Files that cannot be
+// read are recorded in Errors and the paths that cannot be listed in
+// WalkErrors, only an unreadable source directory is fatal.
+func (c *Copier) Search() error {
+	readers := c.Config.ReadWorkers
+	if readers < 1 {
+		readers = 1
+	}
+	queue := make(chan found)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range queue {
+				if p := c.readPhoto(f.info, f.dir); p != nil {
+					c.StatsMutex.Lock()
+					c.Photos = append(c.Photos, p)
+					c.StatsMutex.Unlock()
+				}
+			}
+		}()
+	}
+	err := c.search(queue)
+	close(queue)
+	wg.Wait()
+
+	// readers finish in any order
+	sort.Slice(c.Photos, func(i, j int) bool {
+		return path.Join(c.Photos[i].Path, c.Photos[i].FileName) < path.Join(c.Photos[j].Path, c.Photos[j].FileName)
+	})
+	return err
+}
+
+// Cluster groups the photos found by Search into the sets and events
+// Config asks for, which are part of their target path
+func (c *Copier) Cluster() error {
+	if err := c.validateSets(); err != nil {
+		return err
+	}
+	if c.Config.Sets != "" {
+		sets := c.GroupSets()
+		c.logger().Infof("Found %d bursts and brackets", len(sets))
+	}
+	if strings.Contains(c.Config.DestFileFormat, EventPlaceholder) {
+		var places []Place
+		if c.Config.PlacesPath != "" {
+			var err error
+			if places, err = ReadPlaces(c.Config.PlacesPath); err != nil {
+				return err
+			}
+		}
+		events := c.ClusterEvents(places)
+		c.logger().Infof("Found %d events", len(events))
+	}
+	return nil
+}
+
+func (c *Copier) search(queue chan<- found) error {
 	if c.Config.NoRecurse {
-		files, _ := ioutil.ReadDir(c.Config.SourceDirectory)
+		files, err := c.fileSystem().ReadDir(c.Config.SourceDirectory)
+		if err != nil {
+			return fmt.Errorf("unable to read source directory %v. err=%w", c.Config.SourceDirectory, err)
+		}
 		for _, f := range files {
 			if utils.IsImage(f) {
-				c.addPhoto(f, c.Config.SourceDirectory)
+				queue <- found{f, c.Config.SourceDirectory}
+			} else if f.Mode().IsRegular() {
+				c.reportFiltered(f, path.Join(c.Config.SourceDirectory, f.Name()))
 			}
 		}
 	} else {
-		filepath.Walk(c.Config.SourceDirectory, func(aPath string, f os.FileInfo, _ error) error {
+		err := c.fileSystem().Walk(c.Config.SourceDirectory, func(aPath string, f os.FileInfo, err error) error {
+			if err != nil {
+				if aPath == c.Config.SourceDirectory {
+					return err
+				}
+				c.logger().Errorf("unable to read %v. err=%v", aPath, err.Error())
+				c.WalkErrors.Add(aPath, err)
+				return nil
+			}
 			if utils.IsImage(f) {
-				c.addPhoto(f, strings.TrimSuffix(aPath, f.Name()))
+				queue <- found{f, strings.TrimSuffix(aPath, f.Name())}
+			} else if f.Mode().IsRegular() {
+				c.reportFiltered(f, aPath)
 			}
 			return nil
 		})
-	}
-}
-
-func (c *Copier) addPhoto(f fs.FileInfo, fPath string) {
-	photo := &Photo{Path: fPath, FileName: f.Name(), Copier: c}
-	stat := f.Sys().(*syscall.Stat_t)
-	photo.Atime = time.Unix(stat.Atimespec.Sec, stat.Atimespec.Nsec)
-	photo.Ctime = time.Unix(stat.Ctimespec.Sec, stat.Ctimespec.Nsec)
-	photo.Mtime = time.Unix(stat.Mtimespec.Sec, stat.Mtimespec.Nsec)
-	if err := photo.GetDateTaken(); err != nil {
-		log.Errorf("unable to get image date for image %v. err=%v", path.Join(c.Config.SourceDirectory, f.Name()), err.Error())
-	} else {
-		err := photo.GetHash()
 		if err != nil {
-			log.Errorf("unable to get hash for file %s", f.Name())
+			return fmt.Errorf("unable to read source directory %v. err=%w", c.Config.SourceDirectory, err)
 		}
-		c.Photos = append(c.Photos, photo)
 	}
+	return nil
 }
 
-func RunCopier(cfg *config.Config) {
-	start := time.Now()
-
-	cfg.PrintConfig()
-
-	ctx := context.Background()
-	copier := NewCopier(cfg, ctx)
-
-	copier.Search()
-	copier.CreateDestDirs()
-	copier.InitProgressBar()
-
-	// Handle stop and more
-	signalChannel := make(chan os.Signal, 2)
-	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
-	go handleSignals(signalChannel, copier)
-
-	log.Debugf("Will have to copy %v pictures", len(copier.Photos))
+func (c *Copier) reportFiltered(f fs.FileInfo, fPath string) {
+	c.Report(&ReportEntry{Source: fPath, Action: ActionFiltered, Reason: "not an image", Size: f.Size()})
+}
 
-	if err := copier.Start(); err != nil {
-		log.Errorf(err.Error())
-		os.Exit(1)
+// readPhoto reads the metadata and hashes of a source file, failures are
+// recorded in Errors and nil is returned
+func (c *Copier) readPhoto(f fs.FileInfo, fPath string) *Photo {
+	start := time.Now()
+	photo := &Photo{Path: fPath, FileName: f.Name(), Size: f.Size(), Device: fileDevice(f), Copier: c}
+	photo.Atime, photo.Ctime, photo.Mtime = fileTimes(f)
+	err := c.Devices.Acquire(c.Context, photo.Device)
+	if err == nil {
+		if !c.HashOnly {
+			err = photo.GetDateTaken()
+		}
+		if err == nil {
+			err = photo.GetHash()
+		}
+		c.Devices.Release(photo.Device)
 	}
-	copier.Wait()
-
-	elapsed := time.Since(start)
-	//fmt.Println()
-	log.Infof("Copy ended. Took %v", elapsed)
-	log.Infof("Copied %d images / %s.", copier.Stats.Count, bytefmt.ByteSize(uint64(copier.Stats.Size)))
-	if copier.Stats.Skipped > 0 {
-		log.Infof("Skipped %d images that were duplicates", copier.Stats.Skipped)
+	if c.HashOnly && photo.File != nil {
+		photo.File.Close()
+		photo.File = nil
 	}
-	log.Infof("Byte rate %v/s", bytefmt.ByteSize(uint64(copier.Stats.Size/int64(elapsed.Seconds()))))
-}
-
-func (c *Copier) InitProgressBar() {
-	c.ProgressBar = progressbar.New(len(c.Photos))
-}
-
-//func handleSignals(signChannel chan os.Signal, processorManager *models.ProcessorManager) {
-func handleSignals(signChannel chan os.Signal, copier *Copier) {
-	log.Debugf("Running signal handler")
-	// Handle stop and more
-	for {
-		sig := <-signChannel
-		switch sig {
-		case os.Interrupt, syscall.SIGTERM:
-			log.Infof("Received signal %v", sig)
-			log.Infof("Shutting down application. Kill running/pending jobs")
-			copier.Stop()
-			return
-		default:
-			log.Errorf("Unable to handle signal %v", sig.String())
+	if err != nil {
+		c.logger().Errorf("unable to read image %v. err=%v", path.Join(fPath, f.Name()), err.Error())
+		if photo.File != nil {
+			photo.File.Close()
 		}
+		c.Errors.Add(path.Join(fPath, f.Name()), err)
+		c.Report(&ReportEntry{
+			Source:     path.Join(fPath, f.Name()),
+			Action:     ActionFailed,
+			Reason:     err.Error(),
+			Size:       f.Size(),
+			DurationMs: durationMs(start),
+		})
+		return nil
 	}
+	return photo
 }
@@ -4,11 +4,9 @@ import (
 	"context"
 	"os"
 	"path"
-	"sync"
 	"testing"
 	"time"
 
-	"github.com/schollz/progressbar/v3"
 	"github.com/vfoucault/goPhoto/pkg/config"
 )
 
@@ -19,15 +17,13 @@ func TestCopier_incrementStats(t *testing.T) {
 		Stats  struct {
 			Count   int
 			Skipped int
+			Failed  int
 			Size    int64
 		}
-		StatsMutex  sync.Mutex
-		Workers     []*Worker
-		CopyQueue   chan *Photo
-		Context     context.Context
-		CancelFunc  context.CancelFunc
-		Wg          sync.WaitGroup
-		ProgressBar *progressbar.ProgressBar
+		Workers    []*Worker
+		CopyQueue  chan *Photo
+		Context    context.Context
+		CancelFunc context.CancelFunc
 	}
 	type args struct {
 		size int64
@@ -45,9 +41,9 @@ func TestCopier_incrementStats(t *testing.T) {
 				Stats: struct {
 					Count   int
 					Skipped int
+					Failed  int
 					Size    int64
 				}{},
-				StatsMutex: sync.Mutex{},
 			},
 			args: args{
 				size: 128,
@@ -59,16 +55,13 @@ func TestCopier_incrementStats(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &Copier{
-				Config:      tt.fields.Config,
-				Photos:      tt.fields.Photos,
-				Stats:       tt.fields.Stats,
-				StatsMutex:  tt.fields.StatsMutex,
-				Workers:     tt.fields.Workers,
-				CopyQueue:   tt.fields.CopyQueue,
-				Context:     tt.fields.Context,
-				CancelFunc:  tt.fields.CancelFunc,
-				Wg:          tt.fields.Wg,
-				ProgressBar: tt.fields.ProgressBar,
+				Config:     tt.fields.Config,
+				Photos:     tt.fields.Photos,
+				Stats:      tt.fields.Stats,
+				Workers:    tt.fields.Workers,
+				CopyQueue:  tt.fields.CopyQueue,
+				Context:    tt.fields.Context,
+				CancelFunc: tt.fields.CancelFunc,
 			}
 			c.IncrementStats(tt.args.size)
 			if c.Stats.Size != tt.wantSize {
@@ -0,0 +1,170 @@
+package photo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+type EventType string
+
+const (
+	// EventStarted is sent once the source was searched, with the number of photos to copy
+	EventStarted EventType = "started"
+	// EventQueued is sent in watch mode when a new photo is found
+	EventQueued  EventType = "queued"
+	EventCopied  EventType = "copied"
+	EventSkipped EventType = "skipped"
+	EventFailed  EventType = "failed"
+)
+
+// Event reports the progress of a copy
+type Event struct {
+	Type        EventType
+	Source      string
+	Destination string
+	Size        int64
+	Err         error
+	// Worker is the id of the worker that processed the photo
+	Worker int
+	// Total is the number of photos to copy known so far
+	Total int
+	// TotalBytes is the size of the photos to copy, only set on EventStarted
+	TotalBytes int64
+	// Processed is the number of photos copied, skipped or failed so far
+	Processed int
+}
+
+// Options of Copy. Only Config is required.
+type Options struct {
+	Config *config.Config
+	// Logger defaults to the logrus standard logger
+	Logger logrus.FieldLogger
+	// FS defaults to OSFileSystem. Manifests and reports are always written
+	// to the local disk
+	FS FileSystem
+	// OnEvent is called for every event, never concurrently
+	OnEvent func(Event)
+	// Reporter overrides the report requested in Config.ReportPath
+	Reporter Reporter
+	// Devices overrides Config.DeviceReaders, share it between concurrent
+	// copies reading from the same devices
+	Devices *DeviceLimits
+}
+
+// Result sums up a copy
+type Result struct {
+	Total   int
+	Copied  int
+	Skipped int
+	Failed  int
+	// WalkErrors counts the source paths that could not be listed, their
+	// photos are not in Total
+	WalkErrors int
+	Bytes      int64
+	Duration   time.Duration
+}
+
+// Copy copies opts.Config.SourceDirectory to opts.Config.DestDirectory and
+// returns once every photo was processed, or ctx is cancelled in watch mode.
+// Photos that failed and source paths that could not be listed are returned
+// as a *utils.PartialError along with the result. Copy does not touch any global state.
+func Copy(ctx context.Context, opts Options) (*Result, error) {
+	start := time.Now()
+	cfg := opts.Config
+
+	copier := NewCopier(cfg, ctx)
+	defer copier.Stop()
+	copier.Log = opts.Logger
+	copier.FS = opts.FS
+	copier.OnEvent = opts.OnEvent
+	copier.Reporter = opts.Reporter
+	if opts.Devices != nil {
+		copier.Devices = opts.Devices
+	}
+	if copier.Reporter == nil && cfg.ReportPath != "" {
+		reporter, err := NewReporter(cfg.ReportPath, cfg.ReportFormat)
+		if err != nil {
+			return nil, err
+		}
+		copier.Reporter = reporter
+		defer func() {
+			if err := reporter.Close(); err != nil {
+				copier.logger().Errorf("unable to close report %v. err=%v", cfg.ReportPath, err.Error())
+			}
+		}()
+	}
+
+	if err := copier.validateSets(); err != nil {
+		return nil, err
+	}
+	if err := copier.Search(); err != nil {
+		return nil, err
+	}
+	if err := copier.Cluster(); err != nil {
+		return nil, err
+	}
+	copier.CreateDestDirs()
+	if err := copier.runPreflight(); err != nil {
+		return nil, err
+	}
+	if cfg.SimilarHash != "" {
+		if err := copier.FlagSimilar(); err != nil {
+			return nil, err
+		}
+	}
+	copier.logger().Debugf("Will have to copy %v pictures", len(copier.Photos))
+	var totalBytes int64
+	for _, p := range copier.Photos {
+		totalBytes += p.Size
+	}
+	copier.Emit(Event{Type: EventStarted, Total: len(copier.Photos), TotalBytes: totalBytes})
+
+	if err := copier.Start(); err != nil {
+		return nil, err
+	}
+	if cfg.Watch {
+		watcher, err := NewWatcher(copier)
+		if err != nil {
+			return nil, err
+		}
+		if err := watcher.Run(); err != nil {
+			return nil, err
+		}
+	} else {
+		copier.Wait()
+	}
+	// no event is sent once Copy returned
+	copier.Stop()
+	copier.Wg.Wait()
+	manifestErr := copier.WriteManifest()
+
+	copier.StatsMutex.Lock()
+	// files that failed during search are in Errors but not in Photos
+	result := &Result{
+		Total:      len(copier.Photos) + copier.watched + copier.Errors.Len() - copier.Stats.Failed,
+		Copied:     copier.Stats.Count,
+		Skipped:    copier.Stats.Skipped,
+		Failed:     copier.Errors.Len(),
+		WalkErrors: copier.WalkErrors.Len(),
+		Bytes:      copier.Stats.Size,
+		Duration:   time.Since(start),
+	}
+	copier.StatsMutex.Unlock()
+
+	if manifestErr != nil {
+		return result, fmt.Errorf("unable to write manifest. err=%w", manifestErr)
+	}
+	if !cfg.Watch && copier.Processed() < len(copier.Photos) {
+		return result, fmt.Errorf("copy interrupted after %d of %d photos", copier.Processed(), len(copier.Photos))
+	}
+	failed := append(copier.Errors.Failed(), copier.WalkErrors.Failed()...)
+	if len(failed) > 0 {
+		return result, &utils.PartialError{Total: result.Total, Failed: failed}
+	}
+	return result, nil
+}
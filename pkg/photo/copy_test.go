@@ -0,0 +1,128 @@
+package photo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// writeTestJPEG writes a small JPEG holding an EXIF DateTime tag
+func writeTestJPEG(t *testing.T, filePath, dateTime string) {
+	t.Helper()
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(tiff, binary.BigEndian, uint32(8))
+	binary.Write(tiff, binary.BigEndian, uint16(1))
+	binary.Write(tiff, binary.BigEndian, uint16(0x0132))
+	binary.Write(tiff, binary.BigEndian, uint16(2))
+	binary.Write(tiff, binary.BigEndian, uint32(len(dateTime)+1))
+	binary.Write(tiff, binary.BigEndian, uint32(8+2+12+4))
+	binary.Write(tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString(dateTime + "\x00")
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(img.Bytes()[2:])
+	if err := os.WriteFile(filePath, out.Bytes(), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	writeTestJPEG(t, path.Join(srcDir, "img001.jpg"), "2022:04:30 10:00:00")
+	writeTestJPEG(t, path.Join(srcDir, "img002.jpg"), "2022:03:29 10:00:00")
+
+	logger := logrus.New()
+	logger.SetOutput(&bytes.Buffer{})
+	var events []Event
+	cfg := &config.Config{
+		SourceDirectory: srcDir,
+		DestDirectory:   dstDir,
+		DestFileFormat:  "2006-01-02",
+		Workers:         2,
+		ReadWorkers:     2,
+	}
+	result, err := Copy(context.Background(), Options{
+		Config:  cfg,
+		Logger:  logger,
+		OnEvent: func(e Event) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Copied != 2 || result.Total != 2 || result.Failed != 0 {
+		t.Errorf("Copy() got result %+v", result)
+	}
+	for _, f := range []string{"2022-04-30/img001.jpg", "2022-03-29/img002.jpg"} {
+		if _, err := os.Stat(path.Join(dstDir, f)); err != nil {
+			t.Errorf("Copy(): file %s not found", f)
+		}
+	}
+	if len(events) != 3 || events[0].Type != EventStarted || events[2].Processed != 2 {
+		t.Errorf("Copy() got events %+v", events)
+	}
+
+	// a second run finds identical files
+	result, err = Copy(context.Background(), Options{Config: cfg, Logger: logger})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Skipped != 2 {
+		t.Errorf("Copy() second run got result %+v", result)
+	}
+}
+
+// unlistableFS cannot list the directories named unlisted
+type unlistableFS struct {
+	OSFileSystem
+}
+
+func (fs unlistableFS) Walk(root string, fn filepath.WalkFunc) error {
+	return fs.OSFileSystem.Walk(root, func(aPath string, f os.FileInfo, err error) error {
+		if err == nil && f.IsDir() && f.Name() == "unlisted" {
+			return fn(aPath, nil, errors.New("permission denied"))
+		}
+		return fn(aPath, f, err)
+	})
+}
+
+func TestCopy_walkErrors(t *testing.T) {
+	srcDir := t.TempDir()
+	writeTestJPEG(t, path.Join(srcDir, "img001.jpg"), "2022:04:30 10:00:00")
+	if err := os.Mkdir(path.Join(srcDir, "unlisted"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(&bytes.Buffer{})
+	cfg := &config.Config{SourceDirectory: srcDir, DestDirectory: t.TempDir(), DestFileFormat: "2006-01-02"}
+	result, err := Copy(context.Background(), Options{Config: cfg, Logger: logger, FS: unlistableFS{}})
+	var partial *utils.PartialError
+	if !errors.As(err, &partial) || len(partial.Failed) != 1 || partial.Failed[0].Path != path.Join(srcDir, "unlisted") {
+		t.Fatalf("Copy() error = %v, want the unlisted directory", err)
+	}
+	if result.Total != 1 || result.Copied != 1 || result.Failed != 0 || result.WalkErrors != 1 {
+		t.Errorf("Copy() got result %+v", result)
+	}
+}
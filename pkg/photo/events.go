@@ -0,0 +1,207 @@
+package photo
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EventPlaceholder is replaced by the event name in Config.DestFileFormat
+const EventPlaceholder = "{event}"
+
+// UndatedEvent names the album of the photos without a date
+const UndatedEvent = "undated"
+
+// Album is an event: a run of photos without long pauses nor long trips in
+// between, copied to its own directory
+type Album struct {
+	Name   string
+	Place  string
+	Photos []*Photo
+}
+
+func (e *Album) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.Name
+}
+
+// Place is a named location, events within Radius kilometers of it are
+// named after it
+type Place struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+	Radius    float64
+}
+
+// ReadPlaces reads a CSV file of name,latitude,longitude[,radius_km] lines,
+// the radius defaults to 10km. Lines starting with # are ignored.
+func ReadPlaces(placesPath string) ([]Place, error) {
+	f, err := os.Open(placesPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open places %v. err=%w", placesPath, err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	var places []Place
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read places %v. err=%w", placesPath, err)
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("invalid place %v in %v, want name,latitude,longitude[,radius_km]", record, placesPath)
+		}
+		place := Place{Name: record[0], Radius: 10}
+		values := []*float64{&place.Latitude, &place.Longitude, &place.Radius}
+		for i, field := range record[1:] {
+			if i >= len(values) {
+				break
+			}
+			if *values[i], err = strconv.ParseFloat(field, 64); err != nil {
+				return nil, fmt.Errorf("invalid place %v in %v. err=%w", record, placesPath, err)
+			}
+		}
+		places = append(places, place)
+	}
+	return places, nil
+}
+
+// distanceKm is the great-circle distance between two points
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// ClusterEvents splits the timeline of the photos into events, on pauses
+// longer than Config.EventGap or moves farther than Config.EventDistance
+// kilometers between geotagged photos. Events are named after their date
+// range and, when one is close enough, after a place of places.
+func (c *Copier) ClusterEvents(places []Place) []*Album {
+	c.StatsMutex.Lock()
+	photos := append([]*Photo(nil), c.Photos...)
+	c.StatsMutex.Unlock()
+	sort.SliceStable(photos, func(i, j int) bool {
+		return photos[i].DateTaken.Before(photos[j].DateTaken)
+	})
+
+	var events []*Album
+	var current *Album
+	var last, lastGeo *Photo
+	for _, p := range photos {
+		if p.DateTaken.IsZero() {
+			continue
+		}
+		split := current == nil || p.DateTaken.Sub(last.DateTaken) > c.Config.EventGap
+		if !split && p.HasGPS && lastGeo != nil && c.Config.EventDistance > 0 &&
+			distanceKm(lastGeo.Latitude, lastGeo.Longitude, p.Latitude, p.Longitude) > c.Config.EventDistance {
+			split = true
+		}
+		if split {
+			current = &Album{}
+			events = append(events, current)
+			lastGeo = nil
+		}
+		current.Photos = append(current.Photos, p)
+		p.Album = current
+		last = p
+		if p.HasGPS {
+			lastGeo = p
+		}
+	}
+	var undated *Album
+	for _, p := range photos {
+		if p.DateTaken.IsZero() {
+			if undated == nil {
+				undated = &Album{Name: UndatedEvent}
+				events = append(events, undated)
+			}
+			undated.Photos = append(undated.Photos, p)
+			p.Album = undated
+		}
+	}
+
+	for _, e := range events {
+		if e == undated {
+			continue
+		}
+		e.Place = nearestPlace(e, places)
+		e.Name = eventName(e)
+		c.logger().Debugf("event %v: %d photos", e.Name, len(e.Photos))
+	}
+	return events
+}
+
+// nearestPlace returns the place closest to the first geotagged photo of e
+func nearestPlace(e *Album, places []Place) string {
+	for _, p := range e.Photos {
+		if !p.HasGPS {
+			continue
+		}
+		best, bestDistance := "", math.MaxFloat64
+		for _, place := range places {
+			d := distanceKm(place.Latitude, place.Longitude, p.Latitude, p.Longitude)
+			if d <= place.Radius && d < bestDistance {
+				best, bestDistance = place.Name, d
+			}
+		}
+		return best
+	}
+	return ""
+}
+
+// eventName formats the date range of e: 2022-06-01, 2022-06-01_03,
+// 2022-06-28_07-02 or 2022-12-30_2023-01-02, followed by the place
+func eventName(e *Album) string {
+	start := e.Photos[0].DateTaken
+	end := e.Photos[len(e.Photos)-1].DateTaken
+	name := start.Format("2006-01-02")
+	switch {
+	case start.Year() != end.Year():
+		name += end.Format("_2006-01-02")
+	case start.Month() != end.Month():
+		name += end.Format("_01-02")
+	case start.Day() != end.Day():
+		name += end.Format("_02")
+	}
+	if e.Place != "" {
+		name += " " + strings.ReplaceAll(e.Place, "/", "-")
+	}
+	return name
+}
+
+// formatTargetDir formats Config.DestFileFormat for p, replacing
+// EventPlaceholder by the event name
+func (p *Photo) formatTargetDir() string {
+	parts := strings.Split(p.Copier.Config.DestFileFormat, EventPlaceholder)
+	for i := range parts {
+		parts[i] = p.DateTaken.Format(parts[i])
+	}
+	event := p.Album.String()
+	switch {
+	case event != "":
+	case p.DateTaken.IsZero():
+		event = UndatedEvent
+	default:
+		// photos found in watch mode are not clustered
+		event = eventName(&Album{Photos: []*Photo{p}})
+	}
+	return strings.Join(parts, event)
+}
@@ -0,0 +1,65 @@
+package photo
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/vfoucault/goPhoto/pkg/config"
+)
+
+func TestClusterEvents(t *testing.T) {
+	day := func(d, h int) time.Time { return time.Date(2022, 6, d, h, 0, 0, 0, time.UTC) }
+	geo := func(name string, at time.Time, lat, long float64) *Photo {
+		return &Photo{FileName: name, DateTaken: at, Latitude: lat, Longitude: long, HasGPS: true}
+	}
+	photos := []*Photo{
+		// a weekend in Paris, sleeping less than the event gap
+		geo("a.jpg", day(4, 20), 48.85, 2.35),
+		geo("b.jpg", day(5, 0), 48.86, 2.34),
+		{FileName: "c.jpg", DateTaken: day(5, 3)},
+		// a drive to Rouen, 110km away, right after
+		geo("d.jpg", day(5, 5), 49.44, 1.10),
+		// back home a week later
+		{FileName: "e.jpg", DateTaken: day(12, 10)},
+		{FileName: "f.jpg"},
+	}
+	cfg := &config.Config{DestFileFormat: "2006/{event}", EventGap: 4 * time.Hour, EventDistance: 50}
+	c := &Copier{Config: cfg, Photos: photos}
+	for _, p := range photos {
+		p.Copier = c
+	}
+	places := []Place{{Name: "Paris", Latitude: 48.8566, Longitude: 2.3522, Radius: 15}}
+	albums := c.ClusterEvents(places)
+	if len(albums) != 4 {
+		t.Fatalf("ClusterEvents() got %d albums, want 4", len(albums))
+	}
+	tests := []struct {
+		photo  *Photo
+		target string
+	}{
+		{photos[0], "2022/2022-06-04_05 Paris"},
+		{photos[2], "2022/2022-06-04_05 Paris"},
+		{photos[3], "2022/2022-06-05"},
+		{photos[4], "2022/2022-06-12"},
+		{photos[5], "0001/undated"},
+	}
+	for _, tt := range tests {
+		if got := tt.photo.GetTargetPath(); got != tt.target {
+			t.Errorf("GetTargetPath() of %v = %v, want %v", tt.photo.FileName, got, tt.target)
+		}
+	}
+}
+
+func TestReadPlaces(t *testing.T) {
+	placesPath := path.Join(t.TempDir(), "places.csv")
+	os.WriteFile(placesPath, []byte("# name,lat,long,radius\nParis, 48.8566, 2.3522\nHome,45.1,5.2,0.5\n"), 0640)
+	places, err := ReadPlaces(placesPath)
+	if err != nil {
+		t.Fatalf("ReadPlaces() error = %v", err)
+	}
+	if len(places) != 2 || places[0].Radius != 10 || places[1].Radius != 0.5 || places[1].Longitude != 5.2 {
+		t.Errorf("ReadPlaces() got %+v", places)
+	}
+}
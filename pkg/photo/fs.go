@@ -0,0 +1,173 @@
+package photo
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// File is a source photo opened for reading
+type File interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// WriteFile is a photo being written at destination
+type WriteFile interface {
+	io.Writer
+	io.Closer
+	Sync() error
+}
+
+// FileSystem holds every file operation of the copier, so it can run on
+// something else than the local disk. OSFileSystem is used by default.
+type FileSystem interface {
+	Open(name string) (File, error)
+	Create(name string) (WriteFile, error)
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.FileInfo, error)
+	Walk(root string, fn filepath.WalkFunc) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Remove(name string) error
+}
+
+type OSFileSystem struct{}
+
+func (OSFileSystem) Open(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFileSystem) Create(name string) (WriteFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFileSystem) ReadDir(name string) ([]fs.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+func (OSFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, fn)
+}
+
+func (OSFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (OSFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFileSystem) DiskSpace(name string) (device uint64, free int64, err error) {
+	return diskSpace(name)
+}
+
+func (OSFileSystem) Watch() (FileWatcher, error) {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &osWatcher{notify: notify, events: make(chan FileEvent), done: make(chan struct{})}
+	go w.forward()
+	return w, nil
+}
+
+// osWatcher turns the fsnotify events into FileEvents
+type osWatcher struct {
+	notify *fsnotify.Watcher
+	events chan FileEvent
+	done   chan struct{}
+}
+
+func (w *osWatcher) forward() {
+	defer close(w.events)
+	ops := map[fsnotify.Op]FileOp{fsnotify.Create: FileCreate, fsnotify.Write: FileWrite, fsnotify.Remove: FileRemove, fsnotify.Rename: FileRename}
+	for e := range w.notify.Events {
+		event := FileEvent{Name: e.Name}
+		for op, fileOp := range ops {
+			if e.Op&op != 0 {
+				event.Op |= fileOp
+			}
+		}
+		select {
+		case w.events <- event:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *osWatcher) Add(dir string) error {
+	return w.notify.Add(dir)
+}
+
+func (w *osWatcher) Events() <-chan FileEvent {
+	return w.events
+}
+
+func (w *osWatcher) Errors() <-chan error {
+	return w.notify.Errors
+}
+
+func (w *osWatcher) Close() error {
+	close(w.done)
+	return w.notify.Close()
+}
+
+// DiskSpaceFileSystem is implemented by file systems able to report their
+// free space. The preflight free space check is skipped for the others.
+type DiskSpaceFileSystem interface {
+	DiskSpace(name string) (device uint64, free int64, err error)
+}
+
+// FileOp is a change notified by a FileWatcher
+type FileOp uint32
+
+const (
+	FileCreate FileOp = 1 << iota
+	FileWrite
+	FileRemove
+	FileRename
+)
+
+// FileEvent is a change of the file Name
+type FileEvent struct {
+	Name string
+	Op   FileOp
+}
+
+// FileWatcher notifies the changes in the directories added to it
+type FileWatcher interface {
+	Add(dir string) error
+	Events() <-chan FileEvent
+	Errors() <-chan error
+	Close() error
+}
+
+// WatchFileSystem is implemented by file systems able to notify changes, as
+// needed by watch mode
+type WatchFileSystem interface {
+	Watch() (FileWatcher, error)
+}
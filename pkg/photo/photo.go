@@ -2,34 +2,64 @@ package photo
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"os"
 	"path"
 	"time"
 
 	"github.com/rwcarlsen/goexif/exif"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/manifest"
 )
 
 type Photo struct {
 	Path      string
 	FileName  string
 	DateTaken time.Time
-	Copier    *Copier
-	Atime     time.Time
-	Ctime     time.Time
-	Mtime     time.Time
-	Md5       []byte
-	File      *os.File
+	// DateSource tells where DateTaken comes from
+	DateSource string
+	Size       int64
+	Copier     *Copier
+	Atime      time.Time
+	Ctime      time.Time
+	Mtime      time.Time
+	Md5        []byte
+	Sha256     []byte
+	File       File
+	// Device is the source device, used to bound the concurrent reads
+	Device uint64
+	// SimilarTo is a photo that looks the same, see Copier.FlagSimilar
+	SimilarTo string
+	// Camera, ExposureBias and AutoBracket come from the EXIF, Sequence from
+	// the file name. They are used to group bursts and brackets into Set.
+	Camera       string
+	ExposureBias float64
+	AutoBracket  bool
+	Sequence     int
+	Set          *Set
+	// Latitude and Longitude are set when HasGPS, Album by ClusterEvents
+	Latitude  float64
+	Longitude float64
+	HasGPS    bool
+	Album     *Album
 }
 
 func (p *Photo) GetTargetPath() string {
-	return path.Join(p.Copier.Config.DestDirectory, p.DateTaken.Format(p.Copier.Config.DestFileFormat))
+	dir := p.formatTargetDir()
+	if p.Set != nil && p.Copier.Config.Sets == config.SetsSubdir {
+		dir = path.Join(dir, p.Set.Name)
+	}
+	if p.Copier.Config.BagIt {
+		return path.Join(p.Copier.Config.DestDirectory, manifest.BagPayloadDirectory, dir)
+	}
+	return path.Join(p.Copier.Config.DestDirectory, dir)
 }
 
 func (p *Photo) Open() error {
 	if p.File == nil {
-		f, err := os.Open(path.Join(p.Path, p.FileName))
+		f, err := p.Copier.fileSystem().Open(path.Join(p.Path, p.FileName))
 		if err != nil {
 			return fmt.Errorf("unable to open file %v. err=%v", p.Path, err.Error())
 		}
@@ -45,10 +75,12 @@ func (p *Photo) GetHash() error {
 		return err
 	}
 	h := md5.New()
-	if _, err := io.Copy(h, p.File); err != nil {
+	h256 := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h, h256), p.Copier.reader(p.File)); err != nil {
 		return fmt.Errorf("unable to compute md5 for file %s. err=%v", path.Join(p.Path, p.FileName), err.Error())
 	}
 	p.Md5 = h.Sum(nil)
+	p.Sha256 = h256.Sum(nil)
 	return nil
 }
 
@@ -62,6 +94,33 @@ func (p *Photo) GetDateTaken() error {
 	}
 
 	p.DateTaken, _ = exifData.DateTime()
+	p.DateSource = "exif"
+	p.readShootingInfo(exifData)
 
 	return nil
 }
+
+func (p *Photo) Event(t EventType, err error) Event {
+	return Event{
+		Type:        t,
+		Source:      path.Join(p.Path, p.FileName),
+		Destination: path.Join(p.GetTargetPath(), p.FileName),
+		Size:        p.Size,
+		Err:         err,
+	}
+}
+
+func (p *Photo) ReportEntry(action Action, reason string, start time.Time) *ReportEntry {
+	return &ReportEntry{
+		Source:      path.Join(p.Path, p.FileName),
+		Destination: path.Join(p.GetTargetPath(), p.FileName),
+		Action:      action,
+		Reason:      reason,
+		Size:        p.Size,
+		Hash:        hex.EncodeToString(p.Sha256),
+		DateSource:  p.DateSource,
+		DurationMs:  durationMs(start),
+		SimilarTo:   p.SimilarTo,
+		Set:         p.Set.String(),
+	}
+}
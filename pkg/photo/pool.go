@@ -0,0 +1,122 @@
+package photo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// TuneInterval is the period over which the auto-tuner measures the
+// throughput before changing the number of writers
+var TuneInterval = 5 * time.Second
+
+// DeviceLimits bounds the number of concurrent reads per source device, so
+// that several readers don't thrash a single card or disk. It can be shared
+// between copies running at the same time. A nil DeviceLimits is unlimited.
+type DeviceLimits struct {
+	max   int
+	mutex sync.Mutex
+	slots map[uint64]chan struct{}
+}
+
+// NewDeviceLimits returns limits of max readers per device, or nil when max is 0
+func NewDeviceLimits(max int) *DeviceLimits {
+	if max <= 0 {
+		return nil
+	}
+	return &DeviceLimits{max: max, slots: make(map[uint64]chan struct{})}
+}
+
+// Acquire waits for a free read slot on device
+func (d *DeviceLimits) Acquire(ctx context.Context, device uint64) error {
+	if d == nil {
+		return nil
+	}
+	d.mutex.Lock()
+	slots, ok := d.slots[device]
+	if !ok {
+		slots = make(chan struct{}, d.max)
+		d.slots[device] = slots
+	}
+	d.mutex.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken with Acquire
+func (d *DeviceLimits) Release(device uint64) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	slots := d.slots[device]
+	d.mutex.Unlock()
+	<-slots
+}
+
+// startWriter launches a new copy worker
+func (c *Copier) startWriter() {
+	c.StatsMutex.Lock()
+	worker := NewWorker(len(c.Workers), c)
+	c.Workers = append(c.Workers, worker)
+	c.StatsMutex.Unlock()
+	c.Wg.Add(1)
+	go worker.Start()
+}
+
+// stopWriter asks one copy worker to exit once its current photo is done
+func (c *Copier) stopWriter() {
+	select {
+	case c.shrink <- struct{}{}:
+	case <-c.Context.Done():
+	}
+}
+
+// autoTune adds writers while it improves the byte rate, and removes them
+// when the byte rate drops, between 1 and Config.Workers writers
+func (c *Copier) autoTune(writers int) {
+	ticker := time.NewTicker(TuneInterval)
+	defer ticker.Stop()
+	var lastBytes int64
+	var lastRate float64
+	step := 1
+	for {
+		select {
+		case <-c.Context.Done():
+			return
+		case <-ticker.C:
+		}
+		c.StatsMutex.Lock()
+		bytes := c.Stats.Size
+		c.StatsMutex.Unlock()
+		rate := float64(bytes-lastBytes) / TuneInterval.Seconds()
+		lastBytes = bytes
+		if len(c.CopyQueue) == 0 {
+			// the writers are starved, the rate says nothing about them
+			lastRate = rate
+			continue
+		}
+		if rate < lastRate*0.95 {
+			step = -step
+		}
+		lastRate = rate
+		switch {
+		case step > 0 && writers < c.Config.Workers:
+			c.startWriter()
+			writers += 1
+		case step < 0 && writers > 1:
+			c.stopWriter()
+			writers -= 1
+		default:
+			// at a bound, try the other way next time
+			step = -step
+		}
+		c.logger().Debugf("auto-tune: %d writers after %v/s", writers, bytefmt.ByteSize(uint64(rate)))
+	}
+}
@@ -0,0 +1,51 @@
+package photo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeviceLimits(t *testing.T) {
+	limits := NewDeviceLimits(2)
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limits.Acquire(context.Background(), 1); err != nil {
+				t.Errorf("Acquire() error = %v", err)
+				return
+			}
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			limits.Release(1)
+		}()
+	}
+	// another device is not blocked
+	if err := limits.Acquire(context.Background(), 2); err != nil {
+		t.Errorf("Acquire() on another device error = %v", err)
+	}
+	wg.Wait()
+	if maxRunning != 2 {
+		t.Errorf("DeviceLimits let %d readers run, want 2", maxRunning)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limits.Acquire(context.Background(), 3)
+	limits.Acquire(context.Background(), 3)
+	if err := limits.Acquire(ctx, 3); err == nil {
+		t.Errorf("Acquire() on a full device with a cancelled context got no error")
+	}
+}
@@ -0,0 +1,180 @@
+package photo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/bytefmt"
+	"github.com/vfoucault/goPhoto/pkg/config"
+)
+
+// VolumePlan is what remains to be copied to one destination filesystem
+type VolumePlan struct {
+	Device uint64
+	// Directory is one of the target directories on this filesystem
+	Directory string
+	Files     int
+	Bytes     int64
+	// Free is -1 when the filesystem cannot report its free space
+	Free int64
+}
+
+func (v *VolumePlan) Fits() bool {
+	return v.Free < 0 || v.Bytes <= v.Free
+}
+
+// Plan is the result of the preflight checks
+type Plan struct {
+	Volumes []*VolumePlan
+	// Unwritable holds the target directories that cannot be written to, with the reason
+	Unwritable map[string]error
+}
+
+// Err returns an error describing every failed check, or nil
+func (p *Plan) Err() error {
+	var problems []string
+	for _, v := range p.Volumes {
+		if !v.Fits() {
+			problems = append(problems, fmt.Sprintf("%s needed on the filesystem of %v, only %s free",
+				bytefmt.ByteSize(uint64(v.Bytes)), v.Directory, bytefmt.ByteSize(uint64(v.Free))))
+		}
+	}
+	var dirs []string
+	for dir := range p.Unwritable {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		problems = append(problems, fmt.Sprintf("%v is not writable: %v", dir, p.Unwritable[dir]))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("preflight checks failed: %s", strings.Join(problems, "; "))
+}
+
+// Preflight checks that every target directory is writable and that each
+// destination filesystem has room for the photos still to copy. Photos
+// whose target already exists with the same size are expected to be skipped.
+// It must run after CreateDestDirs.
+func (c *Copier) Preflight() *Plan {
+	plan := &Plan{Unwritable: make(map[string]error)}
+	c.StatsMutex.Lock()
+	photos := append([]*Photo(nil), c.Photos...)
+	c.StatsMutex.Unlock()
+
+	// bytes still to write, by target directory
+	pending := make(map[string]*VolumePlan)
+	for _, p := range photos {
+		dir := p.GetTargetPath()
+		v, ok := pending[dir]
+		if !ok {
+			v = &VolumePlan{Directory: dir, Free: -1}
+			pending[dir] = v
+		}
+		size := p.Size
+		if info, err := c.fileSystem().Stat(path.Join(dir, p.FileName)); err == nil {
+			if info.Size() == p.Size {
+				continue
+			}
+			// the existing file is overwritten
+			size -= info.Size()
+		}
+		v.Files += 1
+		if size > 0 {
+			v.Bytes += size
+		}
+	}
+
+	dirs := make([]string, 0, len(pending))
+	for dir := range pending {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	spacer, hasSpace := c.fileSystem().(DiskSpaceFileSystem)
+	volumes := make(map[uint64]*VolumePlan)
+	for _, dir := range dirs {
+		if err := c.checkWritable(dir); err != nil {
+			plan.Unwritable[dir] = err
+			continue
+		}
+		v := pending[dir]
+		if !hasSpace {
+			plan.Volumes = append(plan.Volumes, v)
+			continue
+		}
+		device, free, err := spacer.DiskSpace(dir)
+		if err != nil {
+			c.logger().Debugf("unable to get free space of %v. err=%v", dir, err.Error())
+			plan.Volumes = append(plan.Volumes, v)
+			continue
+		}
+		vol, ok := volumes[device]
+		if !ok {
+			vol = &VolumePlan{Device: device, Directory: dir, Free: free}
+			volumes[device] = vol
+			plan.Volumes = append(plan.Volumes, vol)
+		}
+		vol.Files += v.Files
+		vol.Bytes += v.Bytes
+	}
+	if !hasSpace {
+		// a single unknown volume
+		total := &VolumePlan{Directory: c.Config.DestDirectory, Free: -1}
+		for _, v := range plan.Volumes {
+			total.Files += v.Files
+			total.Bytes += v.Bytes
+		}
+		plan.Volumes = []*VolumePlan{total}
+	}
+	return plan
+}
+
+// checkWritable creates and removes a file in dir
+func (c *Copier) checkWritable(dir string) error {
+	probe := path.Join(dir, fmt.Sprintf(".gophoto-preflight-%d", os.Getpid()))
+	f, err := c.fileSystem().Create(probe)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return c.fileSystem().Remove(probe)
+}
+
+// LogPlan logs the plan, one line per destination filesystem
+func (c *Copier) LogPlan(plan *Plan) {
+	for _, v := range plan.Volumes {
+		free := "free space unknown"
+		if v.Free >= 0 {
+			free = fmt.Sprintf("%s free", bytefmt.ByteSize(uint64(v.Free)))
+		}
+		c.logger().Infof("Preflight: %d photos, %s to copy to the filesystem of %v (%s)",
+			v.Files, bytefmt.ByteSize(uint64(v.Bytes)), v.Directory, free)
+	}
+}
+
+// runPreflight applies Config.Preflight, the returned error aborts the copy
+func (c *Copier) runPreflight() error {
+	mode := c.Config.Preflight
+	switch mode {
+	case config.PreflightOff:
+		return nil
+	case "", config.PreflightAbort, config.PreflightWarn:
+	default:
+		return fmt.Errorf("unknown preflight mode %v. only %s, %s or %s", mode, config.PreflightAbort, config.PreflightWarn, config.PreflightOff)
+	}
+	plan := c.Preflight()
+	c.LogPlan(plan)
+	err := plan.Err()
+	if err == nil {
+		return nil
+	}
+	if mode == config.PreflightWarn {
+		c.logger().Warnf("%v", err.Error())
+		return nil
+	}
+	return err
+}
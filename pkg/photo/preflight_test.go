@@ -0,0 +1,65 @@
+package photo
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vfoucault/goPhoto/pkg/config"
+)
+
+// smallFS reports little free space and refuses writes in one directory
+type smallFS struct {
+	OSFileSystem
+	free     int64
+	readOnly string
+}
+
+func (s smallFS) Create(name string) (WriteFile, error) {
+	if path.Dir(name) == s.readOnly {
+		return nil, errors.New("read-only")
+	}
+	return s.OSFileSystem.Create(name)
+}
+
+func (s smallFS) DiskSpace(name string) (uint64, int64, error) {
+	return 1, s.free, nil
+}
+
+func TestPreflight(t *testing.T) {
+	dst := t.TempDir()
+	cfg := &config.Config{DestDirectory: dst, DestFileFormat: "2006"}
+	tests := []struct {
+		name    string
+		fs      smallFS
+		wantErr string
+	}{
+		{"fits", smallFS{free: 1000}, ""},
+		{"full", smallFS{free: 100}, "needed on the filesystem"},
+		{"read-only", smallFS{free: 1000, readOnly: path.Join(dst, "2021")}, "2021 is not writable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Copier{Config: cfg, FS: tt.fs}
+			for i, year := range []int{2021, 2022, 2022} {
+				c.Photos = append(c.Photos, &Photo{
+					FileName:  string(rune('a'+i)) + ".jpg",
+					Size:      150,
+					DateTaken: time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+					Copier:    c,
+				})
+			}
+			c.CreateDestDirs()
+			plan := c.Preflight()
+			err := plan.Err()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Preflight() error = %v, want %q", err, tt.wantErr)
+			}
+			if tt.wantErr == "" && (len(plan.Volumes) != 1 || plan.Volumes[0].Files != 3 || plan.Volumes[0].Bytes != 450) {
+				t.Errorf("Preflight() got volumes %+v", plan.Volumes)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package photo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionCopied   Action = "copied"
+	ActionSkipped  Action = "skipped"
+	ActionFailed   Action = "failed"
+	ActionFiltered Action = "filtered"
+)
+
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+)
+
+// ReportEntry describes what happened to a single source file
+type ReportEntry struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination,omitempty"`
+	Action      Action  `json:"action"`
+	Reason      string  `json:"reason,omitempty"`
+	Size        int64   `json:"size"`
+	Hash        string  `json:"hash,omitempty"`
+	DateSource  string  `json:"date_source,omitempty"`
+	DurationMs  float64 `json:"duration_ms"`
+	// SimilarTo is a photo that looks the same, see Config.SimilarHash
+	SimilarTo string `json:"similar_to,omitempty"`
+	// Set is the burst or bracket of the photo
+	Set string `json:"set,omitempty"`
+}
+
+var reportCSVHeader = []string{"source", "destination", "action", "reason", "size", "hash", "date_source", "duration_ms", "similar_to", "set"}
+
+type Reporter interface {
+	Write(e *ReportEntry) error
+	Close() error
+}
+
+// NewReporter creates reportPath and writes entries as JSON lines or CSV.
+// With an empty format, .csv files get CSV and anything else JSON lines.
+func NewReporter(reportPath, format string) (Reporter, error) {
+	if format == "" {
+		format = ReportFormatJSON
+		if strings.EqualFold(filepath.Ext(reportPath), ".csv") {
+			format = ReportFormatCSV
+		}
+	}
+	if format != ReportFormatJSON && format != ReportFormatCSV {
+		return nil, fmt.Errorf("unknown report format %s. only %s and %s", format, ReportFormatJSON, ReportFormatCSV)
+	}
+	f, err := os.Create(reportPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create report %v. err=%v", reportPath, err.Error())
+	}
+	if format == ReportFormatCSV {
+		w := csv.NewWriter(f)
+		if err := w.Write(reportCSVHeader); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &csvReporter{file: f, writer: w}, nil
+	}
+	return &jsonReporter{file: f, encoder: json.NewEncoder(f)}, nil
+}
+
+type jsonReporter struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func (r *jsonReporter) Write(e *ReportEntry) error {
+	return r.encoder.Encode(e)
+}
+
+func (r *jsonReporter) Close() error {
+	return r.file.Close()
+}
+
+type csvReporter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func (r *csvReporter) Write(e *ReportEntry) error {
+	return r.writer.Write([]string{
+		e.Source,
+		e.Destination,
+		string(e.Action),
+		e.Reason,
+		strconv.FormatInt(e.Size, 10),
+		e.Hash,
+		e.DateSource,
+		strconv.FormatFloat(e.DurationMs, 'f', 3, 64),
+		e.SimilarTo,
+		e.Set,
+	})
+}
+
+func (r *csvReporter) Close() error {
+	r.writer.Flush()
+	if err := r.writer.Error(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+func durationMs(start time.Time) float64 {
+	return float64(time.Since(start)) / float64(time.Millisecond)
+}
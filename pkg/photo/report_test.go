@@ -0,0 +1,95 @@
+package photo
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestReporter(t *testing.T) {
+	entries := []*ReportEntry{
+		{Source: "/src/img001.jpg", Destination: "/dst/2022/img001.jpg", Action: ActionCopied, Size: 1024, Hash: "abc", DateSource: "exif", DurationMs: 1.5},
+		{Source: "/src/img002.jpg", Destination: "/dst/2022/img002.jpg", Action: ActionSkipped, Reason: "identical file exists at destination", Size: 2048},
+		{Source: "/src/img003.jpg", Action: ActionFailed, Reason: "unable to open, \"img003.jpg\"", Set: "burst-1"},
+	}
+	readJSON := func(t *testing.T, f *os.File) []ReportEntry {
+		var got []ReportEntry
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e ReportEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("line %q is not JSON. err=%v", scanner.Text(), err)
+			}
+			got = append(got, e)
+		}
+		return got
+	}
+	readCSV := func(t *testing.T, f *os.File) []ReportEntry {
+		records, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) == 0 || !reflect.DeepEqual(records[0], reportCSVHeader) {
+			t.Fatalf("got header %v, want %v", records, reportCSVHeader)
+		}
+		var got []ReportEntry
+		for _, r := range records[1:] {
+			size, _ := strconv.ParseInt(r[4], 10, 64)
+			duration, _ := strconv.ParseFloat(r[7], 64)
+			got = append(got, ReportEntry{Source: r[0], Destination: r[1], Action: Action(r[2]), Reason: r[3], Size: size,
+				Hash: r[5], DateSource: r[6], DurationMs: duration, SimilarTo: r[8], Set: r[9]})
+		}
+		return got
+	}
+	tests := []struct {
+		name   string
+		file   string
+		format string
+		read   func(t *testing.T, f *os.File) []ReportEntry
+	}{
+		{"json lines", "report.jsonl", ReportFormatJSON, readJSON},
+		{"csv", "report.csv", ReportFormatCSV, readCSV},
+		{"csv from the extension", "report.CSV", "", readCSV},
+		{"json lines by default", "report.log", "", readJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reportPath := path.Join(t.TempDir(), tt.file)
+			reporter, err := NewReporter(reportPath, tt.format)
+			if err != nil {
+				t.Fatalf("NewReporter() error = %v", err)
+			}
+			for _, e := range entries {
+				if err := reporter.Write(e); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := reporter.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			f, err := os.Open(reportPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got := tt.read(t, f)
+			if len(got) != len(entries) {
+				t.Fatalf("got %d rows, want %d", len(got), len(entries))
+			}
+			for i, e := range entries {
+				if !reflect.DeepEqual(got[i], *e) {
+					t.Errorf("row %d got %+v, want %+v", i, got[i], *e)
+				}
+			}
+		})
+	}
+
+	if _, err := NewReporter(path.Join(t.TempDir(), "report.xml"), "xml"); err == nil {
+		t.Errorf("NewReporter() accepted the xml format")
+	}
+}
@@ -0,0 +1,205 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/vfoucault/goPhoto/pkg/config"
+)
+
+// Kinds of sets
+const (
+	SetBurst   = "burst"
+	SetBracket = "bracket"
+)
+
+// exposureModeAutoBracket is the EXIF ExposureMode of bracketed shots
+const exposureModeAutoBracket = 2
+
+// Set is a burst or an exposure bracket, shot in a row by one camera
+type Set struct {
+	Kind   string
+	Name   string
+	Photos []*Photo
+}
+
+func (s *Set) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.Name
+}
+
+var sequenceRegexp = regexp.MustCompile(`(\d+)$`)
+
+// readShootingInfo reads what GroupSets needs from the EXIF and file name
+func (p *Photo) readShootingInfo(x *exif.Exif) {
+	var camera []string
+	for _, field := range []exif.FieldName{exif.Make, exif.Model} {
+		if tag, err := x.Get(field); err == nil {
+			if s, err := tag.StringVal(); err == nil {
+				camera = append(camera, strings.TrimSpace(s))
+			}
+		}
+	}
+	p.Camera = strings.Join(camera, " ")
+	if tag, err := x.Get(exif.SubSecTimeOriginal); err == nil && !p.DateTaken.IsZero() {
+		if s, err := tag.StringVal(); err == nil {
+			if digits := strings.TrimSpace(s); digits != "" {
+				if fraction, err := strconv.ParseFloat("0."+digits, 64); err == nil {
+					p.DateTaken = p.DateTaken.Add(time.Duration(fraction * float64(time.Second)))
+				}
+			}
+		}
+	}
+	if tag, err := x.Get(exif.ExposureBiasValue); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+			p.ExposureBias = float64(num) / float64(den)
+		}
+	}
+	if tag, err := x.Get(exif.ExposureMode); err == nil {
+		if mode, err := tag.Int(0); err == nil {
+			p.AutoBracket = mode == exposureModeAutoBracket
+		}
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		p.Latitude, p.Longitude, p.HasGPS = lat, long, true
+	}
+	p.Sequence = -1
+	name := strings.TrimSuffix(p.FileName, path.Ext(p.FileName))
+	if m := sequenceRegexp.FindString(name); m != "" {
+		p.Sequence, _ = strconv.Atoi(m)
+	}
+}
+
+// validateSets checks Config.Sets
+func (c *Copier) validateSets() error {
+	switch c.Config.Sets {
+	case "", config.SetsSubdir, config.SetsSidecar:
+		return nil
+	}
+	return fmt.Errorf("unknown sets mode %v. only %s or %s", c.Config.Sets, config.SetsSubdir, config.SetsSidecar)
+}
+
+// GroupSets groups the photos shot in a row by the same camera, at most
+// Config.SetGap apart and with consecutive file numbers when known, into
+// bursts or brackets. Sets have at least two photos, their kind is bracket
+// when the exposure bias varies or the camera reports auto bracketing.
+func (c *Copier) GroupSets() []*Set {
+	c.StatsMutex.Lock()
+	photos := append([]*Photo(nil), c.Photos...)
+	c.StatsMutex.Unlock()
+	sort.SliceStable(photos, func(i, j int) bool {
+		if photos[i].Camera != photos[j].Camera {
+			return photos[i].Camera < photos[j].Camera
+		}
+		if !photos[i].DateTaken.Equal(photos[j].DateTaken) {
+			return photos[i].DateTaken.Before(photos[j].DateTaken)
+		}
+		return photos[i].Sequence < photos[j].Sequence
+	})
+
+	var sets []*Set
+	var current []*Photo
+	flush := func() {
+		if len(current) > 1 {
+			sets = append(sets, newSet(current))
+		}
+		current = nil
+	}
+	for _, p := range photos {
+		if len(current) > 0 && !c.sameSet(current[len(current)-1], p) {
+			flush()
+		}
+		current = append(current, p)
+	}
+	flush()
+	for _, s := range sets {
+		c.logger().Debugf("%v: %d photos", s.Name, len(s.Photos))
+	}
+	return sets
+}
+
+func (c *Copier) sameSet(prev, p *Photo) bool {
+	if p.Camera != prev.Camera || p.DateTaken.IsZero() || prev.DateTaken.IsZero() {
+		return false
+	}
+	if p.DateTaken.Sub(prev.DateTaken) > c.Config.SetGap {
+		return false
+	}
+	if prev.Sequence >= 0 && p.Sequence >= 0 && p.Sequence != prev.Sequence+1 {
+		// cameras wrap from 9999 to 0001
+		return prev.Sequence == 9999 && p.Sequence <= 1
+	}
+	return true
+}
+
+func newSet(photos []*Photo) *Set {
+	s := &Set{Kind: SetBurst, Photos: photos}
+	for _, p := range photos {
+		if p.AutoBracket || p.ExposureBias != photos[0].ExposureBias {
+			s.Kind = SetBracket
+			break
+		}
+	}
+	first := photos[0].FileName
+	s.Name = fmt.Sprintf("%s_%s", s.Kind, strings.TrimSuffix(first, path.Ext(first)))
+	for _, p := range photos {
+		p.Set = s
+	}
+	return s
+}
+
+// writeSidecar writes an XMP sidecar next to the copied photo, tagging it
+// with its set so that reviewers can filter on it
+func (c *Copier) writeSidecar(p *Photo) error {
+	if p.Set == nil || c.Config.Sets != config.SetsSidecar {
+		return nil
+	}
+	sidecar := path.Join(p.GetTargetPath(), strings.TrimSuffix(p.FileName, path.Ext(p.FileName))+".xmp")
+	f, err := c.fileSystem().Create(sidecar)
+	if err != nil {
+		return fmt.Errorf("unable to create sidecar %v. err=%w", sidecar, err)
+	}
+	var name bytes.Buffer
+	xml.EscapeText(&name, []byte(p.Set.Name))
+	_, err = fmt.Fprintf(f, xmpSidecar, p.Set.Kind, name.String())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write sidecar %v. err=%w", sidecar, err)
+	}
+	return nil
+}
+
+const xmpSidecar = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:lr="http://ns.adobe.com/lightroom/1.0/">
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>%[1]s</rdf:li>
+     <rdf:li>%[2]s</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <lr:hierarchicalSubject>
+    <rdf:Bag>
+     <rdf:li>%[1]s|%[2]s</rdf:li>
+    </rdf:Bag>
+   </lr:hierarchicalSubject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
@@ -0,0 +1,49 @@
+package photo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vfoucault/goPhoto/pkg/config"
+)
+
+func TestGroupSets(t *testing.T) {
+	base := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	shot := func(name, camera string, seq int, offset time.Duration, bias float64) *Photo {
+		return &Photo{FileName: name, Camera: camera, Sequence: seq, DateTaken: base.Add(offset), ExposureBias: bias}
+	}
+	photos := []*Photo{
+		// a 20 fps burst
+		shot("A_0001.jpg", "Canon R6", 1, 0, 0),
+		shot("A_0002.jpg", "Canon R6", 2, 50*time.Millisecond, 0),
+		shot("A_0003.jpg", "Canon R6", 3, 100*time.Millisecond, 0),
+		// a second body shooting at the same time
+		shot("B_0101.jpg", "Nikon Z6", 101, 20*time.Millisecond, 0),
+		// an HDR bracket a minute later
+		shot("A_0004.jpg", "Canon R6", 4, time.Minute, -2),
+		shot("A_0005.jpg", "Canon R6", 5, time.Minute+200*time.Millisecond, 0),
+		shot("A_0006.jpg", "Canon R6", 6, time.Minute+400*time.Millisecond, 2),
+		// same second but a file number gap
+		shot("A_0010.jpg", "Canon R6", 10, time.Minute+600*time.Millisecond, 2),
+	}
+	c := &Copier{Config: &config.Config{SetGap: time.Second, Sets: config.SetsSubdir}, Photos: photos}
+	sets := c.GroupSets()
+	if len(sets) != 2 {
+		t.Fatalf("GroupSets() got %d sets, want 2", len(sets))
+	}
+	tests := []struct {
+		kind, name string
+		count      int
+	}{
+		{SetBurst, "burst_A_0001", 3},
+		{SetBracket, "bracket_A_0004", 3},
+	}
+	for i, tt := range tests {
+		if sets[i].Kind != tt.kind || sets[i].Name != tt.name || len(sets[i].Photos) != tt.count {
+			t.Errorf("GroupSets() set %d = %v %v with %d photos, want %v %v with %d", i, sets[i].Kind, sets[i].Name, len(sets[i].Photos), tt.kind, tt.name, tt.count)
+		}
+	}
+	if photos[3].Set != nil || photos[7].Set != nil {
+		t.Errorf("GroupSets() grouped a photo of another camera or sequence")
+	}
+}
@@ -0,0 +1,125 @@
+package photo
+
+import (
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/vfoucault/goPhoto/pkg/phash"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// FlagSimilar sets SimilarTo on the photos looking like a photo already in
+// their target directory, or like another photo of the import, using the
+// Config.SimilarHash perceptual hash. Flagged photos are still copied and
+// reported for review. Photos found in watch mode are not flagged.
+func (c *Copier) FlagSimilar() error {
+	algorithm := c.Config.SimilarHash
+	if err := phash.Validate(algorithm); err != nil {
+		return err
+	}
+	c.StatsMutex.Lock()
+	photos := append([]*Photo(nil), c.Photos...)
+	c.StatsMutex.Unlock()
+
+	dirs := make(map[string]bool)
+	for _, p := range photos {
+		dirs[p.GetTargetPath()] = true
+	}
+	var existing []string
+	for dir := range dirs {
+		files, err := c.fileSystem().ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if utils.IsImage(f) {
+				existing = append(existing, path.Join(dir, f.Name()))
+			}
+		}
+	}
+	sort.Strings(existing)
+
+	tree := &phash.BKTree{}
+	hashes, ok := c.perceptualHashes(len(existing), func(i int) (phash.Hash, error) {
+		f, err := c.fileSystem().Open(existing[i])
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		return phash.Read(c.reader(f), algorithm)
+	})
+	for i, filePath := range existing {
+		if ok[i] {
+			tree.Add(hashes[i], filePath)
+		}
+	}
+
+	hashes, ok = c.perceptualHashes(len(photos), func(i int) (phash.Hash, error) {
+		if err := photos[i].Open(); err != nil {
+			return 0, err
+		}
+		return phash.Read(c.reader(photos[i].File), algorithm)
+	})
+	flagged := 0
+	for i, p := range photos {
+		if !ok[i] {
+			continue
+		}
+		target := path.Join(p.GetTargetPath(), p.FileName)
+		best := -1
+		for _, m := range tree.Search(hashes[i], c.Config.SimilarThreshold) {
+			// a file at the target path is skipped or overwritten, not duplicated
+			if m.Value.(string) == target {
+				continue
+			}
+			if best < 0 || m.Distance < best {
+				best = m.Distance
+				p.SimilarTo = m.Value.(string)
+			}
+		}
+		if p.SimilarTo != "" {
+			flagged += 1
+			c.logger().Warnf("%v looks like %v, %d bits apart", path.Join(p.Path, p.FileName), p.SimilarTo, best)
+		}
+		tree.Add(hashes[i], path.Join(p.Path, p.FileName))
+	}
+	if flagged > 0 {
+		c.logger().Warnf("%d photos look like another photo", flagged)
+	}
+	return nil
+}
+
+// perceptualHashes runs hash for 0 to n-1 with Config.ReadWorkers
+// goroutines. ok[i] is false when hash i failed, perceptual hashes are best
+// effort and failures are only logged.
+func (c *Copier) perceptualHashes(n int, hash func(i int) (phash.Hash, error)) (hashes []phash.Hash, ok []bool) {
+	hashes = make([]phash.Hash, n)
+	ok = make([]bool, n)
+	workers := c.Config.ReadWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				h, err := hash(i)
+				if err != nil {
+					c.logger().Debugf("unable to compute a perceptual hash. err=%v", err.Error())
+					continue
+				}
+				hashes[i], ok[i] = h, true
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return hashes, ok
+}
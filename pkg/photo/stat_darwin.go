@@ -0,0 +1,42 @@
+package photo
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+func fileTimes(f fs.FileInfo) (atime, ctime, mtime time.Time) {
+	stat, ok := f.Sys().(*syscall.Stat_t)
+	if !ok {
+		// not backed by the local disk
+		return f.ModTime(), f.ModTime(), f.ModTime()
+	}
+	atime = time.Unix(stat.Atimespec.Sec, stat.Atimespec.Nsec)
+	ctime = time.Unix(stat.Ctimespec.Sec, stat.Ctimespec.Nsec)
+	mtime = time.Unix(stat.Mtimespec.Sec, stat.Mtimespec.Nsec)
+	return
+}
+
+// fileDevice returns the device holding f, 0 when unknown
+func fileDevice(f fs.FileInfo) uint64 {
+	stat, ok := f.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	return uint64(stat.Dev)
+}
+
+// diskSpace returns the device holding dir and the bytes available to
+// unprivileged users on it
+func diskSpace(dir string) (device uint64, free int64, err error) {
+	var st syscall.Stat_t
+	if err = syscall.Stat(dir, &st); err != nil {
+		return
+	}
+	var fs syscall.Statfs_t
+	if err = syscall.Statfs(dir, &fs); err != nil {
+		return
+	}
+	return uint64(st.Dev), int64(fs.Bavail) * int64(fs.Bsize), nil
+}
@@ -0,0 +1,21 @@
+//go:build !darwin && !linux
+
+package photo
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+func fileTimes(f fs.FileInfo) (atime, ctime, mtime time.Time) {
+	return f.ModTime(), f.ModTime(), f.ModTime()
+}
+
+func fileDevice(f fs.FileInfo) uint64 {
+	return 0
+}
+
+func diskSpace(dir string) (device uint64, free int64, err error) {
+	return 0, 0, errors.New("free space is not available on this platform")
+}
@@ -0,0 +1,174 @@
+package photo
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// pendingFile tracks a file that is still being written in the watched folder
+type pendingFile struct {
+	LastEvent time.Time
+	Size      int64
+}
+
+type Watcher struct {
+	Copier  *Copier
+	Notify  FileWatcher
+	Pending map[string]*pendingFile
+}
+
+// NewWatcher watches the source directory through the file system of copier,
+// which must implement WatchFileSystem
+func NewWatcher(copier *Copier) (*Watcher, error) {
+	wfs, ok := copier.fileSystem().(WatchFileSystem)
+	if !ok {
+		return nil, fmt.Errorf("unable to watch %v, the file system does not notify changes", copier.Config.SourceDirectory)
+	}
+	notify, err := wfs.Watch()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create file watcher. err=%v", err.Error())
+	}
+	return &Watcher{
+		Copier:  copier,
+		Notify:  notify,
+		Pending: make(map[string]*pendingFile),
+	}, nil
+}
+
+// AddDirectory registers dir, and its subdirectories unless NoRecurse is set, for events
+func (w *Watcher) AddDirectory(dir string) error {
+	if w.Copier.Config.NoRecurse {
+		return w.Notify.Add(dir)
+	}
+	return w.Copier.fileSystem().Walk(dir, func(aPath string, f fs.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if f.IsDir() {
+			w.Copier.logger().Debugf("watching directory %v", aPath)
+			if err := w.Notify.Add(aPath); err != nil {
+				return fmt.Errorf("unable to watch directory %v. err=%v", aPath, err.Error())
+			}
+		}
+		return nil
+	})
+}
+
+// Run watches the source directory until the copier context is done.
+// New files are only handed over to the workers once they stopped changing
+// for Config.WatchSettle.
+func (w *Watcher) Run() error {
+	defer w.Notify.Close()
+	if err := w.AddDirectory(w.Copier.Config.SourceDirectory); err != nil {
+		return err
+	}
+	w.Copier.logger().Infof("Watching %v for new photos", w.Copier.Config.SourceDirectory)
+
+	interval := w.Copier.Config.WatchSettle / 2
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.Copier.Context.Done():
+			return nil
+		case event, ok := <-w.Notify.Events():
+			if !ok {
+				return nil
+			}
+			w.handleEvent(event)
+		case err, ok := <-w.Notify.Errors():
+			if !ok {
+				return nil
+			}
+			w.Copier.logger().Errorf("file watcher error. err=%v", err.Error())
+		case <-ticker.C:
+			w.flushStable()
+		}
+	}
+}
+
+func (w *Watcher) handleEvent(event FileEvent) {
+	if event.Op&(FileRemove|FileRename) != 0 {
+		delete(w.Pending, event.Name)
+		return
+	}
+	if event.Op&(FileCreate|FileWrite) == 0 {
+		return
+	}
+	f, err := w.Copier.fileSystem().Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if f.IsDir() {
+		if event.Op&FileCreate != 0 && !w.Copier.Config.NoRecurse {
+			if err := w.AddDirectory(event.Name); err != nil {
+				w.Copier.logger().Errorf(err.Error())
+			}
+		}
+		return
+	}
+	if !utils.IsImage(f) {
+		return
+	}
+	if pf, ok := w.Pending[event.Name]; ok {
+		pf.LastEvent = time.Now()
+		return
+	}
+	w.Pending[event.Name] = &pendingFile{LastEvent: time.Now(), Size: -1}
+}
+
+// flushStable ingests pending files whose size did not change since the
+// previous tick and that did not receive any event for Config.WatchSettle
+func (w *Watcher) flushStable() {
+	for name, pf := range w.Pending {
+		if time.Since(pf.LastEvent) < w.Copier.Config.WatchSettle {
+			continue
+		}
+		f, err := w.Copier.fileSystem().Stat(name)
+		if err != nil {
+			delete(w.Pending, name)
+			continue
+		}
+		if f.Size() == 0 || f.Size() != pf.Size {
+			pf.Size = f.Size()
+			continue
+		}
+		delete(w.Pending, name)
+		w.ingest(f, filepath.Dir(name))
+	}
+}
+
+// ingest queues a new photo for the workers. It is not kept in
+// Copier.Photos, only counted in the total.
+func (w *Watcher) ingest(f fs.FileInfo, dir string) {
+	p := w.Copier.readPhoto(f, dir)
+	if p == nil {
+		return
+	}
+	w.Copier.StatsMutex.Lock()
+	w.Copier.watched += 1
+	w.Copier.StatsMutex.Unlock()
+	if err := w.Copier.fileSystem().MkdirAll(p.GetTargetPath(), 0750); err != nil {
+		if p.File != nil {
+			p.File.Close()
+		}
+		w.Copier.FailPhoto(p, fmt.Errorf("unable to create directory %v. err=%w", p.GetTargetPath(), err), time.Now())
+		return
+	}
+	w.Copier.logger().Infof("new photo %v", f.Name())
+	w.Copier.Emit(p.Event(EventQueued, nil))
+	select {
+	case w.Copier.CopyQueue <- p:
+	case <-w.Copier.Context.Done():
+		if p.File != nil {
+			p.File.Close()
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package photo
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/config"
+)
+
+// notifiedFS sends the events of its watcher from the test
+type notifiedFS struct {
+	OSFileSystem
+	watcher *testWatcher
+}
+
+func (fs notifiedFS) Watch() (FileWatcher, error) {
+	return fs.watcher, nil
+}
+
+type testWatcher struct {
+	dirs   []string
+	events chan FileEvent
+}
+
+func (w *testWatcher) Add(dir string) error {
+	w.dirs = append(w.dirs, dir)
+	return nil
+}
+
+func (w *testWatcher) Events() <-chan FileEvent {
+	return w.events
+}
+
+func (w *testWatcher) Errors() <-chan error {
+	return nil
+}
+
+func (w *testWatcher) Close() error {
+	return nil
+}
+
+func TestWatcher(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	watcher := &testWatcher{events: make(chan FileEvent, 1)}
+	logger := logrus.New()
+	logger.SetOutput(&bytes.Buffer{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var events []EventType
+	cfg := &config.Config{
+		SourceDirectory: srcDir,
+		DestDirectory:   dstDir,
+		DestFileFormat:  "2006-01-02",
+		Watch:           true,
+		WatchSettle:     10 * time.Millisecond,
+	}
+	result, err := Copy(ctx, Options{
+		Config: cfg,
+		Logger: logger,
+		FS:     notifiedFS{watcher: watcher},
+		OnEvent: func(e Event) {
+			events = append(events, e.Type)
+			switch e.Type {
+			case EventStarted:
+				// the source was searched, the photo is new
+				writeTestJPEG(t, path.Join(srcDir, "img001.jpg"), "2022:04:30 10:00:00")
+				watcher.events <- FileEvent{Name: path.Join(srcDir, "img001.jpg"), Op: FileCreate}
+			case EventCopied:
+				cancel()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if result.Total != 1 || result.Copied != 1 {
+		t.Errorf("Copy() got result %+v", result)
+	}
+	if len(watcher.dirs) != 1 || watcher.dirs[0] != srcDir {
+		t.Errorf("Copy() watched %v, want %v", watcher.dirs, srcDir)
+	}
+	if _, err := os.Stat(path.Join(dstDir, "2022-04-30", "img001.jpg")); err != nil {
+		t.Errorf("Copy(): new photo not copied. err=%v", err)
+	}
+	if len(events) != 3 || events[1] != EventQueued || events[2] != EventCopied {
+		t.Errorf("Copy() got events %v", events)
+	}
+}
@@ -2,11 +2,12 @@ package photo
 
 import (
 	"crypto/md5"
+	"fmt"
 	"io"
-	"os"
 	"path"
+	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/throttle"
 )
 
 func NewWorker(id int, copier *Copier) *Worker {
@@ -22,57 +23,105 @@ type Worker struct {
 }
 
 func (w *Worker) Start() error {
-	log.Debugf("Starting worker id=%v", w.ID)
+	w.Copier.logger().Debugf("Starting worker id=%v", w.ID)
 	for {
 		select {
 		case <-w.Copier.Context.Done():
-			log.Debugf("Stopping worker id=%v", w.ID)
+			w.Copier.logger().Debugf("Stopping worker id=%v", w.ID)
+			w.Copier.Wg.Done()
+			return nil
+		case <-w.Copier.shrink:
+			w.Copier.logger().Debugf("Stopping worker id=%v, auto-tuned out", w.ID)
 			w.Copier.Wg.Done()
 			return nil
 		case p := <-w.Copier.CopyQueue:
-			log.Debugf("copying file %v to %v", p.FileName, p.GetTargetPath())
+			start := time.Now()
+			w.Copier.logger().Debugf("copying file %v to %v", p.FileName, p.GetTargetPath())
 			//Check if a file already exists at destination
 			if ok := w.CheckSameContents(p); !ok {
-				w.Copy(p)
+				if err := w.Copy(p); err != nil {
+					w.Copier.FailPhoto(p, err, start)
+					w.emit(p, EventFailed, err)
+				} else {
+					w.writeSidecar(p)
+					w.Copier.AddToManifest(p)
+					w.Copier.Report(p.ReportEntry(ActionCopied, "", start))
+					w.emit(p, EventCopied, nil)
+				}
 			} else {
+				p.File.Close()
+				w.writeSidecar(p)
+				w.Copier.AddToManifest(p)
+				w.Copier.Report(p.ReportEntry(ActionSkipped, "identical file exists at destination", start))
 				w.Copier.IncrementSkipped()
-				w.Copier.ProgressBar.Add(1)
+				w.emit(p, EventSkipped, nil)
 			}
 		}
 	}
 }
 
-func (w *Worker) Copy(p *Photo) {
+// writeSidecar tags the photo with its set, a failure doesn't fail the photo
+func (w *Worker) writeSidecar(p *Photo) {
+	if err := w.Copier.writeSidecar(p); err != nil {
+		w.Copier.logger().Errorf(err.Error())
+	}
+}
+
+func (w *Worker) emit(p *Photo, t EventType, err error) {
+	e := p.Event(t, err)
+	e.Worker = w.ID
+	w.Copier.Emit(e)
+}
+
+// Copy writes the photo to its target path. A partially written file is
+// removed on failure.
+func (w *Worker) Copy(p *Photo) error {
+	defer p.File.Close()
 	// rewind the file
-	p.File.Seek(0, 0)
-	writer, err := os.Create(path.Join(p.GetTargetPath(), p.FileName))
+	if _, err := p.File.Seek(0, 0); err != nil {
+		return fmt.Errorf("unable to rewind file %v. err=%w", p.FileName, err)
+	}
+	target := path.Join(p.GetTargetPath(), p.FileName)
+	writer, err := w.Copier.fileSystem().Create(target)
 	if err != nil {
-		log.Errorf(err.Error())
+		return fmt.Errorf("unable to create file %v. err=%w", target, err)
+	}
+	if err := w.Copier.Devices.Acquire(w.Copier.Context, p.Device); err != nil {
+		writer.Close()
+		w.Copier.fileSystem().Remove(target)
+		return fmt.Errorf("error copying file %v. err=%w", p.FileName, err)
+	}
+	bytesWritten, err := io.Copy(throttle.NewWriter(w.Copier.Context, writer, w.Copier.WriteLimiter), w.Copier.reader(p.File))
+	w.Copier.Devices.Release(p.Device)
+	if err == nil {
+		err = writer.Sync()
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
 	}
-	bytesWritten, err := io.Copy(writer, p.File)
 	if err != nil {
-		log.Errorf("error copying file %v. err=%v", p.FileName, err.Error())
+		w.Copier.fileSystem().Remove(target)
+		return fmt.Errorf("error copying file %v. err=%w", p.FileName, err)
 	}
-	writer.Sync()
-	p.File.Close()
-	writer.Close()
 
-	os.Chtimes(writer.Name(), p.Atime, p.Mtime)
+	if err := w.Copier.fileSystem().Chtimes(target, p.Atime, p.Mtime); err != nil {
+		return fmt.Errorf("unable to set times of file %v. err=%w", target, err)
+	}
 
 	w.Copier.IncrementStats(bytesWritten)
-	w.Copier.ProgressBar.Add(1)
+	return nil
 }
 
 func (w *Worker) CheckSameContents(p *Photo) bool {
-	if _, err := os.Stat(path.Join(p.GetTargetPath(), p.FileName)); err == nil {
+	if _, err := w.Copier.fileSystem().Stat(path.Join(p.GetTargetPath(), p.FileName)); err == nil {
 		// read and compute md5
-		f, err := os.Open(path.Join(p.GetTargetPath(), p.FileName))
-		defer f.Close()
+		f, err := w.Copier.fileSystem().Open(path.Join(p.GetTargetPath(), p.FileName))
 		if err != nil {
 			return false
 		} else {
+			defer f.Close()
 			h := md5.New()
-			if _, err := io.Copy(h, f); err != nil {
+			if _, err := io.Copy(h, w.Copier.reader(f)); err != nil {
 				return false
 			}
 			sum := h.Sum(nil)
@@ -0,0 +1,36 @@
+package photo
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/vfoucault/goPhoto/pkg/config"
+)
+
+// lockedFS finds every file but cannot open any
+type lockedFS struct {
+	OSFileSystem
+}
+
+func (lockedFS) Open(name string) (File, error) {
+	return nil, errors.New("permission denied")
+}
+
+func TestWorker_CheckSameContents(t *testing.T) {
+	dst := t.TempDir()
+	c := &Copier{Config: &config.Config{DestDirectory: dst, DestFileFormat: "2006"}, FS: lockedFS{}}
+	p := &Photo{FileName: "a.jpg", DateTaken: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), Copier: c}
+	if err := os.MkdirAll(p.GetTargetPath(), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(p.GetTargetPath(), p.FileName), []byte("photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	w := &Worker{Copier: c}
+	if w.CheckSameContents(p) {
+		t.Errorf("CheckSameContents() = true for a target that cannot be opened")
+	}
+}
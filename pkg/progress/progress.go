@@ -0,0 +1,172 @@
+package progress
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// File is a processed file, passed to the renderer along with the snapshot
+type File struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Size   int64  `json:"size"`
+	Worker int    `json:"worker"`
+	Error  string `json:"error,omitempty"`
+}
+
+type WorkerSnapshot struct {
+	ID          int     `json:"id"`
+	Files       int     `json:"files"`
+	Bytes       int64   `json:"bytes"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+}
+
+// Snapshot is the state of the progress at a given time
+type Snapshot struct {
+	Files       int           `json:"files"`
+	TotalFiles  int           `json:"total_files"`
+	Bytes       int64         `json:"bytes"`
+	TotalBytes  int64         `json:"total_bytes"`
+	Elapsed     time.Duration `json:"elapsed_ns"`
+	FilesPerSec float64       `json:"files_per_sec"`
+	BytesPerSec float64       `json:"bytes_per_sec"`
+	// RecentBytesPerSec is the byte rate over the last RateWindow
+	RecentBytesPerSec float64          `json:"recent_bytes_per_sec"`
+	ETA               time.Duration    `json:"eta_ns"`
+	Workers           []WorkerSnapshot `json:"workers"`
+}
+
+// RateWindow is the period over which RecentBytesPerSec is computed
+const RateWindow = 5 * time.Second
+
+type sample struct {
+	at    time.Time
+	bytes int64
+}
+
+// Renderer displays the progress. Calls are serialized by Progress.
+type Renderer interface {
+	Update(s Snapshot, f *File)
+	Finish(s Snapshot)
+}
+
+// Progress tracks processed files and bytes, overall and per worker. Rates
+// and ETA are measured from the first AddTotal, the time spent searching for
+// the files is not counted.
+type Progress struct {
+	mutex    sync.Mutex
+	renderer Renderer
+	// start is zero until the first file is known
+	start      time.Time
+	files      int
+	totalFiles int
+	bytes      int64
+	totalBytes int64
+	workers    map[int]*WorkerSnapshot
+	// recent holds the files done during the last RateWindow
+	recent []sample
+}
+
+func New(renderer Renderer) *Progress {
+	return &Progress{
+		renderer: renderer,
+		workers:  make(map[int]*WorkerSnapshot),
+	}
+}
+
+// AddTotal adds files and bytes still to process
+func (p *Progress) AddTotal(files int, bytes int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.started()
+	p.totalFiles += files
+	p.totalBytes += bytes
+	p.renderer.Update(p.snapshot(), nil)
+}
+
+// Done records a file processed by a worker
+func (p *Progress) Done(f File) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.started()
+	p.files += 1
+	p.bytes += f.Size
+	w, ok := p.workers[f.Worker]
+	if !ok {
+		w = &WorkerSnapshot{ID: f.Worker}
+		p.workers[f.Worker] = w
+	}
+	w.Files += 1
+	w.Bytes += f.Size
+	p.recent = append(p.recent, sample{at: time.Now(), bytes: f.Size})
+	p.renderer.Update(p.snapshot(), &f)
+}
+
+// started starts the clock on the first call
+func (p *Progress) started() {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+}
+
+func (p *Progress) Finish() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.renderer.Finish(p.snapshot())
+}
+
+func (p *Progress) Snapshot() Snapshot {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.snapshot()
+}
+
+func (p *Progress) snapshot() Snapshot {
+	s := Snapshot{
+		Files:      p.files,
+		TotalFiles: p.totalFiles,
+		Bytes:      p.bytes,
+		TotalBytes: p.totalBytes,
+	}
+	if !p.start.IsZero() {
+		s.Elapsed = time.Since(p.start)
+	}
+	seconds := s.Elapsed.Seconds()
+	if seconds > 0 {
+		s.FilesPerSec = float64(s.Files) / seconds
+		s.BytesPerSec = float64(s.Bytes) / seconds
+	}
+	switch {
+	case s.TotalBytes > 0 && s.BytesPerSec > 0:
+		s.ETA = time.Duration(float64(s.TotalBytes-s.Bytes) / s.BytesPerSec * float64(time.Second))
+	case s.TotalFiles > 0 && s.FilesPerSec > 0:
+		s.ETA = time.Duration(float64(s.TotalFiles-s.Files) / s.FilesPerSec * float64(time.Second))
+	}
+	now := time.Now()
+	for len(p.recent) > 0 && now.Sub(p.recent[0].at) > RateWindow {
+		p.recent = p.recent[1:]
+	}
+	if len(p.recent) > 0 && s.Elapsed > 0 {
+		window := RateWindow
+		if s.Elapsed < window {
+			window = s.Elapsed
+		}
+		var bytes int64
+		for _, r := range p.recent {
+			bytes += r.bytes
+		}
+		s.RecentBytesPerSec = float64(bytes) / window.Seconds()
+	}
+	for _, w := range p.workers {
+		ws := *w
+		if seconds > 0 {
+			ws.BytesPerSec = float64(ws.Bytes) / seconds
+		}
+		s.Workers = append(s.Workers, ws)
+	}
+	sort.Slice(s.Workers, func(i, j int) bool {
+		return s.Workers[i].ID < s.Workers[j].ID
+	})
+	return s
+}
@@ -0,0 +1,49 @@
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+type recordRenderer struct {
+	updates []Snapshot
+	final   Snapshot
+}
+
+func (r *recordRenderer) Update(s Snapshot, _ *File) { r.updates = append(r.updates, s) }
+func (r *recordRenderer) Finish(s Snapshot)          { r.final = s }
+
+func TestProgress(t *testing.T) {
+	r := &recordRenderer{}
+	p := New(r)
+	p.AddTotal(2, 300)
+	p.Done(File{Path: "a.jpg", Size: 100, Worker: 1})
+	p.AddTotal(1, 50)
+	p.Done(File{Path: "b.jpg", Size: 200, Worker: 0})
+	p.Done(File{Path: "c.jpg", Size: 50, Worker: 1})
+	p.Finish()
+
+	if len(r.updates) != 5 {
+		t.Errorf("Progress got %d updates, want 5", len(r.updates))
+	}
+	s := r.final
+	if s.Files != 3 || s.TotalFiles != 3 || s.Bytes != 350 || s.TotalBytes != 350 || s.ETA != 0 {
+		t.Errorf("Progress got final snapshot %+v", s)
+	}
+	if len(s.Workers) != 2 || s.Workers[0].ID != 0 || s.Workers[1].Files != 2 || s.Workers[1].Bytes != 150 {
+		t.Errorf("Progress got workers %+v", s.Workers)
+	}
+}
+
+func TestProgressStart(t *testing.T) {
+	p := New(&recordRenderer{})
+	// searching
+	time.Sleep(50 * time.Millisecond)
+	if s := p.Snapshot(); s.Elapsed != 0 {
+		t.Errorf("Progress elapsed %v before the first file", s.Elapsed)
+	}
+	p.AddTotal(1, 100)
+	if s := p.Snapshot(); s.Elapsed >= 50*time.Millisecond {
+		t.Errorf("Progress elapsed %v counts the search", s.Elapsed)
+	}
+}
@@ -0,0 +1,140 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	"github.com/schollz/progressbar/v3"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+const (
+	ModeAuto = "auto"
+	ModeBar  = "bar"
+	ModeLog  = "log"
+	ModeJSON = "json"
+	ModeNone = "none"
+)
+
+// NewRenderer returns the renderer for mode. auto is a bar on a terminal and
+// periodic log lines otherwise.
+func NewRenderer(mode string, interval time.Duration) (Renderer, error) {
+	switch mode {
+	case ModeAuto:
+		if term.IsTerminal(int(os.Stderr.Fd())) {
+			return NewBarRenderer(os.Stderr), nil
+		}
+		return NewLogRenderer(interval), nil
+	case ModeBar:
+		return NewBarRenderer(os.Stderr), nil
+	case ModeLog:
+		return NewLogRenderer(interval), nil
+	case ModeJSON:
+		return NewJSONRenderer(os.Stdout), nil
+	case ModeNone:
+		return noneRenderer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown progress mode %s. only %s", mode, strings.Join([]string{ModeAuto, ModeBar, ModeLog, ModeJSON, ModeNone}, ", "))
+	}
+}
+
+// Summary formats a snapshot for humans
+func Summary(s Snapshot) string {
+	line := fmt.Sprintf("%d/%d files, %s/%s, %s/s (currently %s/s)", s.Files, s.TotalFiles,
+		bytefmt.ByteSize(uint64(s.Bytes)), bytefmt.ByteSize(uint64(s.TotalBytes)),
+		bytefmt.ByteSize(uint64(s.BytesPerSec)), bytefmt.ByteSize(uint64(s.RecentBytesPerSec)))
+	if s.ETA > 0 {
+		line += fmt.Sprintf(", ETA %v", s.ETA.Round(time.Second))
+	}
+	return line
+}
+
+type noneRenderer struct{}
+
+func (noneRenderer) Update(Snapshot, *File) {}
+func (noneRenderer) Finish(Snapshot)        {}
+
+// BarRenderer draws a terminal progress bar on bytes
+type BarRenderer struct {
+	writer io.Writer
+	bar    *progressbar.ProgressBar
+	max    int64
+}
+
+func NewBarRenderer(w io.Writer) *BarRenderer {
+	return &BarRenderer{writer: w}
+}
+
+func (r *BarRenderer) Update(s Snapshot, _ *File) {
+	if r.bar == nil {
+		r.bar = progressbar.NewOptions64(s.TotalBytes,
+			progressbar.OptionSetWriter(r.writer),
+			progressbar.OptionShowBytes(true),
+			progressbar.OptionSetPredictTime(true),
+		)
+		r.max = s.TotalBytes
+	}
+	if s.TotalBytes != r.max {
+		r.bar.ChangeMax64(s.TotalBytes)
+		r.max = s.TotalBytes
+	}
+	r.bar.Describe(fmt.Sprintf("%d/%d files", s.Files, s.TotalFiles))
+	r.bar.Set64(s.Bytes)
+}
+
+func (r *BarRenderer) Finish(Snapshot) {
+	if r.bar != nil {
+		r.bar.Clear()
+	}
+}
+
+// LogRenderer logs the progress at most once per interval
+type LogRenderer struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func NewLogRenderer(interval time.Duration) *LogRenderer {
+	return &LogRenderer{interval: interval, last: time.Now()}
+}
+
+func (r *LogRenderer) Update(s Snapshot, _ *File) {
+	if time.Since(r.last) < r.interval {
+		return
+	}
+	r.last = time.Now()
+	log.Infof("Progress: %s", Summary(s))
+}
+
+func (r *LogRenderer) Finish(s Snapshot) {
+	log.Infof("Progress: %s", Summary(s))
+}
+
+// JSONRenderer writes one JSON object per line for each update
+type JSONRenderer struct {
+	encoder *json.Encoder
+}
+
+type jsonEvent struct {
+	Type string `json:"type"`
+	Snapshot
+	File *File `json:"file,omitempty"`
+}
+
+func NewJSONRenderer(w io.Writer) *JSONRenderer {
+	return &JSONRenderer{encoder: json.NewEncoder(w)}
+}
+
+func (r *JSONRenderer) Update(s Snapshot, f *File) {
+	r.encoder.Encode(jsonEvent{Type: "progress", Snapshot: s, File: f})
+}
+
+func (r *JSONRenderer) Finish(s Snapshot) {
+	r.encoder.Encode(jsonEvent{Type: "done", Snapshot: s})
+}
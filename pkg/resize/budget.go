@@ -0,0 +1,106 @@
+package resize
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/vfoucault/goPhoto/pkg/metadata"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// MinQuality is the lowest JPEG or WebP quality tried before shrinking the photo to
+// fit a byte budget
+var MinQuality = 40
+
+// minBudgetEdge is the smallest edge a photo is shrunk to
+const minBudgetEdge = 16
+
+// budget is the outcome of encodeWithin
+type budget struct {
+	Data []byte
+	// Quality is 0 for formats without quality
+	Quality       int
+	Width, Height int
+}
+
+// encodeWithin encodes img in format in at most maxBytes, at the highest
+// JPEG or lossy WebP quality between MinQuality and format.Quality that fits. When none
+// does, the photo is shrunk with s and the search starts over.
+func encodeWithin(img image.Image, format utils.Format, meta *metadata.Metadata, maxBytes int64, s scaler) (*budget, error) {
+	for {
+		w, h := img.Bounds().Dx(), img.Bounds().Dy()
+		result, smallest, err := searchQuality(img, format, meta, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			result.Width, result.Height = w, h
+			return result, nil
+		}
+		// the size is roughly proportional to the number of pixels
+		f := math.Min(0.9, 0.95*math.Sqrt(float64(maxBytes)/float64(smallest)))
+		nw, nh := scale(w, f), scale(h, f)
+		if nw < minBudgetEdge || nh < minBudgetEdge {
+			return nil, fmt.Errorf("unable to encode under %d bytes, %d bytes at %dx%d", maxBytes, smallest, w, h)
+		}
+		img = s.resize(nw, nh, img)
+	}
+}
+
+// searchQuality returns the best encoding of img under maxBytes, or nil and
+// the size of the smallest encoding tried
+func searchQuality(img image.Image, format utils.Format, meta *metadata.Metadata, maxBytes int64) (*budget, int, error) {
+	encode := func(quality int) ([]byte, error) {
+		format.Quality = quality
+		return utils.EncodeImage(img, format, meta)
+	}
+	high := format.Quality
+	if high == 0 {
+		high = 90
+	}
+	data, err := encode(high)
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(data)) <= maxBytes {
+		return &budget{Data: data, Quality: qualityOf(format, high)}, 0, nil
+	}
+	if !hasQuality(format) || high <= MinQuality {
+		return nil, len(data), nil
+	}
+	// highest quality that fits, in [low, high)
+	var best []byte
+	bestQuality, smallest := 0, len(data)
+	low := MinQuality
+	for low < high {
+		mid := (low + high) / 2
+		data, err := encode(mid)
+		if err != nil {
+			return nil, 0, err
+		}
+		if int64(len(data)) <= maxBytes {
+			best, bestQuality = data, mid
+			low = mid + 1
+		} else {
+			smallest = len(data)
+			high = mid
+		}
+	}
+	if best == nil {
+		return nil, smallest, nil
+	}
+	return &budget{Data: best, Quality: bestQuality}, 0, nil
+}
+
+// hasQuality tells whether the size of format depends on its quality
+func hasQuality(format utils.Format) bool {
+	return format.Name == utils.FormatJPEG || format.Name == utils.FormatWebP && !format.Lossless
+}
+
+func qualityOf(format utils.Format, q int) int {
+	if !hasQuality(format) {
+		return 0
+	}
+	return q
+}
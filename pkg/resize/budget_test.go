@@ -0,0 +1,56 @@
+package resize
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+func TestEncodeWithin(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	noise := image.NewRGBA(image.Rect(0, 0, 200, 150))
+	for i := range noise.Pix {
+		noise.Pix[i] = uint8(rng.Intn(256))
+	}
+	jpeg, _ := utils.ParseFormat(utils.FormatJPEG, "")
+	png, _ := utils.ParseFormat(utils.FormatPNG, "")
+	full, err := utils.EncodeImage(noise, jpeg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		format      utils.Format
+		maxBytes    int64
+		wantQuality func(int) bool
+		wantShrunk  bool
+		wantErr     bool
+	}{
+		{name: "fits", format: jpeg, maxBytes: int64(len(full)), wantQuality: func(q int) bool { return q == 90 }},
+		{name: "lower quality", format: jpeg, maxBytes: int64(len(full)) * 3 / 4, wantQuality: func(q int) bool { return q >= MinQuality && q < 90 }},
+		{name: "shrunk", format: jpeg, maxBytes: 4000, wantQuality: func(q int) bool { return q >= MinQuality }, wantShrunk: true},
+		{name: "png shrunk", format: png, maxBytes: 20000, wantQuality: func(q int) bool { return q == 0 }, wantShrunk: true},
+		{name: "too small", format: jpeg, maxBytes: 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := encodeWithin(noise, tt.format, nil, tt.maxBytes, newScaler(DefaultFilter, false))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: err=%v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if int64(len(got.Data)) > tt.maxBytes {
+			t.Errorf("%v: %d bytes over %d", tt.name, len(got.Data), tt.maxBytes)
+		}
+		if !tt.wantQuality(got.Quality) {
+			t.Errorf("%v: unexpected quality %d", tt.name, got.Quality)
+		}
+		if shrunk := got.Width < 200; shrunk != tt.wantShrunk {
+			t.Errorf("%v: %dx%d, want shrunk %v", tt.name, got.Width, got.Height, tt.wantShrunk)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package resize
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// Resampling filters, from the fastest to the sharpest
+var filters = map[string]resize.InterpolationFunction{
+	"nearest":  resize.NearestNeighbor,
+	"bilinear": resize.Bilinear,
+	"bicubic":  resize.Bicubic,
+	"mitchell": resize.MitchellNetravali,
+	"lanczos2": resize.Lanczos2,
+	"lanczos3": resize.Lanczos3,
+}
+
+// DefaultFilter is the resampling filter used when none is given
+const DefaultFilter = "lanczos3"
+
+// ValidateFilter returns an error for an unknown filter name
+func ValidateFilter(name string) error {
+	if _, ok := filters[name]; !ok && name != "" {
+		return fmt.Errorf("unknown filter %v. only nearest, bilinear, bicubic, mitchell, lanczos2 or lanczos3", name)
+	}
+	return nil
+}
+
+// scaler resamples images with a filter, in linear light when linear is set
+type scaler struct {
+	filter resize.InterpolationFunction
+	linear bool
+}
+
+func newScaler(filter string, linear bool) scaler {
+	f, ok := filters[filter]
+	if !ok {
+		f = filters[DefaultFilter]
+	}
+	return scaler{filter: f, linear: linear}
+}
+
+func (s scaler) resize(w, h uint, img image.Image) image.Image {
+	if !s.linear {
+		return resize.Resize(w, h, img, s.filter)
+	}
+	return fromLinear(resize.Resize(w, h, toLinear(img), s.filter))
+}
+
+var (
+	gammaOnce sync.Once
+	// sRGB to linear and back, 16 bits
+	toLinearLUT, toSRGBLUT []uint16
+)
+
+func gammaTables() {
+	gammaOnce.Do(func() {
+		toLinearLUT = make([]uint16, 1<<16)
+		toSRGBLUT = make([]uint16, 1<<16)
+		for i := range toLinearLUT {
+			v := float64(i) / 0xffff
+			var linear, srgb float64
+			if v <= 0.04045 {
+				linear = v / 12.92
+			} else {
+				linear = math.Pow((v+0.055)/1.055, 2.4)
+			}
+			if v <= 0.0031308 {
+				srgb = v * 12.92
+			} else {
+				srgb = 1.055*math.Pow(v, 1/2.4) - 0.055
+			}
+			toLinearLUT[i] = uint16(math.Round(linear * 0xffff))
+			toSRGBLUT[i] = uint16(math.Round(srgb * 0xffff))
+		}
+	})
+}
+
+// toLinear converts an sRGB image to linear light, 16 bits per channel
+func toLinear(img image.Image) *image.RGBA64 {
+	gammaTables()
+	return convert(img, toLinearLUT)
+}
+
+// fromLinear converts a linear light image back to sRGB
+func fromLinear(img image.Image) *image.RGBA64 {
+	return convert(img, toSRGBLUT)
+}
+
+// convert maps the unpremultiplied color channels of img through lut
+func convert(img image.Image, lut []uint16) *image.RGBA64 {
+	b := img.Bounds()
+	dst := image.NewRGBA64(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			if a == 0 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			for c, v := range []uint32{r, g, bl} {
+				v = uint32(lut[v*0xffff/a]) * a / 0xffff
+				dst.Pix[i+2*c] = uint8(v >> 8)
+				dst.Pix[i+2*c+1] = uint8(v)
+			}
+			dst.Pix[i+6] = uint8(a >> 8)
+			dst.Pix[i+7] = uint8(a)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,109 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+func TestValidateFilter(t *testing.T) {
+	for _, name := range []string{"", "nearest", "bilinear", "bicubic", "mitchell", "lanczos2", "lanczos3"} {
+		if err := ValidateFilter(name); err != nil {
+			t.Errorf("ValidateFilter(%v) err=%v", name, err)
+		}
+	}
+	if err := ValidateFilter("box"); err == nil {
+		t.Errorf("unknown filter accepted")
+	}
+}
+
+func TestLinearDownsample(t *testing.T) {
+	// black and white stripes average to 50% light, sRGB 188
+	stripes := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x += 2 {
+			stripes.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	tests := []struct {
+		linear   bool
+		min, max uint8
+	}{
+		{false, 120, 136},
+		{true, 180, 196},
+	}
+	for _, tt := range tests {
+		got := newScaler("bilinear", tt.linear).resize(8, 8, stripes)
+		if g := color.GrayModel.Convert(got.At(4, 4)).(color.Gray).Y; g < tt.min || g > tt.max {
+			t.Errorf("linear=%v: gray %d not in [%d,%d]", tt.linear, g, tt.min, tt.max)
+		}
+	}
+
+	// sRGB to linear and back is within one level
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		src.SetNRGBA(x, 0, color.NRGBA{R: uint8(x), G: uint8(255 - x), B: 128, A: 255})
+	}
+	back := fromLinear(toLinear(src))
+	for x := 0; x < 256; x++ {
+		got := color.NRGBAModel.Convert(back.At(x, 0)).(color.NRGBA)
+		want := src.NRGBAAt(x, 0)
+		for _, d := range []int{int(got.R) - int(want.R), int(got.G) - int(want.G), int(got.B) - int(want.B)} {
+			if d < -1 || d > 1 {
+				t.Errorf("round trip of %v gave %v", want, got)
+			}
+		}
+	}
+}
+
+func TestParseSharpen(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    utils.Sharpen
+		wantErr bool
+	}{
+		{spec: "", want: utils.Sharpen{}},
+		{spec: "amount=0.5", want: utils.Sharpen{Amount: 0.5, Radius: 1}},
+		{spec: "amount=1, radius=2, threshold=3", want: utils.Sharpen{Amount: 1, Radius: 2, Threshold: 3}},
+		{spec: "radius=0", wantErr: true},
+		{spec: "threshold=300", wantErr: true},
+		{spec: "strength=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSharpen(tt.spec)
+		if (err != nil) != tt.wantErr || (err == nil && got != tt.want) {
+			t.Errorf("ParseSharpen(%q) = %+v, %v", tt.spec, got, err)
+		}
+	}
+}
+
+func TestUnsharpMask(t *testing.T) {
+	// a soft vertical edge
+	edge := image.NewGray(image.Rect(0, 0, 20, 4))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 4; y++ {
+			edge.SetGray(x, y, color.Gray{Y: uint8(100 + 3*x)})
+		}
+	}
+	edge.SetGray(10, 1, color.Gray{Y: 200})
+	gray := func(img image.Image, x, y int) uint8 {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+	}
+	sharp := unsharpMask(edge, utils.Sharpen{Amount: 1, Radius: 1})
+	if gray(sharp, 10, 1) <= 200 {
+		t.Errorf("peak %d not sharpened", gray(sharp, 10, 1))
+	}
+	if gray(sharp, 9, 1) >= gray(edge, 9, 1) {
+		t.Errorf("peak neighbour %d not darkened", gray(sharp, 9, 1))
+	}
+	// the smooth gradient is below the threshold
+	thresholded := unsharpMask(edge, utils.Sharpen{Amount: 1, Radius: 1, Threshold: 10})
+	if gray(thresholded, 3, 3) != gray(edge, 3, 3) {
+		t.Errorf("gradient changed to %d below the threshold", gray(thresholded, 3, 3))
+	}
+	if unsharpMask(edge, utils.Sharpen{}) != image.Image(edge) {
+		t.Errorf("zero amount changed the image")
+	}
+}
@@ -0,0 +1,120 @@
+package resize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Resize modes, a 0 width or height is computed from the aspect ratio
+const (
+	// ModeFit scales the photo to fit within the box
+	ModeFit = "fit"
+	// ModeFill scales the photo to cover the box and crops the center
+	ModeFill = "fill"
+	// ModePad fits the photo and centers it on a box filled with the background
+	ModePad = "pad"
+	// ModeExact stretches the photo to the box
+	ModeExact = "exact"
+	// ModeLongEdge scales the longest edge of the photo to the size
+	ModeLongEdge = "long-edge"
+	// ModeShortEdge scales the shortest edge of the photo to the size
+	ModeShortEdge = "short-edge"
+)
+
+// ValidateMode returns an error for an unknown resize mode
+func ValidateMode(mode string) error {
+	switch mode {
+	case ModeFit, ModeFill, ModePad, ModeExact, ModeLongEdge, ModeShortEdge:
+		return nil
+	}
+	return fmt.Errorf("unknown resize mode %v. only %s, %s, %s, %s, %s or %s", mode,
+		ModeFit, ModeFill, ModePad, ModeExact, ModeLongEdge, ModeShortEdge)
+}
+
+// ParseColor parses white, black or a #rrggbb color
+func ParseColor(s string) (color.Color, error) {
+	switch s {
+	case "white":
+		return color.White, nil
+	case "black":
+		return color.Black, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("unable to parse color %s. only white, black or #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// scale returns n*f rounded, at least 1
+func scale(n int, f float64) uint {
+	return uint(math.Max(1, math.Round(float64(n)*f)))
+}
+
+// fitSize returns the size of a sw x sh image scaled to fit in w x h
+func fitSize(sw, sh int, w, h uint) (uint, uint) {
+	switch {
+	case w == 0 && h == 0:
+		return uint(sw), uint(sh)
+	case w == 0:
+		return scale(sw, float64(h)/float64(sh)), h
+	case h == 0:
+		return w, scale(sh, float64(w)/float64(sw))
+	}
+	f := math.Min(float64(w)/float64(sw), float64(h)/float64(sh))
+	return scale(sw, f), scale(sh, f)
+}
+
+// resizeImage resizes img to w x h with s according to mode, background is
+// the padding color of ModePad
+func resizeImage(w, h uint, mode string, background color.Color, s scaler, img image.Image) (image.Image, error) {
+	sw, sh := img.Bounds().Dx(), img.Bounds().Dy()
+	if sw == 0 || sh == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	switch mode {
+	case ModeExact:
+		return s.resize(w, h, img), nil
+	case ModeLongEdge, ModeShortEdge:
+		edge := w
+		if edge == 0 {
+			edge = h
+		}
+		if (sw >= sh) == (mode == ModeLongEdge) {
+			return s.resize(edge, 0, img), nil
+		}
+		return s.resize(0, edge, img), nil
+	case ModeFill:
+		if w == 0 || h == 0 {
+			break
+		}
+		f := math.Max(float64(w)/float64(sw), float64(h)/float64(sh))
+		m := s.resize(scale(sw, f), scale(sh, f), img)
+		dst := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+		offset := image.Pt((m.Bounds().Dx()-int(w))/2, (m.Bounds().Dy()-int(h))/2)
+		draw.Draw(dst, dst.Bounds(), m, m.Bounds().Min.Add(offset), draw.Src)
+		return dst, nil
+	case ModePad:
+		if w == 0 || h == 0 {
+			break
+		}
+		fw, fh := fitSize(sw, sh, w, h)
+		m := s.resize(fw, fh, img)
+		dst := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+		at := image.Rect(0, 0, int(fw), int(fh)).Add(image.Pt((int(w)-int(fw))/2, (int(h)-int(fh))/2))
+		draw.Draw(dst, at, m, m.Bounds().Min, draw.Over)
+		return dst, nil
+	case ModeFit:
+	default:
+		return nil, ValidateMode(mode)
+	}
+	fw, fh := fitSize(sw, sh, w, h)
+	return s.resize(fw, fh, img), nil
+}
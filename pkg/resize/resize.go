@@ -1,108 +1,319 @@
 package resize
 
 import (
+	"context"
 	"fmt"
-	"image"
 	"image/color"
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
-	"sync"
 
-	"github.com/fogleman/gg"
-	"github.com/nfnt/resize"
 	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/engine"
+	"github.com/vfoucault/goPhoto/pkg/manifest"
+	"github.com/vfoucault/goPhoto/pkg/metadata"
 	"github.com/vfoucault/goPhoto/pkg/utils"
 	"github.com/vfoucault/goPhoto/pkg/watermark"
 )
 
-func PhotoResize(w, h uint, srcPath, dstPath string, addText bool, wm ...watermark.WaterMark) error {
-	// list all images
-	tasks := make(chan *utils.Task, runtime.NumCPU())
-	// Launch workers
-	wg := &sync.WaitGroup{}
-	for i := 0; i < runtime.NumCPU(); i++ {
-		go func() {
-			for {
-				task := <-tasks
-				//log.Infof("Will have to run task for task %s", task.Name)
-				hasErrors := ProcessTask(task)
-				if hasErrors {
-					log.Errorf("processing %s led to errors. check logs.", task.Name)
-				}
-				wg.Done()
-			}
-		}()
-	}
-	filepath.Walk(srcPath, func(aPath string, f os.FileInfo, _ error) error {
-		if utils.IsImage(f) {
-			// create Task struct
-			task := &utils.Task{
-				Path:     strings.TrimSuffix(aPath, f.Name()),
-				Name:     f.Name(),
-				SavePath: dstPath,
-				Resize: struct {
-					Enabled       bool
-					Width, Height uint
-				}{
-					Enabled: true,
-					Width:   w,
-					Height:  h,
-				},
-				Watermark: struct {
-					Enabled bool
-					Color   color.Gray16
-					Size    float64
-					Text    string
-				}{
-					Enabled: addText,
-					Color:   wm[0].Color,
-					Size:    wm[0].Size,
-					Text:    wm[0].Text,
-				},
+// ParseRenditions parses a comma separated list of [name:]WxH sizes, e.g.
+// thumb:320x320,web:1600x1064
+func ParseRenditions(s string) ([]utils.Rendition, error) {
+	var renditions []utils.Rendition
+	seen := make(map[string]bool)
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		var r utils.Rendition
+		size := spec
+		if name, rest, ok := strings.Cut(spec, ":"); ok {
+			r.Name, size = name, rest
+			if r.Name == "" || strings.ContainsAny(r.Name, `/\.`) {
+				return nil, fmt.Errorf("invalid rendition name %q in %v", r.Name, spec)
 			}
-			tasks <- task
-			wg.Add(1)
 		}
-		return nil
+		w, h, ok := strings.Cut(size, "x")
+		if !ok {
+			return nil, fmt.Errorf("unable to parse %s as size in format WxH", size)
+		}
+		width, err := strconv.ParseUint(w, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse width %s. err=%w", w, err)
+		}
+		height, err := strconv.ParseUint(h, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse height %s. err=%w", h, err)
+		}
+		r.Width, r.Height = uint(width), uint(height)
+		key := r.Name
+		if key == "" {
+			key = size
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("rendition %v given twice", key)
+		}
+		seen[key] = true
+		renditions = append(renditions, r)
+	}
+	if len(renditions) == 0 {
+		return nil, fmt.Errorf("no size given")
+	}
+	return renditions, nil
+}
+
+// Options of PhotoResize
+type Options struct {
+	Renditions []utils.Rendition
+	// Layout of the named renditions, utils.LayoutDir or utils.LayoutSuffix
+	Layout string
+	Mode   string
+	// Background pads the photos in ModePad
+	Background color.Color
+	// Strip selects the metadata not copied from the sources
+	Strip metadata.Strip
+	// Format of the outputs
+	Format utils.Format
+	// MaxBytes bounds the size of the outputs, 0 is unlimited
+	MaxBytes int64
+	// Filter resamples the photos, DefaultFilter when empty
+	Filter string
+	// Linear resamples in linear light rather than sRGB
+	Linear bool
+	// Sharpen is applied after resizing
+	Sharpen utils.Sharpen
+	// Template names the outputs, defaults to the Layout
+	Template string
+	// Flatten writes every output to the destination instead of mirroring
+	// the source tree
+	Flatten bool
+	// Incremental skips the outputs up to date in the derived manifest of
+	// the destination
+	Incremental bool
+	// Prune removes the outputs of deleted sources, when Incremental
+	Prune bool
+	// Engine bounds the workers and their memory
+	Engine engine.Options
+}
+
+// PhotoResize decodes every photo of srcPath once and saves one resized copy
+// per rendition to dstPath, below the same subdirectory unless flattened.
+// Nothing is written when two outputs collide. The photos not processed yet
+// are skipped once ctx is done.
+func PhotoResize(ctx context.Context, opts Options, srcPath, dstPath string, addText bool, wm ...watermark.WaterMark) error {
+	switch opts.Layout {
+	case utils.LayoutDir, utils.LayoutSuffix:
+	default:
+		return fmt.Errorf("unknown layout %v. only %s or %s", opts.Layout, utils.LayoutDir, utils.LayoutSuffix)
+	}
+	if err := ValidateMode(opts.Mode); err != nil {
+		return err
+	}
+	if err := ValidateFilter(opts.Filter); err != nil {
+		return err
+	}
+	if opts.Template != "" {
+		if _, err := utils.ExpandTemplate(opts.Template, templateVars(&utils.Task{Name: "a.jpg"}, utils.Rendition{})); err != nil {
+			return err
+		}
+	}
+	var derived *manifest.Derived
+	if opts.Incremental {
+		var err error
+		if derived, err = manifest.ReadDerived(dstPath); err != nil {
+			return err
+		}
+	}
+	errs := &utils.ErrorCollector{}
+	found, err := engine.Collect(ctx, srcPath, errs, func(aPath string, f os.FileInfo) *utils.Task {
+		task := &utils.Task{
+			Path:     strings.TrimSuffix(aPath, f.Name()),
+			Name:     f.Name(),
+			SavePath: dstPath,
+			Template: opts.Template,
+			Derived:  derived,
+			Watermark: struct {
+				Enabled bool
+				Color   color.Gray16
+				Size    float64
+				Text    string
+			}{
+				Enabled: addText,
+				Color:   wm[0].Color,
+				Size:    wm[0].Size,
+				Text:    wm[0].Text,
+			},
+		}
+		task.Strip = opts.Strip
+		task.Format = opts.Format
+		task.Resize.Enabled = true
+		task.Resize.Renditions = opts.Renditions
+		task.Resize.Layout = opts.Layout
+		task.Resize.Mode = opts.Mode
+		task.Resize.Background = opts.Background
+		task.Resize.MaxBytes = opts.MaxBytes
+		task.Resize.Filter = opts.Filter
+		task.Resize.Linear = opts.Linear
+		task.Resize.Sharpen = opts.Sharpen
+		if !opts.Flatten {
+			task.RelDir = utils.RelDir(srcPath, aPath)
+		}
+		return task
 	})
-	wg.Wait()
-	return nil
+	if err != nil {
+		return err
+	}
+	total := len(found) + errs.Len()
+	if err := checkCollisions(found); err != nil {
+		return err
+	}
+	results := engine.Run(ctx, found, opts.Engine, ProcessTask)
+	engine.Failed(results, errs)
+	// keep what was generated before an interruption
+	if err := utils.UpdateDerived(derived, opts.Prune && ctx.Err() == nil); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("resize interrupted. err=%w", err)
+	}
+	return errs.Err(total)
 }
 
-func ProcessTask(task *utils.Task) bool {
-	log.Infof("resizing %s...", task.Name)
+// ProcessTask saves the renditions of the photo of the task, it stops
+// between renditions once ctx is done
+func ProcessTask(ctx context.Context, task *utils.Task) error {
 	imagePath := path.Join(task.Path, task.Name)
-	img, err := gg.LoadImage(imagePath)
-	var hasErrors bool
+	type pending struct {
+		rendition utils.Rendition
+		output    string
+		entry     manifest.DerivedEntry
+	}
+	var todo []pending
+	for _, r := range task.Resize.Renditions {
+		output, err := outputPath(task, r)
+		if err != nil {
+			return err
+		}
+		entry, stale, err := task.Stale(output, r)
+		if err != nil {
+			return err
+		}
+		if stale {
+			todo = append(todo, pending{r, output, entry})
+		}
+	}
+	if len(todo) == 0 {
+		log.Infof("%s is up to date", imagePath)
+		return nil
+	}
+
+	log.Infof("resizing %s...", task.Name)
+	src, err := utils.LoadImage(imagePath)
 	if err != nil {
-		log.Errorf("unable to load image %s. err=%w", imagePath, err.Error())
-		hasErrors = true
+		return fmt.Errorf("unable to load image %s. err=%w", imagePath, err)
 	}
-	img, err = resizeImage(task.Resize.Width, task.Resize.Height, img)
+	meta, err := metadata.Read(imagePath, task.Strip)
 	if err != nil {
-		log.Errorf("unable to resize image %s, err=%s", imagePath, err.Error())
-		hasErrors = true
+		return err
 	}
-	if task.Watermark.Enabled {
-		log.Infof("Adding watermark %s to image %s", task.Watermark.Text, imagePath)
-		img, err = watermark.AddWatermark(img, task.Watermark.Text, task.Watermark.Color, task.Watermark.Size)
+	s := newScaler(task.Resize.Filter, task.Resize.Linear)
+	for _, p := range todo {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r, output := p.rendition, p.output
+		img, err := resizeImage(r.Width, r.Height, task.Resize.Mode, task.Resize.Background, s, src)
 		if err != nil {
-			log.Errorf("unable to add watermark %s to image %s. err=%v", task.Watermark.Text, imagePath, err.Error())
+			return fmt.Errorf("unable to resize image %s. err=%w", imagePath, err)
 		}
+		img = unsharpMask(img, task.Resize.Sharpen)
+		if task.Watermark.Enabled {
+			log.Infof("Adding watermark %s to image %s", task.Watermark.Text, imagePath)
+			img, err = watermark.AddWatermark(img, task.Watermark.Text, task.Watermark.Color, task.Watermark.Size)
+			if err != nil {
+				return fmt.Errorf("unable to add watermark %s to image %s. err=%w", task.Watermark.Text, imagePath, err)
+			}
+		}
+		dir, fileName := filepath.Split(output)
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return fmt.Errorf("unable to create directory %s. err=%w", dir, err)
+		}
+		format := task.Format.For(task.Name)
+		if task.Resize.MaxBytes <= 0 {
+			if err := utils.SaveImage(dir, fileName, img, format, meta); err != nil {
+				return fmt.Errorf("unable to save image %s. err=%w", output, err)
+			}
+		} else {
+			result, err := encodeWithin(img, format, meta, task.Resize.MaxBytes, s)
+			if err != nil {
+				return fmt.Errorf("unable to fit image %s. err=%w", output, err)
+			}
+			log.Infof("%s: %dx%d, quality %d, %d bytes", output, result.Width, result.Height, result.Quality, len(result.Data))
+			if err := utils.WriteImage(dir, fileName, result.Data); err != nil {
+				return fmt.Errorf("unable to save image %s. err=%w", output, err)
+			}
+		}
+		if err := task.Generated(output, p.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateVars returns the placeholders of the output of r:
+// {name}, {ext}, {dir}, {rendition}, {w} and {h}
+func templateVars(task *utils.Task, r utils.Rendition) map[string]string {
+	rendition := r.Name
+	if rendition == "" {
+		rendition = fmt.Sprintf("%dx%d", r.Width, r.Height)
 	}
-	imageName := strings.TrimSuffix(task.Name, filepath.Ext(task.Name))
-	err = utils.SaveImage(task.SavePath, fmt.Sprintf("%s_%dx%d.jpg", imageName, task.Resize.Width, task.Resize.Height), img)
+	return map[string]string{
+		"name":      strings.TrimSuffix(task.Name, filepath.Ext(task.Name)),
+		"ext":       task.Format.For(task.Name).Ext(),
+		"dir":       task.RelDir,
+		"rendition": rendition,
+		"w":         fmt.Sprint(r.Width),
+		"h":         fmt.Sprint(r.Height),
+	}
+}
+
+// outputPath returns where to save the rendition r of the task image
+func outputPath(task *utils.Task, r utils.Rendition) (string, error) {
+	template := task.Template
+	switch {
+	case template != "":
+	case r.Name == "":
+		template = "{dir}/{name}_{w}x{h}{ext}"
+	case task.Resize.Layout == utils.LayoutSuffix:
+		template = "{dir}/{name}_{rendition}{ext}"
+	default:
+		template = "{rendition}/{dir}/{name}{ext}"
+	}
+	rel, err := utils.ExpandTemplate(template, templateVars(task, r))
 	if err != nil {
-		log.Errorf("unable to save image %s. err=%s", path.Join(task.SavePath, fmt.Sprintf("%s_%dx%d.jpg", imageName, task.Resize.Width, task.Resize.Height)), err.Error())
-		hasErrors = true
+		return "", err
 	}
-	return hasErrors
+	return filepath.Join(task.SavePath, filepath.FromSlash(rel)), nil
 }
 
-func resizeImage(w, h uint, img image.Image) (image.Image, error) {
-	m := resize.Resize(w, h, img, resize.Lanczos3)
-	return m, nil
+// checkCollisions fails when two outputs of tasks have the same path, or
+// when an output would overwrite a source
+func checkCollisions(tasks []*utils.Task) error {
+	outputs := make(map[string][]string)
+	sources := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		source := path.Join(task.Path, task.Name)
+		sources = append(sources, source)
+		for _, r := range task.Resize.Renditions {
+			output, err := outputPath(task, r)
+			if err != nil {
+				return err
+			}
+			outputs[output] = append(outputs[output], source)
+		}
+	}
+	return utils.CheckCollisions(outputs, sources)
 }
@@ -0,0 +1,126 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+func TestParseRenditions(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []utils.Rendition
+		wantErr bool
+	}{
+		{spec: "1600x1064", want: []utils.Rendition{{Width: 1600, Height: 1064}}},
+		{spec: "thumb:320x320, web:1600x1064", want: []utils.Rendition{{Name: "thumb", Width: 320, Height: 320}, {Name: "web", Width: 1600, Height: 1064}}},
+		{spec: "web:800x0", want: []utils.Rendition{{Name: "web", Width: 800}}},
+		{spec: "thumb:320x320,thumb:640x640", wantErr: true},
+		{spec: "../up:320x320", wantErr: true},
+		{spec: "320", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRenditions(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRenditions(%q) err=%v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRenditions(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		layout    string
+		relDir    string
+		template  string
+		rendition utils.Rendition
+		want      string
+	}{
+		{utils.LayoutDir, "", "", utils.Rendition{Width: 10, Height: 20}, "/out/a_10x20.jpg"},
+		{utils.LayoutDir, "2022/x", "", utils.Rendition{Width: 10, Height: 20}, "/out/2022/x/a_10x20.jpg"},
+		{utils.LayoutDir, "2022/x", "", utils.Rendition{Name: "web", Width: 10}, "/out/web/2022/x/a.jpg"},
+		{utils.LayoutSuffix, "2022/x", "", utils.Rendition{Name: "web", Width: 10}, "/out/2022/x/a_web.jpg"},
+		{utils.LayoutDir, "x", "{dir}/{rendition}/{name}", utils.Rendition{Name: "web", Width: 10}, "/out/x/web/a.jpg"},
+		{utils.LayoutDir, "x", "{name}_{w}x{h}{ext}", utils.Rendition{Width: 10}, "/out/a_10x0.jpg"},
+	}
+	for _, tt := range tests {
+		task := &utils.Task{Name: "a.jpeg", SavePath: "/out", RelDir: tt.relDir, Template: tt.template}
+		task.Resize.Layout = tt.layout
+		got, err := outputPath(task, tt.rendition)
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("outputPath(%v, %v, %v) = %v, %v, want %v", tt.layout, tt.template, tt.rendition, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckCollisions(t *testing.T) {
+	task := func(dir, relDir string) *utils.Task {
+		task := &utils.Task{Path: dir, Name: "IMG_1.jpg", SavePath: "/out", RelDir: relDir}
+		task.Resize.Renditions = []utils.Rendition{{Width: 10}}
+		return task
+	}
+	if err := checkCollisions([]*utils.Task{task("/src/a", "a"), task("/src/b", "b")}); err != nil {
+		t.Errorf("mirrored outputs collide. err=%v", err)
+	}
+	if err := checkCollisions([]*utils.Task{task("/src/a", ""), task("/src/b", "")}); err == nil {
+		t.Errorf("flattened outputs don't collide")
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	portrait := image.NewRGBA(image.Rect(0, 0, 300, 400))
+	tests := []struct {
+		mode         string
+		w, h         uint
+		wantW, wantH int
+		wantErr      bool
+	}{
+		{mode: ModeFit, w: 160, h: 100, wantW: 75, wantH: 100},
+		{mode: ModeFit, w: 150, h: 0, wantW: 150, wantH: 200},
+		{mode: ModeFit, w: 0, h: 0, wantW: 300, wantH: 400},
+		{mode: ModeFill, w: 160, h: 100, wantW: 160, wantH: 100},
+		{mode: ModePad, w: 160, h: 100, wantW: 160, wantH: 100},
+		{mode: ModeExact, w: 160, h: 100, wantW: 160, wantH: 100},
+		{mode: ModeLongEdge, w: 200, wantW: 150, wantH: 200},
+		{mode: ModeShortEdge, w: 0, h: 150, wantW: 150, wantH: 200},
+		{mode: "stretch", w: 10, h: 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resizeImage(tt.w, tt.h, tt.mode, color.White, newScaler(DefaultFilter, false), portrait)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resizeImage(%v, %dx%d) err=%v, wantErr %v", tt.mode, tt.w, tt.h, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.Bounds().Dx() != tt.wantW || got.Bounds().Dy() != tt.wantH {
+			t.Errorf("resizeImage(%v, %dx%d) = %v, want %dx%d", tt.mode, tt.w, tt.h, got.Bounds().Size(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestResizeImagePad(t *testing.T) {
+	red := image.NewUniform(color.RGBA{R: 0xff, A: 0xff})
+	src := image.NewRGBA(image.Rect(0, 0, 100, 200))
+	draw.Draw(src, src.Bounds(), red, image.Point{}, draw.Src)
+	got, err := resizeImage(100, 100, ModePad, color.Black, newScaler(DefaultFilter, false), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := got.At(2, 50).RGBA(); r != 0 {
+		t.Errorf("padding is %v, want black", got.At(2, 50))
+	}
+	if r, _, _, _ := got.At(50, 50).RGBA(); r != 0xffff {
+		t.Errorf("center is %v, want red", got.At(50, 50))
+	}
+}
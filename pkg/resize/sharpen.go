@@ -0,0 +1,121 @@
+package resize
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+// ParseSharpen parses the comma separated amount=, radius= and threshold=
+// options of the unsharp mask, e.g. amount=0.5,radius=1,threshold=2. The
+// amount is the strength, 0.5 for 50%, the radius the standard deviation of
+// the blur in pixels and the threshold, 0 to 255, the smallest difference
+// sharpened. An empty string disables sharpening.
+func ParseSharpen(s string) (utils.Sharpen, error) {
+	sharpen := utils.Sharpen{Radius: 1}
+	if strings.TrimSpace(s) == "" {
+		return utils.Sharpen{}, nil
+	}
+	for _, option := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return sharpen, fmt.Errorf("invalid sharpen option %v. err=%w", option, err)
+		}
+		switch {
+		case key == "amount" && v >= 0:
+			sharpen.Amount = v
+		case key == "radius" && v > 0 && v <= 50:
+			sharpen.Radius = v
+		case key == "threshold" && v >= 0 && v <= 255:
+			sharpen.Threshold = uint8(v)
+		default:
+			return sharpen, fmt.Errorf("invalid sharpen option %v. only amount>=0, radius in ]0,50] or threshold in [0,255]", option)
+		}
+	}
+	return sharpen, nil
+}
+
+// unsharpMask sharpens img: every channel moves away from its gaussian blur
+// by amount, when they differ by more than threshold
+func unsharpMask(img image.Image, s utils.Sharpen) image.Image {
+	if s.Amount <= 0 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	blurred := gaussianBlur(src, s.Radius)
+	dst := image.NewNRGBA(src.Bounds())
+	for i, v := range src.Pix {
+		if i%4 == 3 {
+			// alpha
+			dst.Pix[i] = v
+			continue
+		}
+		diff := float64(v) - blurred[i]
+		if math.Abs(diff) <= float64(s.Threshold) {
+			dst.Pix[i] = v
+			continue
+		}
+		dst.Pix[i] = uint8(math.Max(0, math.Min(255, math.Round(float64(v)+s.Amount*diff))))
+	}
+	return dst
+}
+
+// gaussianBlur returns the blurred channels of img, in the layout of its Pix
+func gaussianBlur(img *image.NRGBA, sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= max {
+			return max - 1
+		}
+		return v
+	}
+	// horizontal then vertical pass
+	tmp := make([]float64, len(img.Pix))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+				var v float64
+				for k, weight := range kernel {
+					v += weight * float64(img.Pix[img.PixOffset(clamp(x+k-radius, w), y)+c])
+				}
+				tmp[img.PixOffset(x, y)+c] = v
+			}
+		}
+	}
+	out := make([]float64, len(img.Pix))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+				var v float64
+				for k, weight := range kernel {
+					v += weight * tmp[img.PixOffset(x, clamp(y+k-radius, h))+c]
+				}
+				out[img.PixOffset(x, y)+c] = v
+			}
+		}
+	}
+	return out
+}
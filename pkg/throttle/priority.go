@@ -0,0 +1,40 @@
+package throttle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// I/O scheduling classes, as used by ionice(1)
+const (
+	IOClassRealtime   = "realtime"
+	IOClassBestEffort = "best-effort"
+	IOClassIdle       = "idle"
+)
+
+var ioClasses = map[string]int{
+	IOClassRealtime:   1,
+	IOClassBestEffort: 2,
+	IOClassIdle:       3,
+}
+
+// ParseIOClass parses class[:level] into the kernel class and level, level
+// defaults to 4 and is ignored by the idle class
+func ParseIOClass(s string) (class, level int, err error) {
+	name, levelStr, hasLevel := strings.Cut(s, ":")
+	class, ok := ioClasses[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown io class %v. only %s, %s or %s", name, IOClassRealtime, IOClassBestEffort, IOClassIdle)
+	}
+	level = 4
+	if class == ioClasses[IOClassIdle] {
+		level = 0
+	} else if hasLevel {
+		level, err = strconv.Atoi(levelStr)
+		if err != nil || level < 0 || level > 7 {
+			return 0, 0, fmt.Errorf("invalid io priority level %v. only 0 (highest) to 7 (lowest)", levelStr)
+		}
+	}
+	return class, level, nil
+}
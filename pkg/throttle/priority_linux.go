@@ -0,0 +1,65 @@
+package throttle
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// SetPriority applies the io class (see ParseIOClass) to every thread of the
+// process and adds nice to their niceness, like nice(1). Threads started
+// later inherit them. An empty ioClass or a 0 nice leaves the current value.
+func SetPriority(ioClass string, nice int) error {
+	ioprio := -1
+	if ioClass != "" {
+		class, level, err := ParseIOClass(ioClass)
+		if err != nil {
+			return err
+		}
+		ioprio = class<<ioprioClassShift | level
+	}
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("unable to list threads. err=%w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if ioprio >= 0 {
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio)); errno != 0 {
+				return fmt.Errorf("unable to set io priority %v. err=%w", ioClass, errno)
+			}
+		}
+		if nice != 0 {
+			// the raw syscall returns 20 - niceness
+			prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+			if err != nil {
+				return fmt.Errorf("unable to read nice. err=%w", err)
+			}
+			value := clampNice(20 - prio + nice)
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, value); err != nil {
+				return fmt.Errorf("unable to set nice %d. err=%w", value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clampNice bounds a niceness to the -20..19 range of the kernel
+func clampNice(nice int) int {
+	switch {
+	case nice < -20:
+		return -20
+	case nice > 19:
+		return 19
+	}
+	return nice
+}
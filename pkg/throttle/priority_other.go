@@ -0,0 +1,20 @@
+//go:build !linux
+
+package throttle
+
+import (
+	"errors"
+)
+
+// SetPriority is only supported on linux
+func SetPriority(ioClass string, nice int) error {
+	if ioClass == "" && nice == 0 {
+		return nil
+	}
+	if ioClass != "" {
+		if _, _, err := ParseIOClass(ioClass); err != nil {
+			return err
+		}
+	}
+	return errors.New("io class and nice are only supported on linux")
+}
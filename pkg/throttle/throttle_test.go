@@ -0,0 +1,48 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 96*1024)
+	var out bytes.Buffer
+	start := time.Now()
+	// the first 64K are the burst, the next 32K take half a second
+	w := NewWriter(context.Background(), &out, NewLimiter(64*1024))
+	if _, err := io.Copy(w, NewReader(context.Background(), bytes.NewReader(data), nil)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Write() wrote %d bytes, want %d", out.Len(), len(data))
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Write() took %v, want about 500ms", elapsed)
+	}
+}
+
+func TestParseIOClass(t *testing.T) {
+	tests := []struct {
+		in        string
+		wantClass int
+		wantLevel int
+		wantErr   bool
+	}{
+		{"idle", 3, 0, false},
+		{"best-effort", 2, 4, false},
+		{"best-effort:7", 2, 7, false},
+		{"realtime:0", 1, 0, false},
+		{"best-effort:8", 0, 0, true},
+		{"lowest", 0, 0, true},
+	}
+	for _, tt := range tests {
+		class, level, err := ParseIOClass(tt.in)
+		if (err != nil) != tt.wantErr || class != tt.wantClass || level != tt.wantLevel {
+			t.Errorf("ParseIOClass(%q) = %d, %d, %v", tt.in, class, level, err)
+		}
+	}
+}
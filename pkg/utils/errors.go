@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FileError is a failure to process a single file
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// PartialError is returned by a run that went through but failed on some files
+type PartialError struct {
+	Total  int
+	Failed []*FileError
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d of %d files failed", len(e.Failed), e.Total)
+}
+
+// ErrorCollector aggregates the file errors of concurrent workers
+type ErrorCollector struct {
+	mutex  sync.Mutex
+	errors []*FileError
+}
+
+func (c *ErrorCollector) Add(filePath string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.errors = append(c.errors, &FileError{Path: filePath, Err: err})
+}
+
+func (c *ErrorCollector) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.errors)
+}
+
+// Failed returns the errors collected so far
+func (c *ErrorCollector) Failed() []*FileError {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	failed := make([]*FileError, len(c.errors))
+	copy(failed, c.errors)
+	return failed
+}
+
+// Err returns a *PartialError if any error was collected, nil otherwise
+func (c *ErrorCollector) Err(total int) error {
+	failed := c.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return &PartialError{Total: total, Failed: failed}
+}
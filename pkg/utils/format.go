@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vfoucault/goPhoto/pkg/codec"
+	"golang.org/x/image/tiff"
+)
+
+// Output formats, FormatAuto keeps the format of the source
+const (
+	FormatAuto = "auto"
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatTIFF = "tiff"
+	FormatWebP = "webp"
+)
+
+// Format is an output format and its encoder options
+type Format struct {
+	Name string
+	// Quality of JPEG and lossy WebP, 1 to 100
+	Quality int
+	// Subsampling of the JPEG chroma
+	Subsampling codec.Subsampling
+	// Progressive JPEG
+	Progressive bool
+	// Lossless WebP
+	Lossless bool
+	// PNGCompression of PNG
+	PNGCompression png.CompressionLevel
+	// TIFFCompression of TIFF
+	TIFFCompression tiff.CompressionType
+	// Colors of GIF, 1 to 256
+	Colors int
+}
+
+// ParseFormat parses a format name and its comma separated key=value options:
+//
+//	jpeg  quality=1..100 (90), subsampling=444|422|420 (420), progressive
+//	png   compression=default|none|fast|best
+//	gif   colors=1..256 (256)
+//	tiff  compression=deflate|none (deflate)
+//	webp  quality=1..100 (90), lossless
+//
+// Options of the other formats are ignored so that one set serves FormatAuto.
+func ParseFormat(name, options string) (Format, error) {
+	f := Format{Name: strings.ToLower(name), Quality: 90, Colors: 256, TIFFCompression: tiff.Deflate}
+	switch f.Name {
+	case "jpg":
+		f.Name = FormatJPEG
+	case "tif":
+		f.Name = FormatTIFF
+	case FormatAuto, FormatJPEG, FormatPNG, FormatGIF, FormatTIFF, FormatWebP:
+	default:
+		return f, fmt.Errorf("unknown format %v. only %s, %s, %s, %s, %s or %s", name, FormatAuto, FormatJPEG, FormatPNG, FormatGIF, FormatTIFF, FormatWebP)
+	}
+	for _, option := range strings.Split(options, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		var err error
+		switch key {
+		case "quality":
+			f.Quality, err = parseRange(value, 1, 100)
+		case "subsampling":
+			err = f.parseSubsampling(value)
+		case "progressive":
+			f.Progressive, err = parseFlag(value)
+		case "lossless":
+			f.Lossless, err = parseFlag(value)
+		case "colors":
+			f.Colors, err = parseRange(value, 1, 256)
+		case "compression":
+			err = f.parseCompression(value)
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return f, fmt.Errorf("invalid format option %v. err=%w", option, err)
+		}
+	}
+	return f, nil
+}
+
+func parseRange(value string, min, max int) (int, error) {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d not between %d and %d", v, min, max)
+	}
+	return v, nil
+}
+
+// parseFlag parses the value of a boolean option, true when omitted
+func parseFlag(value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func (f *Format) parseSubsampling(value string) error {
+	switch value {
+	case "444":
+		f.Subsampling = codec.Subsample444
+	case "422":
+		f.Subsampling = codec.Subsample422
+	case "420":
+		f.Subsampling = codec.Subsample420
+	default:
+		return fmt.Errorf("only 444, 422 or 420")
+	}
+	return nil
+}
+
+// parseCompression sets the PNG or TIFF compression, deflate and none apply to both
+func (f *Format) parseCompression(value string) error {
+	switch value {
+	case "default":
+		f.PNGCompression = png.DefaultCompression
+	case "fast":
+		f.PNGCompression = png.BestSpeed
+	case "best", "deflate":
+		f.PNGCompression = png.BestCompression
+		f.TIFFCompression = tiff.Deflate
+	case "none":
+		f.PNGCompression = png.NoCompression
+		f.TIFFCompression = tiff.Uncompressed
+	default:
+		return fmt.Errorf("only default, fast, best, deflate or none")
+	}
+	return nil
+}
+
+// For returns the format of the output of fileName, resolving FormatAuto
+// from its extension
+func (f Format) For(fileName string) Format {
+	if f.Name != FormatAuto && f.Name != "" {
+		return f
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".png":
+		f.Name = FormatPNG
+	case ".gif":
+		f.Name = FormatGIF
+	case ".tif", ".tiff":
+		f.Name = FormatTIFF
+	case ".webp":
+		f.Name = FormatWebP
+	default:
+		f.Name = FormatJPEG
+	}
+	return f
+}
+
+// Ext returns the file extension of the format
+func (f Format) Ext() string {
+	switch f.Name {
+	case FormatPNG:
+		return ".png"
+	case FormatGIF:
+		return ".gif"
+	case FormatTIFF:
+		return ".tiff"
+	case FormatWebP:
+		return ".webp"
+	}
+	return ".jpg"
+}
+
+// Encode writes img in the format, FormatAuto writes a JPEG
+func (f Format) Encode(w io.Writer, img image.Image) error {
+	switch f.Name {
+	case FormatPNG:
+		encoder := png.Encoder{CompressionLevel: f.PNGCompression}
+		return encoder.Encode(w, img)
+	case FormatGIF:
+		return gif.Encode(w, img, &gif.Options{NumColors: f.Colors})
+	case FormatTIFF:
+		return tiff.Encode(w, img, &tiff.Options{Compression: f.TIFFCompression, Predictor: f.TIFFCompression == tiff.Deflate})
+	case FormatWebP:
+		return codec.EncodeWebP(w, img, &codec.WebPOptions{Quality: f.Quality, Lossless: f.Lossless})
+	}
+	return codec.EncodeJPEG(w, img, &codec.JPEGOptions{Quality: f.Quality, Subsampling: f.Subsampling, Progressive: f.Progressive})
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"testing"
+
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name, options string
+		wantErr       bool
+	}{
+		{name: "auto"},
+		{name: "JPG", options: "quality=75, colors=8"},
+		{name: "png", options: "compression=best"},
+		{name: "tif", options: "compression=none"},
+		{name: "gif", options: "colors=16"},
+		{name: "webp"},
+		{name: "bmp", wantErr: true},
+		{name: "jpeg", options: "quality=0", wantErr: true},
+		{name: "jpeg", options: "progressive, subsampling=444"},
+		{name: "jpeg", options: "progressive=no", wantErr: true},
+		{name: "jpeg", options: "subsampling=411", wantErr: true},
+		{name: "webp", options: "quality=60"},
+		{name: "webp", options: "lossless"},
+		{name: "png", options: "compression=max", wantErr: true},
+		{name: "gif", options: "dither", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseFormat(tt.name, tt.options); (err != nil) != tt.wantErr {
+			t.Errorf("ParseFormat(%v, %v) err=%v, wantErr %v", tt.name, tt.options, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	auto, _ := ParseFormat(FormatAuto, "")
+	png, _ := ParseFormat(FormatPNG, "")
+	tests := []struct {
+		format   Format
+		fileName string
+		want     string
+	}{
+		{auto, "a.JPEG", ".jpg"},
+		{auto, "logo.png", ".png"},
+		{auto, "scan.tif", ".tiff"},
+		{auto, "anim.gif", ".gif"},
+		{png, "a.jpg", ".png"},
+	}
+	for _, tt := range tests {
+		if got := tt.format.For(tt.fileName).Ext(); got != tt.want {
+			t.Errorf("%v.For(%v).Ext() = %v, want %v", tt.format.Name, tt.fileName, got, tt.want)
+		}
+	}
+}
+
+func TestFormatEncode(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 6))
+	img.Set(1, 1, color.NRGBA{R: 0xff, A: 0x80})
+	for _, name := range []string{FormatJPEG, FormatPNG, FormatGIF, FormatTIFF, FormatWebP} {
+		f, err := ParseFormat(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := f.Encode(&buf, img); err != nil {
+			t.Errorf("Encode(%v) err=%v", name, err)
+			continue
+		}
+		decoded, format, err := image.Decode(&buf)
+		if err != nil {
+			t.Errorf("Encode(%v) output does not decode. err=%v", name, err)
+			continue
+		}
+		if format != name || decoded.Bounds() != img.Bounds() {
+			t.Errorf("Encode(%v) decoded as %v %v", name, format, decoded.Bounds())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"image"
+	"os"
+
+	"github.com/fogleman/gg"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// LoadImage decodes an image and rotates or flips it upright according to
+// its EXIF orientation
+func LoadImage(imagePath string) (image.Image, error) {
+	img, err := gg.LoadImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	return Orient(img, ReadOrientation(imagePath)), nil
+}
+
+// ReadOrientation returns the EXIF orientation of an image, 1 when it has none
+func ReadOrientation(imagePath string) int {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	x, err := exif.Decode(f)
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// Orient applies the transform of an EXIF orientation to img, so that the
+// result is displayed upright with orientation 1
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if orientation >= 5 {
+		// 5 to 8 swap width and height
+		dw, dh = sh, sw
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = sw-1-x, y
+			case 3: // upside down
+				sx, sy = sw-1-x, sh-1-y
+			case 4: // upside down, mirrored
+				sx, sy = x, sh-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotate 90 CW
+				sx, sy = y, sh-1-x
+			case 7: // transversed
+				sx, sy = sw-1-y, sh-1-x
+			case 8: // rotate 90 CCW
+				sx, sy = sw-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	// 3x2 image with a marked top-left pixel
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	mark := color.RGBA{R: 0xff, A: 0xff}
+	src.Set(0, 0, mark)
+	tests := []struct {
+		orientation int
+		w, h        int
+		// where the marked pixel ends up once upright
+		x, y int
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	}
+	for _, tt := range tests {
+		got := Orient(src, tt.orientation)
+		if got.Bounds().Dx() != tt.w || got.Bounds().Dy() != tt.h {
+			t.Errorf("Orient(%d) size = %v, want %dx%d", tt.orientation, got.Bounds().Size(), tt.w, tt.h)
+			continue
+		}
+		if got.At(tt.x, tt.y) != color.Color(mark) {
+			t.Errorf("Orient(%d) mark not at %d,%d", tt.orientation, tt.x, tt.y)
+		}
+	}
+}
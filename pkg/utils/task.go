@@ -1,14 +1,54 @@
 package utils
 
-import "image/color"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"path"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/manifest"
+	"github.com/vfoucault/goPhoto/pkg/metadata"
+)
+
+const (
+	// LayoutDir writes each named rendition to its own subdirectory
+	LayoutDir = "dir"
+	// LayoutSuffix appends the rendition name to the file name
+	LayoutSuffix = "suffix"
+)
+
+// Rendition is one output size of a resized photo, an unnamed rendition is
+// saved as <name>_<w>x<h>.jpg
+type Rendition struct {
+	Name          string
+	Width, Height uint
+}
+
+// Sharpen is an unsharp mask, disabled when Amount is 0
+type Sharpen struct {
+	Amount    float64
+	Radius    float64
+	Threshold uint8
+}
 
 type Task struct {
 	Path     string
 	Name     string
 	SavePath string
 	Resize   struct {
-		Enabled       bool
-		Width, Height uint
+		Enabled    bool
+		Renditions []Rendition
+		Layout     string
+		Mode       string
+		// Background pads the photos in pad mode
+		Background color.Color
+		// MaxBytes bounds the size of the output
+		MaxBytes int64
+		Filter   string
+		Linear   bool
+		Sharpen  Sharpen
 	}
 	Watermark struct {
 		Enabled bool
@@ -16,4 +56,64 @@ type Task struct {
 		Size    float64
 		Text    string
 	}
+	// Strip selects the metadata not copied to the output
+	Strip metadata.Strip
+	// Format of the output, FormatAuto keeps the format of the source
+	Format Format
+	// RelDir is the directory of the source relative to the source root,
+	// empty when the output is flattened
+	RelDir string
+	// Template names the output below SavePath, see ExpandTemplate
+	Template string
+	// Derived skips the outputs already generated with the same source and
+	// parameters, nil generates every output
+	Derived *manifest.Derived
+}
+
+// params identifies the processing of the task, with extra per output
+// parameters
+func (t *Task) params(extra ...interface{}) string {
+	resize := t.Resize
+	resize.Renditions = nil
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%+v|%+v|%+v|%+v|%+v", resize, t.Watermark, t.Strip, t.Format, extra)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Stale tells whether output must be generated, and returns the entry to
+// record once it is
+func (t *Task) Stale(output string, extra ...interface{}) (manifest.DerivedEntry, bool, error) {
+	if t.Derived == nil {
+		return manifest.DerivedEntry{}, true, nil
+	}
+	e, err := manifest.SourceEntry(path.Join(t.Path, t.Name), t.params(extra...))
+	if err != nil {
+		return e, true, err
+	}
+	return e, !t.Derived.UpToDate(output, e), nil
+}
+
+// Generated records an output made from the task source
+func (t *Task) Generated(output string, e manifest.DerivedEntry) error {
+	if t.Derived == nil {
+		return nil
+	}
+	return t.Derived.Record(output, e)
+}
+
+// UpdateDerived prunes the outputs of deleted sources when prune is set,
+// and saves the derived manifest. Nothing is done when derived is nil.
+func UpdateDerived(derived *manifest.Derived, prune bool) error {
+	if derived == nil {
+		return nil
+	}
+	if prune {
+		pruned, err := derived.Prune()
+		for _, output := range pruned {
+			log.Infof("pruned %s", output)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return derived.Write()
 }
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// ExpandTemplate replaces the {placeholders} of an output file name template
+// by vars. The extension is appended when the template has no {ext}. The
+// result is a slash separated path that must stay below the destination.
+func ExpandTemplate(template string, vars map[string]string) (string, error) {
+	var err error
+	expanded := placeholder.ReplaceAllStringFunc(template, func(p string) string {
+		value, ok := vars[strings.Trim(p, "{}")]
+		if !ok && err == nil {
+			err = fmt.Errorf("unknown placeholder %v in output template %v", p, template)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(template, "{ext}") {
+		expanded += vars["ext"]
+	}
+	expanded = path.Clean("/" + filepath.ToSlash(expanded))[1:]
+	if expanded == "" || strings.HasSuffix(expanded, "/") || path.Base(expanded) == vars["ext"] {
+		return "", fmt.Errorf("output template %v gives no file name", template)
+	}
+	return expanded, nil
+}
+
+// RelDir returns the slash separated directory of aPath relative to root,
+// empty for root
+func RelDir(root, aPath string) string {
+	rel, err := filepath.Rel(root, filepath.Dir(aPath))
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// CheckCollisions returns an error naming the outputs written from several
+// sources, and the outputs that would overwrite a source. outputs maps the
+// output paths to their sources.
+func CheckCollisions(outputs map[string][]string, sources []string) error {
+	isSource := make(map[string]bool, len(sources))
+	for _, s := range sources {
+		if abs, err := filepath.Abs(s); err == nil {
+			isSource[abs] = true
+		}
+	}
+	var problems []string
+	for output, from := range outputs {
+		abs, err := filepath.Abs(output)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%v: %v", output, err))
+		case len(from) > 1:
+			sort.Strings(from)
+			problems = append(problems, fmt.Sprintf("%v written from %v", output, strings.Join(from, ", ")))
+		case isSource[abs]:
+			problems = append(problems, fmt.Sprintf("%v overwrites its source", output))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("output collisions, change the output template: %s", strings.Join(problems, "; "))
+}
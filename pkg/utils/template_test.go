@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	vars := map[string]string{"name": "IMG_1", "ext": ".jpg", "dir": "2022/a", "rendition": "web", "w": "1600", "h": "1064"}
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "{dir}/{name}_{w}x{h}{ext}", want: "2022/a/IMG_1_1600x1064.jpg"},
+		{template: "{rendition}/{name}", want: "web/IMG_1.jpg"},
+		{template: "{rendition}/{dir}/{name}{ext}", want: "web/2022/a/IMG_1.jpg"},
+		{template: "../../{name}", want: "IMG_1.jpg"},
+		{template: "/etc/{name}", want: "etc/IMG_1.jpg"},
+		{template: "{name}-{size}", wantErr: true},
+		{template: "{dir}/", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ExpandTemplate(tt.template, vars)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ExpandTemplate(%v) = %v, %v, want %v", tt.template, got, err, tt.want)
+		}
+	}
+	// flattened, no directory
+	vars["dir"] = ""
+	if got, _ := ExpandTemplate("{dir}/{name}{ext}", vars); got != "IMG_1.jpg" {
+		t.Errorf("empty dir gave %v", got)
+	}
+}
+
+func TestCheckCollisions(t *testing.T) {
+	if err := CheckCollisions(map[string][]string{"out/a.jpg": {"a/a.jpg"}, "out/b.jpg": {"b.jpg"}}, []string{"a/a.jpg", "b.jpg"}); err != nil {
+		t.Errorf("no collision, got %v", err)
+	}
+	if err := CheckCollisions(map[string][]string{"out/a.jpg": {"a/a.jpg", "b/a.png"}}, nil); err == nil {
+		t.Errorf("two sources for one output accepted")
+	}
+	if err := CheckCollisions(map[string][]string{"a.jpg": {"a.jpg"}}, []string{"a.jpg"}); err == nil {
+		t.Errorf("overwritten source accepted")
+	}
+}
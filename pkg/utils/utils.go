@@ -1,13 +1,14 @@
 package utils
 
 import (
+	"bytes"
 	"image"
 	"os"
 	"path"
 	"regexp"
 
-	"github.com/fogleman/gg"
 	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/metadata"
 )
 
 var (
@@ -24,7 +25,30 @@ func IsImage(file os.FileInfo) bool {
 	return false
 }
 
-func SaveImage(filePath, fileName string, img image.Image) error {
+// SaveImage writes img in format, JPEGs get the metadata of their source
+// when meta is not nil
+func SaveImage(filePath, fileName string, img image.Image, format Format, meta *metadata.Metadata) error {
+	data, err := EncodeImage(img, format, meta)
+	if err != nil {
+		return err
+	}
+	return WriteImage(filePath, fileName, data)
+}
+
+// EncodeImage encodes img as SaveImage does
+func EncodeImage(img image.Image, format Format, meta *metadata.Metadata) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := format.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if format.Ext() != ".jpg" {
+		return buf.Bytes(), nil
+	}
+	return meta.Embed(buf.Bytes(), img.Bounds().Dx(), img.Bounds().Dy())
+}
+
+// WriteImage writes an encoded image
+func WriteImage(filePath, fileName string, data []byte) error {
 	log.Infof("saving image %s", path.Join(filePath, fileName))
-	return gg.SaveJPG(path.Join(filePath, fileName), img, 90)
+	return os.WriteFile(path.Join(filePath, fileName), data, 0640)
 }
@@ -0,0 +1,239 @@
+package verify
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/manifest"
+	"github.com/vfoucault/goPhoto/pkg/photo"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+type Status string
+
+const (
+	StatusOK         Status = "ok"
+	StatusMissing    Status = "missing"
+	StatusChanged    Status = "changed"
+	StatusUnexpected Status = "unexpected"
+	StatusError      Status = "error"
+)
+
+type FileResult struct {
+	Path     string `json:"path"`
+	Status   Status `json:"status"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Root      string         `json:"root"`
+	Algorithm string         `json:"algorithm"`
+	StartedAt time.Time      `json:"started_at"`
+	Duration  string         `json:"duration"`
+	Summary   map[Status]int `json:"summary"`
+	Files     []FileResult   `json:"files"`
+}
+
+// Err returns a *utils.PartialError listing the files not in the expected state
+func (r *Report) Err() error {
+	errs := &utils.ErrorCollector{}
+	for _, f := range r.Files {
+		switch f.Status {
+		case StatusOK:
+		case StatusError:
+			errs.Add(f.Path, errors.New(f.Error))
+		default:
+			errs.Add(f.Path, errors.New(string(f.Status)))
+		}
+	}
+	return errs.Err(len(r.Files))
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type Verifier struct {
+	Root     string
+	Expected *manifest.Manifest
+	Workers  int
+	// Exclude holds slash separated paths, relative to Root, that are not reported as unexpected
+	Exclude map[string]bool
+	// Filter restricts the files looked at in Root. nil means every regular file
+	Filter func(os.FileInfo) bool
+	// Scope tells whether a file that is not expected, given its slash
+	// separated path relative to Root, is reported as unexpected. nil means
+	// every file
+	Scope func(rel string) bool
+	// SourceErrors are the files of the source that could not be read, they
+	// are reported as errors
+	SourceErrors []*utils.FileError
+}
+
+// Run hashes every expected file found under Root with Workers goroutines
+// and reports missing, changed and unexpected files.
+func (v *Verifier) Run(ctx context.Context) (*Report, error) {
+	report := &Report{
+		Root:      v.Root,
+		Algorithm: v.Expected.Algorithm,
+		StartedAt: time.Now(),
+		Summary:   make(map[Status]int),
+	}
+
+	found := make(map[string]bool)
+	err := filepath.Walk(v.Root, func(aPath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.Mode().IsRegular() || (v.Filter != nil && !v.Filter(f)) {
+			return nil
+		}
+		rel, err := filepath.Rel(v.Root, aPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !v.Exclude[rel] {
+			found[rel] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to walk %v. err=%v", v.Root, err.Error())
+	}
+
+	var toHash []string
+	for rel := range found {
+		if _, ok := v.Expected.Entries[rel]; ok {
+			toHash = append(toHash, rel)
+		} else if v.Scope == nil || v.Scope(rel) {
+			report.Files = append(report.Files, FileResult{Path: rel, Status: StatusUnexpected})
+		}
+	}
+	for rel, sum := range v.Expected.Entries {
+		if !found[rel] {
+			report.Files = append(report.Files, FileResult{Path: rel, Status: StatusMissing, Expected: sum})
+		}
+	}
+	for _, e := range v.SourceErrors {
+		report.Files = append(report.Files, FileResult{Path: e.Path, Status: StatusError, Error: e.Err.Error()})
+	}
+
+	jobs := make(chan string, len(toHash))
+	for _, rel := range toHash {
+		jobs <- rel
+	}
+	close(jobs)
+
+	results := make(chan FileResult, len(toHash))
+	workers := v.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				results <- v.check(rel)
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	for res := range results {
+		report.Files = append(report.Files, res)
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Path < report.Files[j].Path
+	})
+	for _, res := range report.Files {
+		report.Summary[res.Status] += 1
+	}
+	report.Duration = time.Since(report.StartedAt).String()
+	return report, nil
+}
+
+func (v *Verifier) check(rel string) FileResult {
+	res := FileResult{Path: rel, Expected: v.Expected.Entries[rel]}
+	sum, err := manifest.HashFile(filepath.Join(v.Root, filepath.FromSlash(rel)), v.Expected.Algorithm)
+	switch {
+	case err != nil:
+		res.Status = StatusError
+		res.Error = err.Error()
+	case sum != res.Expected:
+		res.Status = StatusChanged
+		res.Actual = sum
+	default:
+		res.Status = StatusOK
+	}
+	log.Debugf("verified %v: %v", rel, res.Status)
+	return res
+}
+
+// ExpectedFromSource searches cfg.SourceDirectory and groups the photos into
+// sets and events like the copier does, and returns where each photo should
+// be in cfg.DestDirectory with its md5. The source files that could not be
+// read are returned as a *utils.PartialError along with the manifest.
+func ExpectedFromSource(ctx context.Context, cfg *config.Config) (*manifest.Manifest, error) {
+	copier := photo.NewCopier(cfg, ctx)
+	defer copier.Stop()
+	if err := copier.Search(); err != nil {
+		return nil, err
+	}
+	if err := copier.Cluster(); err != nil {
+		return nil, err
+	}
+	m := manifest.New(manifest.MD5)
+	for _, p := range copier.Photos {
+		if p.File != nil {
+			p.File.Close()
+		}
+		rel, err := filepath.Rel(cfg.DestDirectory, filepath.Join(p.GetTargetPath(), p.FileName))
+		if err != nil {
+			return nil, err
+		}
+		m.Entries[filepath.ToSlash(rel)] = hex.EncodeToString(p.Md5)
+	}
+	failed := append(copier.Errors.Failed(), copier.WalkErrors.Failed()...)
+	if len(failed) > 0 {
+		return m, &utils.PartialError{Total: len(copier.Photos) + copier.Errors.Len(), Failed: failed}
+	}
+	return m, nil
+}
+
+// InExpectedDirs returns a Scope limited to the directories holding the files
+// of m, other directories may hold photos from other sources
+func InExpectedDirs(m *manifest.Manifest) func(rel string) bool {
+	dirs := make(map[string]bool)
+	for rel := range m.Entries {
+		dirs[path.Dir(rel)] = true
+	}
+	return func(rel string) bool {
+		return dirs[path.Dir(rel)]
+	}
+}
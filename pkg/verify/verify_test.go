@@ -0,0 +1,142 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/config"
+	"github.com/vfoucault/goPhoto/pkg/manifest"
+	"github.com/vfoucault/goPhoto/pkg/photo"
+	"github.com/vfoucault/goPhoto/pkg/utils"
+)
+
+func TestRunScope(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"bagit.txt":                      "BagIt-Version: 1.0\n",
+		"bag-info.txt":                   "Payload-Oxum: 1.1\n",
+		"tagmanifest-md5.txt":            "",
+		"data/2022/2022-01-02/a.jpg":     "a",
+		"data/2022/2022-01-02/other.jpg": "from another import",
+		"data/2021/2021-05-06/b.jpg":     "from another import",
+	}
+	for name, content := range files {
+		filePath := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(filePath), 0750)
+		if err := os.WriteFile(filePath, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := manifest.HashFile(filepath.Join(root, "data/2022/2022-01-02/a.jpg"), manifest.MD5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := manifest.New(manifest.MD5)
+	expected.Entries["data/2022/2022-01-02/a.jpg"] = sum
+
+	tests := []struct {
+		name           string
+		scope          func(string) bool
+		wantUnexpected int
+	}{
+		{"everywhere", nil, 5},
+		{"bag", func(rel string) bool { return !manifest.IsBagTagFile(rel) }, 2},
+		{"expected dirs", InExpectedDirs(expected), 1},
+	}
+	for _, tt := range tests {
+		report, err := (&Verifier{Root: root, Expected: expected, Scope: tt.scope}).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Summary[StatusOK] != 1 || report.Summary[StatusUnexpected] != tt.wantUnexpected {
+			t.Errorf("%v: Run() summary = %v, want 1 ok and %d unexpected", tt.name, report.Summary, tt.wantUnexpected)
+		}
+	}
+	if !manifest.IsBag(root) {
+		t.Errorf("IsBag() = false")
+	}
+}
+
+// writeTestJPEG writes a small JPEG holding an EXIF DateTime tag
+func writeTestJPEG(t *testing.T, filePath, dateTime string) {
+	t.Helper()
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(tiff, binary.BigEndian, uint32(8))
+	binary.Write(tiff, binary.BigEndian, uint16(1))
+	binary.Write(tiff, binary.BigEndian, uint16(0x0132))
+	binary.Write(tiff, binary.BigEndian, uint16(2))
+	binary.Write(tiff, binary.BigEndian, uint32(len(dateTime)+1))
+	binary.Write(tiff, binary.BigEndian, uint32(8+2+12+4))
+	binary.Write(tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString(dateTime + "\x00")
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(img.Bytes()[2:])
+	if err := os.WriteFile(filePath, out.Bytes(), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpectedFromSource(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	writeTestJPEG(t, filepath.Join(srcDir, "img001.jpg"), "2022:04:30 10:00:00")
+	writeTestJPEG(t, filepath.Join(srcDir, "img002.jpg"), "2022:04:30 11:00:00")
+	// no EXIF, it cannot be copied
+	if err := os.WriteFile(filepath.Join(srcDir, "broken.jpg"), []byte("not a jpeg"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := &config.Config{
+		SourceDirectory: srcDir,
+		DestDirectory:   dstDir,
+		DestFileFormat:  "2006/{event}",
+		EventGap:        4 * time.Hour,
+		BagIt:           true,
+	}
+	if _, err := photo.Copy(context.Background(), photo.Options{Config: cfg, Logger: logger}); err == nil {
+		t.Fatalf("Copy() err=nil, want broken.jpg to fail")
+	}
+
+	expected, err := ExpectedFromSource(context.Background(), cfg)
+	var partial *utils.PartialError
+	if !errors.As(err, &partial) || len(partial.Failed) != 1 || partial.Total != 3 {
+		t.Fatalf("ExpectedFromSource() err=%v, want broken.jpg to fail", err)
+	}
+	for rel := range expected.Entries {
+		if _, err := os.Stat(filepath.Join(dstDir, filepath.FromSlash(rel))); err != nil || filepath.Dir(filepath.Dir(rel)) != "data/2022" {
+			t.Errorf("ExpectedFromSource() expects %v, not copied there", rel)
+		}
+	}
+
+	verifier := &Verifier{Root: dstDir, Expected: expected, Filter: utils.IsImage, SourceErrors: partial.Failed}
+	report, err := verifier.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 3 || report.Summary[StatusOK] != 2 || report.Summary[StatusError] != 1 {
+		t.Errorf("Run() summary = %v, want 2 ok and 1 error", report.Summary)
+	}
+}
@@ -1,19 +1,21 @@
 package watermark
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
-	"sync"
 
 	"github.com/flopp/go-findfont"
 	"github.com/fogleman/gg"
 	log "github.com/sirupsen/logrus"
+	"github.com/vfoucault/goPhoto/pkg/engine"
+	"github.com/vfoucault/goPhoto/pkg/manifest"
+	"github.com/vfoucault/goPhoto/pkg/metadata"
 	"github.com/vfoucault/goPhoto/pkg/utils"
 )
 
@@ -23,74 +25,166 @@ type WaterMark struct {
 	Text  string
 }
 
-func AddWatermarkToImage(srcPath, dstPath string, addText bool, wm ...WaterMark) error {
-	// list all images
-	tasks := make(chan *utils.Task, runtime.NumCPU())
-	// Launch workers
-	wg := &sync.WaitGroup{}
-	for i := 0; i < runtime.NumCPU(); i++ {
-		go func() {
-			for {
-				task := <-tasks
-				//log.Infof("Will have to run task for task %s", task.Name)
-				hasErrors := ProcessTask(task)
-				if hasErrors {
-					log.Errorf("processing %s led to errors. check logs.", task.Name)
-				}
-				wg.Done()
-			}
-		}()
-	}
-	filepath.Walk(srcPath, func(aPath string, f os.FileInfo, _ error) error {
-		if utils.IsImage(f) {
-			// create Task struct
-			task := &utils.Task{
-				Path:     strings.TrimSuffix(aPath, f.Name()),
-				Name:     f.Name(),
-				SavePath: dstPath,
-				Watermark: struct {
-					Enabled bool
-					Color   color.Gray16
-					Size    float64
-					Text    string
-				}{
-					Enabled: addText,
-					Color:   wm[0].Color,
-					Size:    wm[0].Size,
-					Text:    wm[0].Text,
-				},
-			}
-			tasks <- task
-			wg.Add(1)
+// Options of AddWatermarkToImage
+type Options struct {
+	// Strip selects the metadata not copied from the sources
+	Strip metadata.Strip
+	// Format of the outputs
+	Format utils.Format
+	// Template names the outputs, {dir}/{name}{ext} by default
+	Template string
+	// Flatten writes every output to the destination instead of mirroring
+	// the source tree
+	Flatten bool
+	// Incremental skips the outputs up to date in the derived manifest of
+	// the destination
+	Incremental bool
+	// Prune removes the outputs of deleted sources, when Incremental
+	Prune bool
+	// Engine bounds the workers and their memory
+	Engine engine.Options
+}
+
+// AddWatermarkToImage watermarks every photo of srcPath into dstPath, below
+// the same subdirectory unless flattened. Nothing is written when two
+// outputs collide. The photos not processed yet are skipped once ctx is
+// done.
+func AddWatermarkToImage(ctx context.Context, srcPath, dstPath string, opts Options, addText bool, wm ...WaterMark) error {
+	if opts.Template != "" {
+		if _, err := utils.ExpandTemplate(opts.Template, templateVars(&utils.Task{Name: "a.jpg"})); err != nil {
+			return err
 		}
-		return nil
+	}
+	var derived *manifest.Derived
+	if opts.Incremental {
+		var err error
+		if derived, err = manifest.ReadDerived(dstPath); err != nil {
+			return err
+		}
+	}
+	errs := &utils.ErrorCollector{}
+	found, err := engine.Collect(ctx, srcPath, errs, func(aPath string, f os.FileInfo) *utils.Task {
+		task := &utils.Task{
+			Path:     strings.TrimSuffix(aPath, f.Name()),
+			Name:     f.Name(),
+			SavePath: dstPath,
+			Template: opts.Template,
+			Derived:  derived,
+			Watermark: struct {
+				Enabled bool
+				Color   color.Gray16
+				Size    float64
+				Text    string
+			}{
+				Enabled: addText,
+				Color:   wm[0].Color,
+				Size:    wm[0].Size,
+				Text:    wm[0].Text,
+			},
+		}
+		task.Strip = opts.Strip
+		task.Format = opts.Format
+		if !opts.Flatten {
+			task.RelDir = utils.RelDir(srcPath, aPath)
+		}
+		return task
 	})
-	wg.Wait()
-	return nil
+	if err != nil {
+		return err
+	}
+	total := len(found) + errs.Len()
+	if err := checkCollisions(found); err != nil {
+		return err
+	}
+	results := engine.Run(ctx, found, opts.Engine, ProcessTask)
+	engine.Failed(results, errs)
+	// keep what was generated before an interruption
+	if err := utils.UpdateDerived(derived, opts.Prune && ctx.Err() == nil); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("watermark interrupted. err=%w", err)
+	}
+	return errs.Err(total)
 }
 
-func ProcessTask(task *utils.Task) bool {
+// ProcessTask watermarks the photo of the task
+func ProcessTask(ctx context.Context, task *utils.Task) error {
 	imagePath := path.Join(task.Path, task.Name)
-	img, err := gg.LoadImage(imagePath)
-	var hasErrors bool
+	output, err := outputPath(task)
+	if err != nil {
+		return err
+	}
+	entry, stale, err := task.Stale(output)
+	if err != nil {
+		return err
+	}
+	if !stale {
+		log.Infof("%s is up to date", imagePath)
+		return nil
+	}
+	img, err := utils.LoadImage(imagePath)
 	if err != nil {
-		log.Errorf("unable to load image %s. err=%w", imagePath, err.Error())
-		hasErrors = true
+		return fmt.Errorf("unable to load image %s. err=%w", imagePath, err)
+	}
+	meta, err := metadata.Read(imagePath, task.Strip)
+	if err != nil {
+		return err
 	}
 	if task.Watermark.Enabled {
 		log.Infof("Adding watermark %s to image %s", task.Watermark.Text, imagePath)
 		img, err = AddWatermark(img, task.Watermark.Text, task.Watermark.Color, task.Watermark.Size)
 		if err != nil {
-			log.Errorf("unable to add watermark %s to image %s. err=%v", task.Watermark.Text, imagePath, err.Error())
+			return fmt.Errorf("unable to add watermark %s to image %s. err=%w", task.Watermark.Text, imagePath, err)
 		}
 	}
-	imageName := strings.TrimSuffix(task.Name, filepath.Ext(task.Name))
-	err = utils.SaveImage(task.SavePath, fmt.Sprintf("%s.jpg", imageName), img)
+	dir, fileName := filepath.Split(output)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("unable to create directory %s. err=%w", dir, err)
+	}
+	if err := utils.SaveImage(dir, fileName, img, task.Format.For(task.Name), meta); err != nil {
+		return fmt.Errorf("unable to save image %s. err=%w", output, err)
+	}
+	return task.Generated(output, entry)
+}
+
+// templateVars returns the placeholders of the output: {name}, {ext} and {dir}
+func templateVars(task *utils.Task) map[string]string {
+	return map[string]string{
+		"name": strings.TrimSuffix(task.Name, filepath.Ext(task.Name)),
+		"ext":  task.Format.For(task.Name).Ext(),
+		"dir":  task.RelDir,
+	}
+}
+
+// outputPath returns where to save the watermarked task image
+func outputPath(task *utils.Task) (string, error) {
+	template := task.Template
+	if template == "" {
+		template = "{dir}/{name}{ext}"
+	}
+	rel, err := utils.ExpandTemplate(template, templateVars(task))
 	if err != nil {
-		log.Errorf("unable to save image %s. err=%s", path.Join(task.SavePath, fmt.Sprintf("%s_%dx%d.jpg", imageName, task.Resize.Width, task.Resize.Height)), err.Error())
-		hasErrors = true
+		return "", err
+	}
+	return filepath.Join(task.SavePath, filepath.FromSlash(rel)), nil
+}
+
+// checkCollisions fails when two outputs of tasks have the same path, or
+// when an output would overwrite a source
+func checkCollisions(tasks []*utils.Task) error {
+	outputs := make(map[string][]string)
+	sources := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		source := path.Join(task.Path, task.Name)
+		sources = append(sources, source)
+		output, err := outputPath(task)
+		if err != nil {
+			return err
+		}
+		outputs[output] = append(outputs[output], source)
 	}
-	return hasErrors
+	return utils.CheckCollisions(outputs, sources)
 }
 
 func AddWatermark(img image.Image, text string, c color.Gray16, size float64) (image.Image, error) {